@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strconv"
@@ -11,9 +12,15 @@ import (
 	"github.com/viswals_backend_task/controller"
 	"github.com/viswals_backend_task/pkg/encryptions"
 	"github.com/viswals_backend_task/pkg/logger"
+	"github.com/viswals_backend_task/pkg/mapping"
+	"github.com/viswals_backend_task/pkg/membroker"
+	"github.com/viswals_backend_task/pkg/models"
 	"github.com/viswals_backend_task/pkg/postgres"
 	"github.com/viswals_backend_task/pkg/rabbitmq"
 	"github.com/viswals_backend_task/pkg/redis"
+	"github.com/viswals_backend_task/pkg/redisstream"
+	"github.com/viswals_backend_task/pkg/timeparse"
+	"github.com/viswals_backend_task/pkg/validation"
 	"github.com/viswals_backend_task/repository"
 	"github.com/viswals_backend_task/usecases"
 	"go.uber.org/zap"
@@ -22,6 +29,10 @@ import (
 var (
 	DevelopmentMode   = "development"
 	defaultBufferSize = "50"
+
+	defaultImportRejectsDir = "./rejects/imports"
+	defaultBodyLimit        = 512 * 1024 * 1024
+	defaultReadTimeout      = 5 * time.Minute
 )
 
 func main() {
@@ -38,8 +49,14 @@ func main() {
 		log.Fatal("failed to initialize postgres", zap.Error(err))
 	}
 
+	// Existing user IDs are skipped, overwritten or replaced by newer versions
+	conflictPolicy, err := models.ParseConflictPolicy(os.Getenv("CONFLICT_POLICY"))
+	if err != nil {
+		log.Fatal("invalid CONFLICT_POLICY", zap.Error(err))
+	}
+
 	// Initialize repository layer
-	repo := repository.NewRepository(pg)
+	repo := repository.NewRepository(pg, repository.WithConflictPolicy(conflictPolicy))
 
 	log.Debug("repository layer initialized")
 
@@ -58,10 +75,10 @@ func main() {
 	}
 	log.Debug("cache store initialized")
 
-	// Initialize RabbitMQ message broker
-	messageBroker, err := rabbitmq.New(os.Getenv("RABBITMQ_CONNECTION_STRING"), os.Getenv("RABBITMQ_QUEUE_NAME"))
+	// Initialize the message broker selected by MESSAGE_BROKER
+	messageBroker, err := newMessageBroker(log)
 	if err != nil {
-		log.Error("error initializing rabbitmq throws error", zap.Error(err))
+		log.Error("error initializing message broker throws error", zap.Error(err))
 		return
 	}
 	log.Debug("message broker initialized")
@@ -73,52 +90,342 @@ func main() {
 	}
 
 	// Initialize consumer service
-	uc, err := usecases.NewConsumer(messageBroker, repo, cacheStore, log)
+	// Batches from COPY_THRESHOLD users on are written with COPY
+	copyThreshold, err := envInt("COPY_THRESHOLD", 1000)
 	if err != nil {
-		log.Error("error initializing consumer service throws error", zap.Error(err))
+		log.Error("error parsing COPY_THRESHOLD throws error", zap.Error(err))
 		return
 	}
 
-	log.Debug("service layer initialized")
-
-	// Fetch and validate buffer size for channel
-	channelCapacity := os.Getenv("CHANNEL_SIZE")
-	if channelCapacity == "" {
-		log.Warn("Buffer size is not set using environment variable 'CHANNEL_SIZE', using default buffer size", zap.Any("buffer_size", defaultBufferSize))
-
-		channelCapacity = defaultBufferSize
+	batchOpts, err := batchOptionsFromEnv(log)
+	if err != nil {
+		log.Error("invalid consumer batch configuration", zap.Error(err))
+		return
 	}
 
-	bufferSize, err := strconv.Atoi(channelCapacity)
+	uc, err := usecases.NewConsumer(messageBroker, repo, cacheStore, log,
+		usecases.WithCopyThreshold(copyThreshold),
+		usecases.WithBatchOptions(batchOpts),
+	)
 	if err != nil {
-		log.Error("error parsing buffer size throws error", zap.Error(err), zap.Int("buffer_size", bufferSize))
+		log.Error("error initializing consumer service throws error", zap.Error(err))
 		return
 	}
 
+	log.Debug("service layer initialized")
+
 	// create a separate go routine to handle upcoming data.
 	wg := &sync.WaitGroup{}
 	log.Info("starting consumer")
 
 	wg.Add(1)
-	go uc.Consume(wg, bufferSize)
+	go uc.Consume(wg)
 
 	// initialize user service.
 	userService := usecases.NewUserService(repo, cacheStore, log)
 
+	// initialize the import service behind POST /imports
+	importService, err := newImportService(messageBroker, log)
+	if err != nil {
+		log.Error("error initializing import service throws error", zap.Error(err))
+		return
+	}
+
+	// with the embedded broker the producer runs in this process: CSV_FILE_PATH is ingested on start
+	if brokerKind() == brokerMemory {
+		if filePath := os.Getenv("CSV_FILE_PATH"); filePath != "" {
+			if err := ingestFile(importService, filePath, log); err != nil {
+				log.Error("error ingesting CSV_FILE_PATH throws error", zap.Error(err))
+				return
+			}
+		}
+	}
+
+	readTimeout := defaultReadTimeout
+	if v := os.Getenv("HTTP_READ_TIMEOUT"); v != "" {
+		readTimeout, err = time.ParseDuration(v)
+		if err != nil {
+			log.Error("error parsing HTTP_READ_TIMEOUT throws error", zap.Error(err))
+			return
+		}
+	}
+
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		log.Warn("ADMIN_TOKEN is not set, the /admin endpoints are disabled")
+	}
+
+	bodyLimit, err := envInt("HTTP_BODY_LIMIT_BYTES", defaultBodyLimit)
+	if err != nil {
+		log.Error("error parsing HTTP_BODY_LIMIT_BYTES throws error", zap.Error(err))
+		return
+	}
+
 	// initialize controller
-	ctrl:=controller.New(userService, log, controller.WithHttpPort("8080"))
+	ctrl := controller.New(userService, log,
+		controller.WithHttpPort("8080"),
+		controller.WithImportService(importService),
+		controller.WithBodyLimit(bodyLimit),
+		controller.WithReadTimeout(readTimeout),
+		controller.WithHealthCheck(brokerKind(), messageBroker),
+		controller.WithDeadLetterService(messageBroker),
+		controller.WithAdminToken(adminToken),
+	)
 
 	log.Info("starting HTTP server", zap.String("port", ctrl.HttpPort))
-	
-	go func(){
+
+	go func() {
 		defer wg.Done()
 		if err := ctrl.Start(); err != nil {
-			log.Error("error starting HTTP server", zap.Error(err))	
+			log.Error("error starting HTTP server", zap.Error(err))
 			panic(err)
 		}
 	}()
 
 	wg.Wait()
 
+}
+
+// Message brokers selectable with MESSAGE_BROKER
+const (
+	brokerRabbitMQ = "rabbitmq"
+	brokerMemory   = "memory"
+	brokerRedis    = "redis"
+)
+
+// messageBroker is what the consumer, the health check and the dead-letter endpoints use of the broker
+type messageBroker interface {
+	usecases.MessageBroker
+	controller.HealthChecker
+	controller.DeadLetterService
+}
+
+// brokerKind reads MESSAGE_BROKER, defaulting to RabbitMQ
+func brokerKind() string {
+	if v := strings.ToLower(os.Getenv("MESSAGE_BROKER")); v != "" {
+		return v
+	}
+	return brokerRabbitMQ
+}
+
+// newMessageBroker connects to the broker selected by MESSAGE_BROKER. The in-process broker
+// serves the producer pipeline of this process only; its messages are lost on exit unless
+// MEMORY_BROKER_FILE is set.
+func newMessageBroker(log *zap.Logger) (messageBroker, error) {
+	queue := os.Getenv("RABBITMQ_QUEUE_NAME")
+
+	switch kind := brokerKind(); kind {
+	case brokerRabbitMQ:
+		// the topology must match the producer's, so both read the same RABBITMQ_* settings
+		brokerOpts, err := rabbitmq.OptionsFromEnv(queue)
+		if err != nil {
+			return nil, err
+		}
+		// the client reconnects on its own when the broker restarts; its state is reported on /health
+		rmq, err := rabbitmq.New(os.Getenv("RABBITMQ_CONNECTION_STRING"), queue, append(brokerOpts, rabbitmq.WithLogger(log))...)
+		if err != nil {
+			return nil, err
+		}
+		return rmq, nil
+
+	case brokerMemory:
+		brokerOpts, err := membroker.OptionsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if queue == "" {
+			queue = "users"
+		}
+		mem, err := membroker.New(queue, append(brokerOpts, membroker.WithLogger(log))...)
+		if err != nil {
+			return nil, err
+		}
+		return mem, nil
+
+	case brokerRedis:
+		rs, err := newRedisStream(queue, log)
+		if err != nil {
+			return nil, err
+		}
+		return rs, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported MESSAGE_BROKER %q", kind)
+	}
+}
+
+// newRedisStream uses a Redis stream as the queue, on REDIS_STREAM_CONNECTION_STRING or else the cache's Redis
+func newRedisStream(queue string, log *zap.Logger) (*redisstream.Broker, error) {
+	uri := os.Getenv("REDIS_STREAM_CONNECTION_STRING")
+	if uri == "" {
+		uri = os.Getenv("REDIS_CONNECTION_STRING")
+	}
+	if queue == "" {
+		queue = "users"
+	}
+
+	brokerOpts, err := redisstream.OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return redisstream.New(uri, queue, append(brokerOpts, redisstream.WithLogger(log))...)
+}
 
+// ingestFile submits a file to the import service, as the producer would publish it
+func ingestFile(importService *usecases.ImportService, filePath string, log *zap.Logger) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	job, err := importService.Submit(context.Background(), filePath, file)
+	if err != nil {
+		return err
+	}
+	log.Info("ingesting file through the embedded broker", zap.String("path", filePath), zap.String("import_id", job.ID))
+	return nil
+}
+
+// newImportService configures uploads with the same column aliases, timestamp formats and
+// validation rules as cmd/producer
+func newImportService(messageBroker usecases.MessageBroker, log *zap.Logger) (*usecases.ImportService, error) {
+	aliases := mapping.DefaultAliases()
+	if aliasFile := os.Getenv("COLUMN_ALIASES_FILE"); aliasFile != "" {
+		var err error
+		if aliases, err = mapping.LoadAliases(aliasFile); err != nil {
+			return nil, err
+		}
+	}
+
+	timestamps, err := timeparse.FromEnv(mapping.FieldCreatedAt, mapping.FieldDeletedAt, mapping.FieldMergedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := validation.DefaultConfig()
+	if rulesFile := os.Getenv("VALIDATION_RULES_FILE"); rulesFile != "" {
+		if rules, err = validation.LoadConfig(rulesFile); err != nil {
+			return nil, err
+		}
+	}
+	validator, err := validation.New(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize, err := envInt("IMPORT_BATCH_SIZE", 1)
+	if err != nil {
+		return nil, err
+	}
+	maxConcurrent, err := envInt("IMPORT_MAX_CONCURRENT", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	maxFinished, err := envInt("IMPORT_MAX_FINISHED", 0)
+	if err != nil {
+		return nil, err
+	}
+	var retention time.Duration
+	if v := os.Getenv("IMPORT_RETENTION"); v != "" {
+		if retention, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("IMPORT_RETENTION: %w", err)
+		}
+	}
+
+	rejectsDir := os.Getenv("IMPORT_REJECTS_DIR")
+	if rejectsDir == "" {
+		rejectsDir = defaultImportRejectsDir
+	}
+
+	opts := []usecases.ImportOption{
+		usecases.WithImportAliases(aliases),
+		usecases.WithImportRejectsDir(rejectsDir),
+		usecases.WithMaxConcurrentImports(maxConcurrent),
+		usecases.WithImportRetention(retention),
+		usecases.WithMaxFinishedImports(maxFinished),
+		usecases.WithImportProducerOptions(
+			usecases.WithTimestampFormats(timestamps),
+			usecases.WithValidator(validator),
+			usecases.WithBatchSize(batchSize),
+		),
+	}
+	if dir := os.Getenv("IMPORT_SPOOL_DIR"); dir != "" {
+		opts = append(opts, usecases.WithImportSpoolDir(dir))
+	}
+
+	return usecases.NewImportService(messageBroker, log, opts...), nil
+}
+
+// batchOptionsFromEnv reads the consumer batching settings; unset values keep their defaults
+func batchOptionsFromEnv(log *zap.Logger) (usecases.BatchOptions, error) {
+	opts := usecases.DefaultBatchOptions()
+
+	// Fetch and validate buffer size for channel
+	channelCapacity := os.Getenv("CHANNEL_SIZE")
+	if channelCapacity == "" {
+		log.Warn("Buffer size is not set using environment variable 'CHANNEL_SIZE', using default buffer size", zap.Any("buffer_size", defaultBufferSize))
+
+		channelCapacity = defaultBufferSize
+	}
+	bufferSize, err := strconv.Atoi(channelCapacity)
+	if err != nil {
+		return opts, fmt.Errorf("CHANNEL_SIZE: %w", err)
+	}
+	opts.ChannelSize = bufferSize
+
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"CONSUMER_BATCH_SIZE", &opts.Size},
+		{"CONSUMER_BATCH_MAX_BYTES", &opts.MaxBytes},
+		{"CONSUMER_WORKERS", &opts.Workers},
+		{"CONSUMER_MIN_BATCH_SIZE", &opts.MinSize},
+		{"CONSUMER_MAX_BATCH_SIZE", &opts.MaxSize},
+	}
+	for _, v := range ints {
+		if *v.dst, err = envInt(v.name, *v.dst); err != nil {
+			return opts, err
+		}
+	}
+
+	durations := []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"CONSUMER_FLUSH_INTERVAL", &opts.FlushInterval},
+		{"CONSUMER_TARGET_LATENCY", &opts.TargetLatency},
+	}
+	for _, v := range durations {
+		if env := os.Getenv(v.name); env != "" {
+			if *v.dst, err = time.ParseDuration(env); err != nil {
+				return opts, fmt.Errorf("%s: %w", v.name, err)
+			}
+		}
+	}
+
+	if env := os.Getenv("CONSUMER_ADAPTIVE_BATCH"); env != "" {
+		if opts.Adaptive, err = strconv.ParseBool(env); err != nil {
+			return opts, fmt.Errorf("CONSUMER_ADAPTIVE_BATCH: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// envInt reads an optional non-negative integer environment variable
+func envInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%s must not be negative", name)
+	}
+	return n, nil
 }
@@ -1,70 +1,442 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/viswals_backend_task/pkg/csvutils"
+	"github.com/viswals_backend_task/pkg/checkpoint"
 	"github.com/viswals_backend_task/pkg/logger"
+	"github.com/viswals_backend_task/pkg/mapping"
+	"github.com/viswals_backend_task/pkg/membroker"
 	"github.com/viswals_backend_task/pkg/rabbitmq"
+	"github.com/viswals_backend_task/pkg/recordsource"
+	"github.com/viswals_backend_task/pkg/redisstream"
+	"github.com/viswals_backend_task/pkg/rejects"
+	"github.com/viswals_backend_task/pkg/timeparse"
+	"github.com/viswals_backend_task/pkg/validation"
 	"github.com/viswals_backend_task/usecases"
 	"go.uber.org/zap"
 )
+
+// Exit codes tell an orchestrator whether the input was fully processed
+const (
+	exitOK          = 0 // the whole input was read
+	exitError       = 1 // the run failed; see the logs
+	exitInterrupted = 3 // stopped by a signal; the next run resumes from the checkpoint
+)
+
 var (
-	DevelopmentMode = "development"
+	DevelopmentMode       = "development"
+	defaultCheckpointFile = "./checkpoints/producer.json"
+	defaultRejectsDir     = "./rejects"
+	defaultReportsDir     = "./reports"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run executes one producer run and returns the process exit code
+func run() int {
 	// Initialize the logger
 	log, err := logger.Init(os.Stdout, strings.ToLower(os.Getenv("ENVIRONMENT")) == DevelopmentMode)
 	if err != nil {
 		fmt.Println("Logger initialization failed:", err)
-		return
+		return exitError
+	}
+
+	// Open the input file; the format is taken from INPUT_FORMAT or the file extension
+	filePath := os.Getenv("CSV_FILE_PATH")
+	sourceOpts, err := inputOptions()
+	if err != nil {
+		log.Error("Invalid input configuration", zap.Error(err))
+		return exitError
 	}
 
-	// Open the CSV file as a reader
-	csvReader, err := csvutils.OpenFile(os.Getenv("CSV_FILE_PATH"))
+	source, err := recordsource.Open(filePath, sourceOpts)
 	if err != nil {
-		log.Error("Unable to open the CSV file", zap.Error(err))
-		return
+		log.Error("Unable to open the input file", zap.Error(err), zap.String("path", filePath))
+		return exitError
 	}
+	defer source.Close()
 
+	// Resolve user fields by header name, optionally extended with an alias file
+	aliases := mapping.DefaultAliases()
+	if aliasFile := os.Getenv("COLUMN_ALIASES_FILE"); aliasFile != "" {
+		aliases, err = mapping.LoadAliases(aliasFile)
+		if err != nil {
+			log.Error("Unable to load the column alias file", zap.Error(err))
+			return exitError
+		}
+	}
+
+	columns, err := mapping.Resolve(source.Header(), aliases)
+	if err != nil {
+		log.Error("Unable to map input columns", zap.Error(err))
+		return exitError
+	}
+
+	// Fingerprint the file so a checkpoint is only resumed for identical content
+	fingerprint, err := checkpoint.Fingerprint(filePath)
+	if err != nil {
+		log.Error("Unable to fingerprint the input file", zap.Error(err))
+		return exitError
+	}
+
+	checkpointFile := os.Getenv("CHECKPOINT_FILE")
+	if checkpointFile == "" {
+		checkpointFile = defaultCheckpointFile
+	}
+
+	// FORCE_FULL_RUN ignores any stored checkpoint and republishes the whole file
+	forceRerun := false
+	if v := os.Getenv("FORCE_FULL_RUN"); v != "" {
+		forceRerun, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Error("Invalid FORCE_FULL_RUN value", zap.Error(err), zap.String("value", v))
+			return exitError
+		}
+	}
+
+	// Timestamp columns are parsed as TIMESTAMP_FORMAT (auto by default), overridable per column
+	timestamps, err := timeparse.FromEnv(mapping.FieldCreatedAt, mapping.FieldDeletedAt, mapping.FieldMergedAt)
+	if err != nil {
+		log.Error("Invalid timestamp configuration", zap.Error(err))
+		return exitError
+	}
+
+	// Business validation rules, from VALIDATION_RULES_FILE (YAML or JSON) or the built-in defaults
+	rules := validation.DefaultConfig()
+	if rulesFile := os.Getenv("VALIDATION_RULES_FILE"); rulesFile != "" {
+		rules, err = validation.LoadConfig(rulesFile)
+		if err != nil {
+			log.Error("Unable to load the validation rules file", zap.Error(err))
+			return exitError
+		}
+	}
+
+	validator, err := validation.New(rules)
+	if err != nil {
+		log.Error("Invalid validation rules", zap.Error(err))
+		return exitError
+	}
+
+	// DRY_RUN parses and validates the whole file without connecting to RabbitMQ
+	dryRun := false
+	if v := os.Getenv("DRY_RUN"); v != "" {
+		dryRun, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Error("Invalid DRY_RUN value", zap.Error(err), zap.String("value", v))
+			return exitError
+		}
+	}
+
+	// Rejected rows go to REJECTS_FILE (.csv or .jsonl), defaulting to <input name>.rejects.csv. A dry run
+	// writes them to DRY_RUN_REJECTS_FILE instead, next to its report, so it never replaces the rejects of a real run.
+	rejectsFile := os.Getenv("REJECTS_FILE")
+	if rejectsFile == "" {
+		rejectsFile = filepath.Join(defaultRejectsDir, filepath.Base(filePath)+".rejects.csv")
+	}
+	if dryRun {
+		rejectsFile = os.Getenv("DRY_RUN_REJECTS_FILE")
+		if rejectsFile == "" {
+			rejectsFile = filepath.Join(defaultReportsDir, filepath.Base(filePath)+".dryrun.rejects.csv")
+		}
+	}
+
+	// the producer empties the file on a fresh run, or drops the rejects past the checkpoint it resumes from
+	rejectWriter, err := rejects.Open(rejectsFile, source.Header(), false)
+	if err != nil {
+		log.Error("Unable to open the rejects file", zap.Error(err), zap.String("path", rejectsFile))
+		return exitError
+	}
+	defer rejectWriter.Close()
+
+	// BATCH_SIZE_PRODUCER users are packed into one message, capped at BATCH_MAX_BYTES_PRODUCER encoded bytes
+	batchSize, err := envInt("BATCH_SIZE_PRODUCER")
+	if err != nil {
+		log.Error("Invalid BATCH_SIZE_PRODUCER value", zap.Error(err))
+		return exitError
+	}
+	maxBatchBytes, err := envInt("BATCH_MAX_BYTES_PRODUCER")
+	if err != nil {
+		log.Error("Invalid BATCH_MAX_BYTES_PRODUCER value", zap.Error(err))
+		return exitError
+	}
+
+	publishTimeout, err := envDuration("PUBLISH_TIMEOUT")
+	if err != nil {
+		log.Error("Invalid PUBLISH_TIMEOUT value", zap.Error(err))
+		return exitError
+	}
+
+	var messageBroker usecases.MessageBroker
+	queue := os.Getenv("RABBITMQ_QUEUE_NAME")
+	if !dryRun {
+		// MESSAGE_BROKER selects RabbitMQ (the default), a Redis stream or the in-process broker
+		switch kind := strings.ToLower(os.Getenv("MESSAGE_BROKER")); kind {
+		case "", "rabbitmq":
+			rmq, code := newRabbitMQ(queue, log)
+			if rmq == nil {
+				return code
+			}
+			defer rmq.Close()
+			messageBroker = rmq
+
+		case "memory":
+			// no consumer shares this process, so the messages are only useful once journaled
+			// to MEMORY_BROKER_FILE for a consumer started later on the same file
+			if os.Getenv("MEMORY_BROKER_FILE") == "" {
+				log.Error("MESSAGE_BROKER=memory requires MEMORY_BROKER_FILE; to run producer and consumer in one process, set CSV_FILE_PATH on the consumer instead")
+				return exitError
+			}
+			brokerOpts, err := membroker.OptionsFromEnv()
+			if err != nil {
+				log.Error("Invalid memory broker configuration", zap.Error(err))
+				return exitError
+			}
+			if queue == "" {
+				queue = "users"
+			}
+			mem, err := membroker.New(queue, append(brokerOpts, membroker.WithLogger(log))...)
+			if err != nil {
+				log.Error("Failed to initialize the memory broker", zap.Error(err))
+				return exitError
+			}
+			defer mem.Close()
+			messageBroker = mem
+
+		case "redis":
+			rs, err := newRedisStream(queue, log)
+			if err != nil {
+				log.Error("Failed to initialize the Redis stream", zap.Error(err))
+				return exitError
+			}
+			defer rs.Close()
+			messageBroker = rs
+
+		default:
+			log.Error("Unsupported MESSAGE_BROKER", zap.String("value", kind))
+			return exitError
+		}
+	}
+
+	// Initialize the producer service
+	producer := usecases.NewProducer(source, messageBroker, log,
+		usecases.WithColumnMapping(columns),
+		usecases.WithRejects(rejectWriter),
+		usecases.WithValidator(validator),
+		usecases.WithTimestampFormats(timestamps),
+		usecases.WithCheckpoint(checkpoint.NewStore(checkpointFile), filePath, fingerprint),
+		usecases.WithForceRerun(forceRerun),
+		usecases.WithDryRun(dryRun),
+		usecases.WithBatchSize(batchSize),
+		usecases.WithMaxBatchBytes(maxBatchBytes),
+		usecases.WithPublishTimeout(publishTimeout),
+	)
+
+	log.Info("Initializing the producer service")
+
+	// SIGINT/SIGTERM stop reading; in-flight publishes are drained and the checkpoint saved before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start the producer service
+	err = producer.Start(ctx)
+	if errors.Is(err, usecases.ErrInterrupted) {
+		log.Warn("Producer interrupted before reaching the end of the input", zap.Any("summary", producer.Summary()))
+		return exitInterrupted
+	}
+	if err != nil {
+		log.Error("Producer service failed to start", zap.Error(err), zap.String("queueName", queue))
+		return exitError
+	}
+
+	if dryRun {
+		reportFile := os.Getenv("DRY_RUN_REPORT_FILE")
+		if reportFile == "" {
+			reportFile = filepath.Join(defaultReportsDir, filepath.Base(filePath)+".dryrun.json")
+		}
+		if err := writeReport(producer.Report(), reportFile); err != nil {
+			log.Error("Unable to write the dry-run report", zap.Error(err), zap.String("path", reportFile))
+			return exitError
+		}
+		log.Info("Dry run completed.", zap.String("report", reportFile), zap.String("rejects", rejectsFile))
+		return exitOK
+	}
+
+	// rows that could not be published hold back the checkpoint and are retried by the next run
+	if summary := producer.Summary(); summary.Failed > 0 {
+		log.Error("Producer finished with unpublished rows", zap.Any("summary", summary))
+		return exitError
+	}
+
+	log.Info("Producer operation completed successfully.", zap.Any("summary", producer.Summary()))
+	return exitOK
+}
+
+// newRabbitMQ connects to RABBITMQ_CONNECTION_STRING, returning the exit code when it cannot
+func newRabbitMQ(queue string, log *zap.Logger) (*rabbitmq.RabbitMQ, int) {
 	// Establish a connection with the message broker
 	connStr := os.Getenv("RABBITMQ_CONNECTION_STRING")
 	if connStr == "" {
 		log.Error("Missing RabbitMQ connection string. Please set the RABBITMQ_CONNECTION_STRING environment variable.")
-		return
+		return nil, exitError
 	}
 
-	queue := os.Getenv("RABBITMQ_QUEUE_NAME")
 	if queue == "" {
 		log.Error("Missing RabbitMQ queue name. Please set the RABBITMQ_QUEUE_NAME environment variable.")
-		return
+		return nil, exitError
+	}
+
+	// Publishes wait for broker confirms and are retried with backoff per PUBLISH_* settings
+	retry, err := retryPolicy()
+	if err != nil {
+		log.Error("Invalid publish retry configuration", zap.Error(err))
+		return nil, exitError
+	}
+
+	// Exchange, queue type and arguments come from RABBITMQ_*; messages are persistent by default
+	brokerOpts, err := rabbitmq.OptionsFromEnv(queue)
+	if err != nil {
+		log.Error("Invalid RabbitMQ topology configuration", zap.Error(err))
+		return nil, exitError
 	}
+	brokerOpts = append(brokerOpts, rabbitmq.WithRetryPolicy(retry), rabbitmq.WithLogger(log))
 
 	// Initialize the message broker
-	messageBroker, err := rabbitmq.New(connStr, queue)
+	rmq, err := rabbitmq.New(connStr, queue, brokerOpts...)
 	if err != nil {
 		log.Error("Failed to initialize RabbitMQ queue", zap.Error(err), zap.String("queueName", queue))
-		return
+		return nil, exitError
 	}
-	defer messageBroker.Close()
+	return rmq, exitOK
+}
 
-	// Initialize the producer service
-	producer := usecases.NewProducer(csvReader, messageBroker, log)
+// newRedisStream uses a Redis stream as the queue, on REDIS_STREAM_CONNECTION_STRING or else REDIS_CONNECTION_STRING
+func newRedisStream(queue string, log *zap.Logger) (*redisstream.Broker, error) {
+	uri := os.Getenv("REDIS_STREAM_CONNECTION_STRING")
+	if uri == "" {
+		uri = os.Getenv("REDIS_CONNECTION_STRING")
+	}
+	if queue == "" {
+		queue = "users"
+	}
 
-	log.Info("Initializing the producer service")
+	brokerOpts, err := redisstream.OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return redisstream.New(uri, queue, append(brokerOpts, redisstream.WithLogger(log))...)
+}
 
-	// Start the producer service		
-	err=producer.Start()
-	if err!=nil{
-		log.Error("Producer service failed to start", zap.Error(err), zap.String("queueName", queue))
-		return
+// envInt reads an optional integer environment variable, returning 0 when it is unset
+func envInt(name string) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
 	}
+	if n < 0 {
+		return 0, fmt.Errorf("%s must not be negative", name)
+	}
+	return n, nil
+}
+
+// envDuration reads an optional duration environment variable, returning 0 when it is unset
+func envDuration(name string) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// retryPolicy reads PUBLISH_MAX_ATTEMPTS, PUBLISH_RETRY_BACKOFF and PUBLISH_RETRY_MAX_BACKOFF
+// on top of the default policy
+func retryPolicy() (rabbitmq.RetryPolicy, error) {
+	policy := rabbitmq.DefaultRetryPolicy()
+
+	attempts, err := envInt("PUBLISH_MAX_ATTEMPTS")
+	if err != nil {
+		return policy, err
+	}
+	if attempts > 0 {
+		policy.MaxAttempts = attempts
+	}
+
+	if d, err := envDuration("PUBLISH_RETRY_BACKOFF"); err != nil {
+		return policy, err
+	} else if d > 0 {
+		policy.InitialBackoff = d
+	}
+
+	if d, err := envDuration("PUBLISH_RETRY_MAX_BACKOFF"); err != nil {
+		return policy, err
+	} else if d > 0 {
+		policy.MaxBackoff = d
+	}
+	return policy, nil
+}
+
+// inputOptions reads the optional INPUT_FORMAT, INPUT_DELIMITER, INPUT_SHEET and INPUT_XLSX_MAX_BYTES settings
+func inputOptions() (recordsource.Options, error) {
+	var opts recordsource.Options
+
+	if v := os.Getenv("INPUT_FORMAT"); v != "" {
+		format, err := recordsource.ParseFormat(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Format = format
+	}
+
+	if v := os.Getenv("INPUT_DELIMITER"); v != "" {
+		if v == `\t` {
+			v = "\t"
+		}
+		delimiter := []rune(v)
+		if len(delimiter) != 1 {
+			return opts, fmt.Errorf("INPUT_DELIMITER must be a single character, got %q", v)
+		}
+		opts.Delimiter = delimiter[0]
+	}
+
+	opts.Sheet = os.Getenv("INPUT_SHEET")
 
-	log.Info("Producer operation completed successfully.")
+	maxXLSX, err := envInt("INPUT_XLSX_MAX_BYTES")
+	if err != nil {
+		return opts, fmt.Errorf("INPUT_XLSX_MAX_BYTES: %w", err)
+	}
+	opts.MaxXLSXBytes = int64(maxXLSX)
+	return opts, nil
+}
 
+// writeReport prints the dry-run report to stdout and saves it as JSON
+func writeReport(report usecases.IngestionReport, path string) error {
+	if err := report.WriteText(os.Stdout); err != nil {
+		return err
+	}
 
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
 
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
 }
@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// errBodyTooLarge is returned once a request body grows past the body limit
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitBody reads streamed request bodies into memory up to the body limit, since fasthttp does not
+// enforce the limit on streamed bodies. Uploads to /imports are left to read their own stream.
+func (c *Controller) limitBody(ctx *fiber.Ctx) error {
+	stream := ctx.Context().RequestBodyStream()
+	if stream == nil || (ctx.Method() == fiber.MethodPost && ctx.Path() == "/imports") {
+		return ctx.Next()
+	}
+
+	body, err := io.ReadAll(c.limitReader(stream))
+	if err != nil {
+		// the rest of the body is left unread, so the connection cannot be reused
+		ctx.Context().SetConnectionClose()
+		if errors.Is(err, errBodyTooLarge) {
+			return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"message": "request body too large"})
+		}
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "failed to read request body"})
+	}
+	ctx.Request().SetBody(body)
+	return ctx.Next()
+}
+
+// limitReader fails with errBodyTooLarge once more than the body limit is read from r
+func (c *Controller) limitReader(r io.Reader) io.Reader {
+	return &limitedReader{r: r, remaining: int64(c.BodyLimit)}
+}
+
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
@@ -10,14 +10,21 @@ import (
 )
 
 var (
-	defaultTimeout  = 5 * time.Second
-	defaultHttpPort = "8080"
+	defaultTimeout   = 5 * time.Second
+	defaultHttpPort  = "8080"
+	defaultBodyLimit = 4 * 1024 * 1024
 )
 
 type Controller struct {
-	UserService UserService
-	logger      *zap.Logger
-	HttpPort    string
+	UserService       UserService
+	ImportService     ImportService
+	DeadLetterService DeadLetterService
+	logger            *zap.Logger
+	HttpPort          string
+	BodyLimit         int
+	ReadTimeout       time.Duration
+	HealthChecks      map[string]HealthChecker
+	AdminToken        string
 }
 
 // Option defines functional options for the controller
@@ -30,12 +37,64 @@ func WithHttpPort(port string) Option {
 	}
 }
 
+// WithImportService enables the /imports endpoints backed by the given service
+func WithImportService(importService ImportService) Option {
+	return func(c *Controller) {
+		c.ImportService = importService
+	}
+}
+
+// WithDeadLetterService provides the service behind the /admin/dlq endpoints, which are only served
+// together with an admin token
+func WithDeadLetterService(deadLetterService DeadLetterService) Option {
+	return func(c *Controller) {
+		c.DeadLetterService = deadLetterService
+	}
+}
+
+// WithAdminToken enables the /admin endpoints behind "Authorization: Bearer <token>"
+func WithAdminToken(token string) Option {
+	return func(c *Controller) {
+		c.AdminToken = token
+	}
+}
+
+// WithBodyLimit sets the maximum request body size in bytes, which bounds the size of uploaded imports
+func WithBodyLimit(limit int) Option {
+	return func(c *Controller) {
+		if limit > 0 {
+			c.BodyLimit = limit
+		}
+	}
+}
+
+// WithReadTimeout sets how long the server waits for a full request, which must cover the upload time of imports
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *Controller) {
+		if timeout > 0 {
+			c.ReadTimeout = timeout
+		}
+	}
+}
+
+// WithHealthCheck reports the named dependency on the /health endpoint
+func WithHealthCheck(name string, checker HealthChecker) Option {
+	return func(c *Controller) {
+		if c.HealthChecks == nil {
+			c.HealthChecks = make(map[string]HealthChecker)
+		}
+		c.HealthChecks[name] = checker
+	}
+}
+
 // New creates a new Controller with optional configurations
 func New(userService UserService,logger *zap.Logger, opts ...Option) *Controller {
 	ctrl := &Controller{
 		UserService: userService,
 		logger:      logger,
 		HttpPort:    defaultHttpPort,
+		BodyLimit:   defaultBodyLimit,
+		ReadTimeout: defaultTimeout,
 	}
 	for _, opt := range opts {
 		opt(ctrl)
@@ -52,11 +111,20 @@ func (c *Controller) sendResponse(ctx *fiber.Ctx, statusCode int, message string
 	})
 }
 
+// config returns the server settings. Request bodies are streamed so uploads are spooled to disk as
+// they arrive instead of being buffered in memory; limitBody enforces the body limit on other routes.
+func (c *Controller) config() fiber.Config {
+	return fiber.Config{
+		ReadTimeout:                  c.ReadTimeout,
+		BodyLimit:                    c.BodyLimit,
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
+	}
+}
+
 // Start initializes and starts the Fiber server
 func (c *Controller) Start() error {
-	app := fiber.New(fiber.Config{
-		ReadTimeout: defaultTimeout,
-	})
+	app := fiber.New(c.config())
 
 	c.registerRoutes(app)
 
@@ -76,14 +144,51 @@ func (c *Controller) Ping(ctx *fiber.Ctx) error {
 	})
 }
 
+// Health reports every registered dependency, answering 503 if any of them is unhealthy
+func (c *Controller) Health(ctx *fiber.Ctx) error {
+	status := fiber.StatusOK
+	checks := make(map[string]string, len(c.HealthChecks))
+	for name, checker := range c.HealthChecks {
+		if err := checker.Health(); err != nil {
+			status = fiber.StatusServiceUnavailable
+			checks[name] = err.Error()
+			continue
+		}
+		checks[name] = "ok"
+	}
+
+	message := "ok"
+	if status != fiber.StatusOK {
+		message = "unavailable"
+	}
+	return ctx.Status(status).JSON(fiber.Map{
+		"status": message,
+		"checks": checks,
+	})
+}
+
 // registerRoutes sets up routes for HTTP endpoints
 func (c *Controller) registerRoutes(app *fiber.App) {
+	app.Use(c.limitBody)
 	app.Get("/ping", c.Ping)
+	app.Get("/health", c.Health)
 	app.Get("/users/sse", c.GetAllUsersSSE)
 	app.Get("/users", c.GetAllUsers)
 	app.Get("/users/:id", c.GetUser)
 	app.Post("/users", c.CreateUser)
 	app.Delete("/users/:id", c.DeleteUser)
+	if c.ImportService != nil {
+		app.Post("/imports", c.CreateImport)
+		app.Get("/imports/:id", c.GetImport)
+	}
+	// the admin endpoints can replay and purge messages, so they are never served unauthenticated
+	if c.DeadLetterService != nil && c.AdminToken != "" {
+		admin := app.Group("/admin", c.requireAdmin)
+		admin.Get("/dlq", c.ListDeadLetters)
+		admin.Get("/dlq/:id", c.PeekDeadLetter)
+		admin.Post("/dlq/replay", c.ReplayDeadLetters)
+		admin.Delete("/dlq", c.PurgeDeadLetters)
+	}
 	app.Static("/static", "./web")
 }
 
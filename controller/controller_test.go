@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,6 +18,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/viswals_backend_task/pkg/models"
 	"github.com/viswals_backend_task/pkg/postgres"
+	"go.uber.org/zap"
 )
 
 // Mock UserService
@@ -104,3 +110,286 @@ func TestDeleteUser(t *testing.T) {
 
 	require.Equal(t, fiber.StatusNoContent, resp.StatusCode)
 }
+
+// Mock ImportService
+
+type MockImportService struct {
+	mock.Mock
+}
+
+func (m *MockImportService) Submit(ctx context.Context, fileName string, r io.Reader) (*models.ImportJob, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	args := m.Called(ctx, fileName, string(data))
+	job, _ := args.Get(0).(*models.ImportJob)
+	return job, args.Error(1)
+}
+
+func (m *MockImportService) Get(ctx context.Context, id string) (*models.ImportJob, error) {
+	args := m.Called(ctx, id)
+	job, _ := args.Get(0).(*models.ImportJob)
+	return job, args.Error(1)
+}
+
+func setupImportController(opts ...Option) (*MockImportService, *fiber.App) {
+	mockService := new(MockImportService)
+	ctrl := New(new(MockUserService), zap.NewNop(), append(opts, WithImportService(mockService))...)
+	app := fiber.New(ctrl.config())
+	ctrl.registerRoutes(app)
+	return mockService, app
+}
+
+func multipartUpload(t *testing.T, field, fileName, content string) *http.Request {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile(field, fileName)
+	require.NoError(t, err)
+	_, err = part.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/imports", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestCreateImport(t *testing.T) {
+	mockService, app := setupImportController()
+
+	content := "id,first_name,last_name,email_address,created_at\n1,John,Doe,john@example.com,1612137600\n"
+	job := &models.ImportJob{ID: "abc", FileName: "users.csv", Status: models.ImportQueued}
+	mockService.On("Submit", mock.Anything, "users.csv", content).Return(job, nil)
+
+	resp, err := app.Test(multipartUpload(t, "file", "users.csv", content), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+
+	var got models.ImportJob
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	require.Equal(t, "abc", got.ID)
+}
+
+func TestCreateImport_Invalid(t *testing.T) {
+	mockService, app := setupImportController()
+
+	resp, err := app.Test(multipartUpload(t, "upload", "users.csv", "id\n"), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+	mockService.On("Submit", mock.Anything, "users.pdf", mock.Anything).
+		Return(nil, fmt.Errorf("%w: unsupported input format", models.ErrInvalidImport))
+
+	resp, err = app.Test(multipartUpload(t, "file", "users.pdf", "id\n"), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+}
+
+func TestCreateImport_TooLarge(t *testing.T) {
+	_, app := setupImportController(WithBodyLimit(1024))
+
+	resp, err := app.Test(multipartUpload(t, "file", "users.csv", strings.Repeat("1,John,Doe,john@example.com\n", 100)), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestBodyLimit(t *testing.T) {
+	mockService := new(MockUserService)
+	ctrl := New(mockService, zap.NewNop(), WithBodyLimit(64))
+	app := fiber.New(ctrl.config())
+	ctrl.registerRoutes(app)
+
+	// streamed bodies of other routes are still bounded by the body limit
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"first_name":"`+strings.Repeat("a", 100)+`"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusRequestEntityTooLarge, resp.StatusCode)
+	mockService.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything)
+}
+
+func TestGetImport(t *testing.T) {
+	mockService, app := setupImportController()
+
+	mockService.On("Get", mock.Anything, "abc").Return(&models.ImportJob{ID: "abc", Status: models.ImportRunning, RowsRead: 10}, nil)
+	mockService.On("Get", mock.Anything, "missing").Return(nil, models.ErrImportNotFound)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/imports/abc", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/imports/missing", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
+
+type healthFunc func() error
+
+func (f healthFunc) Health() error { return f() }
+
+func TestHealth(t *testing.T) {
+	healthy := true
+	ctrl := New(new(MockUserService), zap.NewNop(), WithHealthCheck("rabbitmq", healthFunc(func() error {
+		if healthy {
+			return nil
+		}
+		return errors.New("rabbitmq not connected")
+	})))
+	app := fiber.New()
+	ctrl.registerRoutes(app)
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/health", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	healthy = false
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/health", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, "unavailable", body.Status)
+	require.Equal(t, "rabbitmq not connected", body.Checks["rabbitmq"])
+}
+
+// Mock DeadLetterService
+type MockDeadLetterService struct {
+	mock.Mock
+}
+
+func (m *MockDeadLetterService) ListDeadLetters(ctx context.Context, limit int) ([]models.DeadLetter, error) {
+	args := m.Called(ctx, limit)
+	return args.Get(0).([]models.DeadLetter), args.Error(1)
+}
+
+func (m *MockDeadLetterService) PeekDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error) {
+	args := m.Called(ctx, id)
+	letter, _ := args.Get(0).(*models.DeadLetter)
+	return letter, args.Error(1)
+}
+
+func (m *MockDeadLetterService) ReplayDeadLetters(ctx context.Context, ids []string) (int, error) {
+	args := m.Called(ctx, ids)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockDeadLetterService) PurgeDeadLetters(ctx context.Context) (int, error) {
+	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
+func setupDeadLetterController(opts ...Option) (*MockDeadLetterService, *fiber.App) {
+	mockService := new(MockDeadLetterService)
+	opts = append([]Option{WithAdminToken("secret")}, opts...)
+	ctrl := New(new(MockUserService), zap.NewNop(), append(opts, WithDeadLetterService(mockService))...)
+	app := fiber.New()
+	ctrl.registerRoutes(app)
+	return mockService, app
+}
+
+// adminRequest returns a request carrying the admin token of setupDeadLetterController
+func adminRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
+
+func TestListDeadLetters(t *testing.T) {
+	mockService, app := setupDeadLetterController()
+
+	letters := []models.DeadLetter{{ID: "m1", Reason: "invalid character", Class: "*json.SyntaxError", Attempts: 1}}
+	mockService.On("ListDeadLetters", mock.Anything, 10).Return(letters, nil)
+
+	resp, err := app.Test(adminRequest(http.MethodGet, "/admin/dlq?limit=10", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Data []models.DeadLetter `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, letters, body.Data)
+
+	resp, err = app.Test(adminRequest(http.MethodGet, "/admin/dlq?limit=-1", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+}
+
+func TestPeekDeadLetter(t *testing.T) {
+	mockService, app := setupDeadLetterController()
+
+	mockService.On("PeekDeadLetter", mock.Anything, "m1").Return(&models.DeadLetter{ID: "m1", Body: "{bad"}, nil)
+	mockService.On("PeekDeadLetter", mock.Anything, "missing").Return(nil, models.ErrDeadLetterNotFound)
+
+	resp, err := app.Test(adminRequest(http.MethodGet, "/admin/dlq/m1", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	resp, err = app.Test(adminRequest(http.MethodGet, "/admin/dlq/missing", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	mockService, app := setupDeadLetterController()
+
+	mockService.On("ReplayDeadLetters", mock.Anything, []string{"m1", "m2"}).Return(2, nil)
+	mockService.On("ReplayDeadLetters", mock.Anything, []string(nil)).Return(5, nil)
+
+	req := adminRequest(http.MethodPost, "/admin/dlq/replay", bytes.NewBufferString(`{"ids":["m1","m2"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	// no body replays the whole queue
+	resp, err = app.Test(adminRequest(http.MethodPost, "/admin/dlq/replay", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Data map[string]int `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, 5, body.Data["replayed"])
+	mockService.AssertExpectations(t)
+}
+
+func TestPurgeDeadLetters_AdminToken(t *testing.T) {
+	mockService, app := setupDeadLetterController()
+
+	mockService.On("PurgeDeadLetters", mock.Anything).Return(3, nil).Once()
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodDelete, "/admin/dlq", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusUnauthorized, resp.StatusCode)
+
+	req := httptest.NewRequest(http.MethodDelete, "/admin/dlq", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = app.Test(req, -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, resp.StatusCode)
+	mockService.AssertExpectations(t)
+}
+
+func TestDeadLetters_NoAdminToken(t *testing.T) {
+	mockService := new(MockDeadLetterService)
+	ctrl := New(new(MockUserService), zap.NewNop(), WithDeadLetterService(mockService))
+	app := fiber.New()
+	ctrl.registerRoutes(app)
+
+	// without a token the admin endpoints are not served at all
+	resp, err := app.Test(httptest.NewRequest(http.MethodDelete, "/admin/dlq", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, "/admin/dlq", nil), -1)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusNotFound, resp.StatusCode)
+	mockService.AssertNotCalled(t, "PurgeDeadLetters", mock.Anything)
+}
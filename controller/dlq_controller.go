@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/viswals_backend_task/pkg/models"
+	"go.uber.org/zap"
+)
+
+var (
+	defaultDeadLetterLimit = 50
+	// adminTimeout covers replays, which publish and confirm every message they move
+	adminTimeout = 2 * time.Minute
+)
+
+// replayRequest selects the messages to replay; no IDs replays the whole queue
+type replayRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// requireAdmin checks the bearer token of admin requests, refusing every request when no token is configured
+func (c *Controller) requireAdmin(ctx *fiber.Ctx) error {
+	if c.AdminToken == "" {
+		return ctx.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "admin endpoints are disabled"})
+	}
+	token := ctx.Get(fiber.HeaderAuthorization)
+	if subtle.ConstantTimeCompare([]byte(token), []byte("Bearer "+c.AdminToken)) != 1 {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "unauthorized"})
+	}
+	return ctx.Next()
+}
+
+// ListDeadLetters lists the messages at the head of the dead-letter queue with their failure headers.
+func (c *Controller) ListDeadLetters(ctx *fiber.Ctx) error {
+	limit := ctx.QueryInt("limit", defaultDeadLetterLimit)
+	if limit <= 0 {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "limit must be a positive integer"})
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	letters, err := c.DeadLetterService.ListDeadLetters(ctxWithTimeout, limit)
+	if err != nil {
+		c.logger.Error("failed to list dead letters", zap.Error(err))
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+	}
+	return c.sendResponse(ctx, fiber.StatusOK, "success", letters)
+}
+
+// PeekDeadLetter returns one dead-lettered message with its payload, leaving it in the queue.
+func (c *Controller) PeekDeadLetter(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	letter, err := c.DeadLetterService.PeekDeadLetter(ctxWithTimeout, id)
+	if err != nil {
+		if errors.Is(err, models.ErrDeadLetterNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "dead letter not found"})
+		}
+		c.logger.Error("failed to peek dead letter", zap.Error(err), zap.String("id", id))
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+	}
+	return c.sendResponse(ctx, fiber.StatusOK, "success", letter)
+}
+
+// ReplayDeadLetters moves the selected messages, or all of them, back to the main queue.
+func (c *Controller) ReplayDeadLetters(ctx *fiber.Ctx) error {
+	var req replayRequest
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&req); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid request body"})
+		}
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	replayed, err := c.DeadLetterService.ReplayDeadLetters(ctxWithTimeout, req.IDs)
+	if err != nil {
+		c.logger.Error("failed to replay dead letters", zap.Error(err), zap.Int("replayed", replayed))
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error", "replayed": replayed})
+	}
+	c.logger.Info("Replayed dead letters", zap.Int("replayed", replayed))
+	return c.sendResponse(ctx, fiber.StatusOK, "success", fiber.Map{"replayed": replayed})
+}
+
+// PurgeDeadLetters deletes every message in the dead-letter queue.
+func (c *Controller) PurgeDeadLetters(ctx *fiber.Ctx) error {
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	purged, err := c.DeadLetterService.PurgeDeadLetters(ctxWithTimeout)
+	if err != nil {
+		c.logger.Error("failed to purge dead letters", zap.Error(err))
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+	}
+	c.logger.Info("Purged dead letters", zap.Int("purged", purged))
+	return c.sendResponse(ctx, fiber.StatusOK, "success", fiber.Map{"purged": purged})
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/viswals_backend_task/pkg/models"
+	"go.uber.org/zap"
+)
+
+// CreateImport accepts a multipart upload in the "file" field and starts ingesting it. The file is
+// read from the request body as it arrives, so uploads are never held in memory.
+func (c *Controller) CreateImport(ctx *fiber.Ctx) error {
+	// the body is only buffered when the server was not configured to stream it
+	var body io.Reader = ctx.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(ctx.Body())
+	}
+	body = c.limitReader(body)
+
+	// whatever the handler left unread is drained so the connection can be reused, or the
+	// connection is closed when the body is too large to drain
+	defer func() {
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			ctx.Context().SetConnectionClose()
+		}
+	}()
+
+	part, err := uploadedFile(ctx, body)
+	if err != nil {
+		if errors.Is(err, errBodyTooLarge) {
+			return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"message": "upload too large"})
+		}
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "a file must be uploaded in the 'file' form field"})
+	}
+	defer part.Close()
+
+	job, err := c.ImportService.Submit(ctx.UserContext(), part.FileName(), part)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidImport) {
+			return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+		}
+		if errors.Is(err, errBodyTooLarge) {
+			return ctx.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"message": "upload too large"})
+		}
+		c.logger.Error("failed to submit import", zap.Error(err), zap.String("file", part.FileName()))
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+	}
+
+	return ctx.Status(fiber.StatusAccepted).JSON(job)
+}
+
+// uploadedFile returns the "file" part of a multipart upload, skipping any fields before it
+func uploadedFile(ctx *fiber.Ctx, body io.Reader) (*multipart.Part, error) {
+	mediaType, params, err := mime.ParseMediaType(ctx.Get(fiber.HeaderContentType))
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != fiber.MIMEMultipartForm || params["boundary"] == "" {
+		return nil, errors.New("not a multipart upload")
+	}
+
+	reader := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// GetImport reports the progress and reject counts of an import.
+func (c *Controller) GetImport(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	if id == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "import id is required"})
+	}
+
+	ctxWithTimeout, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	job, err := c.ImportService.Get(ctxWithTimeout, id)
+	if err != nil {
+		if errors.Is(err, models.ErrImportNotFound) {
+			return ctx.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "import not found"})
+		}
+		c.logger.Error("failed to get import", zap.Error(err), zap.String("id", id))
+		return ctx.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(job)
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"io"
 
 	"github.com/viswals_backend_task/pkg/models"
 )
@@ -13,3 +14,22 @@ type UserService interface {
 	DeleteUser(context.Context, string) error
 	GetAllUsersSSE(ctx context.Context, limit, lastKey int64) ([]byte, error)
 }
+
+// ImportService ingests uploaded files in the background and reports their progress
+type ImportService interface {
+	Submit(ctx context.Context, fileName string, r io.Reader) (*models.ImportJob, error)
+	Get(ctx context.Context, id string) (*models.ImportJob, error)
+}
+
+// DeadLetterService inspects, replays and purges the messages in the dead-letter queue
+type DeadLetterService interface {
+	ListDeadLetters(ctx context.Context, limit int) ([]models.DeadLetter, error)
+	PeekDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error)
+	ReplayDeadLetters(ctx context.Context, ids []string) (int, error)
+	PurgeDeadLetters(ctx context.Context) (int, error)
+}
+
+// HealthChecker reports whether a dependency is usable; a nil error means healthy
+type HealthChecker interface {
+	Health() error
+}
@@ -0,0 +1,259 @@
+// Package brokertest is the behavioral test suite every message broker backend must pass
+package brokertest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+// MaxAttempts is the retry limit the brokers under test must be configured with
+const MaxAttempts = 2
+
+// waitTimeout bounds how long a test waits for a delivery
+const waitTimeout = 10 * time.Second
+
+// Broker is what the producer, the consumer and the dead-letter admin endpoints use of a broker
+type Broker interface {
+	Publish(ctx context.Context, message []byte) error
+	Subscribe(ctx context.Context) (<-chan models.Message, error)
+	Retry(ctx context.Context, msg models.Message, failure models.Failure) error
+	DeadLetter(ctx context.Context, msg models.Message, failure models.Failure) error
+	PublishDeadLetter(ctx context.Context, msg models.Message, failure models.Failure) error
+	ListDeadLetters(ctx context.Context, limit int) ([]models.DeadLetter, error)
+	PeekDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error)
+	ReplayDeadLetters(ctx context.Context, ids []string) (int, error)
+	PurgeDeadLetters(ctx context.Context) (int, error)
+	Close() error
+}
+
+// Factory returns a broker on empty queues, allowing MaxAttempts attempts per message with
+// retry delays of a few hundred milliseconds at most. Cleanup is registered on t.
+type Factory func(t *testing.T) Broker
+
+// Run runs the suite against the brokers newBroker creates
+func Run(t *testing.T, newBroker Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, b Broker)
+	}{
+		{"PublishSubscribe", testPublishSubscribe},
+		{"NackRequeue", testNackRequeue},
+		{"Retry", testRetry},
+		{"RetryExhausted", testRetryExhausted},
+		{"DeadLetterReplay", testDeadLetterReplay},
+		{"PublishDeadLetter", testPublishDeadLetter},
+		{"PurgeDeadLetters", testPurgeDeadLetters},
+		{"CloseEndsSubscription", testCloseEndsSubscription},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newBroker(t))
+		})
+	}
+}
+
+// testPublishSubscribe checks messages are delivered once, in order, with an ID
+func testPublishSubscribe(t *testing.T, b Broker) {
+	ctx := context.Background()
+	messages := subscribe(t, b)
+
+	for _, body := range []string{"a", "b", "c"} {
+		require.NoError(t, b.Publish(ctx, []byte(body)))
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		m := receive(t, messages)
+		assert.Equal(t, want, string(m.Body))
+		assert.NotEmpty(t, m.ID)
+		assert.Equal(t, int32(1), m.Attempt())
+		require.NoError(t, m.Ack())
+	}
+	assertNoMessage(t, messages)
+}
+
+// testNackRequeue checks a message nacked with requeue is delivered again
+func testNackRequeue(t *testing.T, b Broker) {
+	messages := subscribe(t, b)
+	require.NoError(t, b.Publish(context.Background(), []byte("again")))
+
+	m := receive(t, messages)
+	require.NoError(t, m.Nack(true))
+
+	again := receive(t, messages)
+	assert.Equal(t, "again", string(again.Body))
+	assert.Equal(t, m.ID, again.ID)
+	require.NoError(t, again.Ack())
+	assertNoMessage(t, messages)
+}
+
+// testRetry checks a retried message comes back with its attempt count and failure
+func testRetry(t *testing.T, b Broker) {
+	ctx := context.Background()
+	messages := subscribe(t, b)
+	require.NoError(t, b.Publish(ctx, []byte("retry")))
+
+	m := receive(t, messages)
+	require.NoError(t, b.Retry(ctx, m, models.Failure{Reason: "timeout", Class: "*net.OpError"}))
+
+	retried := receive(t, messages)
+	assert.Equal(t, "retry", string(retried.Body))
+	assert.Equal(t, m.ID, retried.ID)
+	assert.Equal(t, int32(2), retried.Attempt())
+	assert.Equal(t, "timeout", retried.Headers[models.HeaderFailureReason])
+	require.NoError(t, retried.Ack())
+	assertNoMessage(t, messages)
+}
+
+// testRetryExhausted checks a message on its last attempt is dead-lettered instead of retried
+func testRetryExhausted(t *testing.T, b Broker) {
+	ctx := context.Background()
+	messages := subscribe(t, b)
+	require.NoError(t, b.Publish(ctx, []byte("exhausted")))
+
+	failure := models.Failure{Reason: "timeout", Class: "*net.OpError"}
+	m := receive(t, messages)
+	for i := 1; i < MaxAttempts; i++ {
+		require.NoError(t, b.Retry(ctx, m, failure))
+		m = receive(t, messages)
+	}
+	require.NoError(t, b.Retry(ctx, m, failure))
+	assertNoMessage(t, messages)
+
+	letters, err := b.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, m.ID, letters[0].ID)
+	assert.Equal(t, "timeout", letters[0].Reason)
+	assert.Equal(t, int32(MaxAttempts), letters[0].Attempts)
+	assert.Empty(t, letters[0].Body)
+}
+
+// testDeadLetterReplay checks a dead-lettered message can be inspected and replayed as a fresh message
+func testDeadLetterReplay(t *testing.T, b Broker) {
+	ctx := context.Background()
+	messages := subscribe(t, b)
+	require.NoError(t, b.Publish(ctx, []byte(`{"id":1}`)))
+
+	m := receive(t, messages)
+	require.NoError(t, b.DeadLetter(ctx, m, models.Failure{Reason: "bad json", Class: "*json.SyntaxError", Code: "22P02"}))
+	assertNoMessage(t, messages)
+
+	letter, err := b.PeekDeadLetter(ctx, m.ID)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":1}`, letter.Body)
+	assert.Equal(t, "bad json", letter.Reason)
+	assert.Equal(t, "*json.SyntaxError", letter.Class)
+	assert.Equal(t, "22P02", letter.Code)
+	assert.NotEmpty(t, letter.OriginalQueue)
+	assert.NotNil(t, letter.FailedAt)
+
+	_, err = b.PeekDeadLetter(ctx, "missing")
+	assert.ErrorIs(t, err, models.ErrDeadLetterNotFound)
+
+	replayed, err := b.ReplayDeadLetters(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+
+	again := receive(t, messages)
+	assert.Equal(t, `{"id":1}`, string(again.Body))
+	assert.Equal(t, int32(1), again.Attempt())
+	assert.NotContains(t, again.Headers, models.HeaderFailureReason)
+	require.NoError(t, again.Ack())
+
+	letters, err := b.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+// testPublishDeadLetter checks part of a message can be dead-lettered without settling the message
+func testPublishDeadLetter(t *testing.T, b Broker) {
+	ctx := context.Background()
+	messages := subscribe(t, b)
+	require.NoError(t, b.Publish(ctx, []byte(`[{"id":1},{"id":2}]`)))
+
+	m := receive(t, messages)
+	part := m
+	part.ID = m.ID + "-2"
+	part.Body = []byte(`{"id":2}`)
+	require.NoError(t, b.PublishDeadLetter(ctx, part, models.Failure{Reason: "value too long", Code: "22001"}))
+	require.NoError(t, m.Ack())
+	assertNoMessage(t, messages)
+
+	letter, err := b.PeekDeadLetter(ctx, part.ID)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":2}`, letter.Body)
+	assert.Equal(t, "22001", letter.Code)
+}
+
+// testPurgeDeadLetters checks purging empties the dead-letter queue
+func testPurgeDeadLetters(t *testing.T, b Broker) {
+	ctx := context.Background()
+	messages := subscribe(t, b)
+
+	for _, body := range []string{"x", "y"} {
+		require.NoError(t, b.Publish(ctx, []byte(body)))
+		require.NoError(t, b.DeadLetter(ctx, receive(t, messages), models.Failure{Reason: "bad"}))
+	}
+
+	purged, err := b.PurgeDeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+
+	letters, err := b.ListDeadLetters(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+// testCloseEndsSubscription checks Close closes the channel returned by Subscribe
+func testCloseEndsSubscription(t *testing.T, b Broker) {
+	messages, err := b.Subscribe(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, b.Close())
+
+	select {
+	case _, ok := <-messages:
+		assert.False(t, ok, "no message was published")
+	case <-time.After(waitTimeout):
+		t.Fatal("subscription still open after Close")
+	}
+}
+
+// subscribe subscribes for the duration of the test
+func subscribe(t *testing.T, b Broker) <-chan models.Message {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	messages, err := b.Subscribe(ctx)
+	require.NoError(t, err)
+	return messages
+}
+
+// receive waits for the next message
+func receive(t *testing.T, messages <-chan models.Message) models.Message {
+	t.Helper()
+	select {
+	case m, ok := <-messages:
+		require.True(t, ok, "subscription closed")
+		return m
+	case <-time.After(waitTimeout):
+		t.Fatal("no message delivered")
+	}
+	return models.Message{}
+}
+
+// assertNoMessage checks nothing else is delivered for a while
+func assertNoMessage(t *testing.T, messages <-chan models.Message) {
+	t.Helper()
+	select {
+	case m, ok := <-messages:
+		if ok {
+			t.Errorf("unexpected message %q", m.Body)
+		}
+	case <-time.After(300 * time.Millisecond):
+	}
+}
@@ -0,0 +1,185 @@
+package checkpoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Checkpoint records how far the producer got through a given input file.
+// All rows up to and including LastRow have been handled.
+type Checkpoint struct {
+	FilePath    string    `json:"file_path"`
+	Fingerprint string    `json:"fingerprint"`
+	LastRow     int64     `json:"last_row"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Store persists checkpoints as a JSON document on the local filesystem.
+// Entries are keyed by file path and content fingerprint, so an edited file
+// never matches the checkpoint of its previous version.
+type Store struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStore creates a checkpoint store backed by the file at path.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// Load returns the checkpoint for the given file and fingerprint, if any.
+func (s *Store) Load(filePath, fingerprint string) (Checkpoint, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read()
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+
+	cp, ok := entries[key(filePath, fingerprint)]
+	return cp, ok, nil
+}
+
+// Save stores the checkpoint, replacing any entry recorded for an older version of the same file.
+func (s *Store) Save(cp Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	for k, existing := range entries {
+		if existing.FilePath == cp.FilePath {
+			delete(entries, k)
+		}
+	}
+
+	cp.UpdatedAt = time.Now().UTC()
+	entries[key(cp.FilePath, cp.Fingerprint)] = cp
+
+	return s.write(entries)
+}
+
+// read loads all entries from disk, treating a missing file as empty.
+func (s *Store) read() (map[string]Checkpoint, error) {
+	entries := make(map[string]Checkpoint)
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return entries, nil
+		}
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return entries, nil
+	}
+
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// write atomically replaces the checkpoint file so a crash never leaves it half written.
+func (s *Store) write(entries map[string]Checkpoint) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func key(filePath, fingerprint string) string {
+	return filePath + "@" + fingerprint
+}
+
+// Fingerprint returns the SHA-256 digest of the file contents.
+func Fingerprint(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Tracker turns out-of-order row completions into a contiguous high-water mark.
+// Rows that never complete (e.g. failed publishes) hold the mark back, so a
+// resumed run always starts at the first row that was not handled.
+type Tracker struct {
+	mu      sync.Mutex
+	last    int64
+	pending map[int64]struct{}
+}
+
+// NewTracker creates a tracker whose rows up to and including start are already done.
+func NewTracker(start int64) *Tracker {
+	return &Tracker{last: start, pending: make(map[int64]struct{})}
+}
+
+// Done marks the row as handled and advances the mark when possible.
+func (t *Tracker) Done(row int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if row <= t.last {
+		return
+	}
+
+	t.pending[row] = struct{}{}
+	for {
+		if _, ok := t.pending[t.last+1]; !ok {
+			break
+		}
+		delete(t.pending, t.last+1)
+		t.last++
+	}
+}
+
+// Last returns the highest row such that it and every row before it are done.
+func (t *Tracker) Last() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
@@ -15,7 +15,10 @@ func ReadRows(reader *csv.Reader, n int) ([][]string, []string, error) {
 		if errors.Is(err, io.EOF) {
 			return records, nil, err
 		} else if errors.Is(err, csv.ErrFieldCount) {
-			return records[:len(records)-1], records[len(records)-1], nil
+			// the reader still returns the offending record alongside the error.
+			return records, record, nil
+		} else if err != nil {
+			return records, nil, err
 		}
 		records = append(records, record)
 	}
@@ -23,24 +26,32 @@ func ReadRows(reader *csv.Reader, n int) ([][]string, []string, error) {
 }
 
 // OpenFile opens a CSV file in read-only mode and initializes a CSV reader.
-func OpenFile(filePath string) (*csv.Reader, error) {
+// The header row is consumed and returned alongside the reader.
+func OpenFile(filePath string) (*csv.Reader, []string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	csvReader := csv.NewReader(file)
+	return NewReader(file, ',')
+}
+
+// NewReader initializes a CSV reader with the given field delimiter.
+// The header row is consumed and returned alongside the reader.
+func NewReader(r io.Reader, comma rune) (*csv.Reader, []string, error) {
+	csvReader := csv.NewReader(r)
+	csvReader.Comma = comma
+
 	// Determine number of fields per record by reading the first row.
-	firstRow, err := csvReader.Read()
+	header, err := csvReader.Read()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	csvReader.FieldsPerRecord = len(firstRow)
+	csvReader.FieldsPerRecord = len(header)
 	csvReader.ReuseRecord = false
-	csvReader.Comma = ','
 
-	return csvReader, nil
+	return csvReader, header, nil
 }
 
 // ReadAll reads all records from the CSV reader.
@@ -0,0 +1,155 @@
+package mapping
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Field names of models.UserDetails, matching its JSON tags.
+const (
+	FieldID           = "id"
+	FieldFirstName    = "first_name"
+	FieldLastName     = "last_name"
+	FieldEmailAddress = "email_address"
+	FieldCreatedAt    = "created_at"
+	FieldDeletedAt    = "deleted_at"
+	FieldMergedAt     = "merged_at"
+	FieldParentUserID = "parent_user_id"
+)
+
+// Fields lists every user field in the legacy positional column order.
+var Fields = []string{
+	FieldID,
+	FieldFirstName,
+	FieldLastName,
+	FieldEmailAddress,
+	FieldCreatedAt,
+	FieldDeletedAt,
+	FieldMergedAt,
+	FieldParentUserID,
+}
+
+// requiredFields must be present in every input; the rest default to empty when missing.
+var requiredFields = map[string]bool{
+	FieldID:           true,
+	FieldFirstName:    true,
+	FieldLastName:     true,
+	FieldEmailAddress: true,
+	FieldCreatedAt:    true,
+}
+
+// Aliases maps each user field to the header names accepted for it.
+type Aliases map[string][]string
+
+// DefaultAliases returns the header names recognised without an alias file.
+func DefaultAliases() Aliases {
+	return Aliases{
+		FieldID:           {"id", "user_id"},
+		FieldFirstName:    {"first_name", "firstname", "given_name"},
+		FieldLastName:     {"last_name", "lastname", "surname", "family_name"},
+		FieldEmailAddress: {"email_address", "email", "e_mail", "mail"},
+		FieldCreatedAt:    {"created_at", "created", "creation_time"},
+		FieldDeletedAt:    {"deleted_at", "deleted", "deletion_time"},
+		FieldMergedAt:     {"merged_at", "merged", "merge_time"},
+		FieldParentUserID: {"parent_user_id", "parent_id", "parent"},
+	}
+}
+
+// LoadAliases reads a JSON alias file and merges it on top of the defaults.
+// The file maps field names to lists of accepted header names, e.g.
+// {"email_address": ["contact_email"]}.
+func LoadAliases(path string) (Aliases, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var custom Aliases
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("invalid alias file %s: %w", path, err)
+	}
+
+	aliases := DefaultAliases()
+	for field, names := range custom {
+		if _, ok := aliases[field]; !ok {
+			return nil, fmt.Errorf("invalid alias file %s: unknown field %q", path, field)
+		}
+		aliases[field] = append(aliases[field], names...)
+	}
+	return aliases, nil
+}
+
+// Mapping resolves user fields to column positions within a row.
+type Mapping struct {
+	index map[string]int
+	width int
+}
+
+// Positional returns the mapping for the legacy fixed column layout.
+func Positional() *Mapping {
+	m := &Mapping{index: make(map[string]int, len(Fields)), width: len(Fields)}
+	for i, field := range Fields {
+		m.index[field] = i
+	}
+	return m
+}
+
+// Resolve builds a mapping from a header row. Unknown columns are ignored;
+// a missing required column or a field matched by two columns is an error.
+func Resolve(header []string, aliases Aliases) (*Mapping, error) {
+	lookup := make(map[string]string)
+	for field, names := range aliases {
+		for _, name := range names {
+			lookup[normalize(name)] = field
+		}
+	}
+
+	m := &Mapping{index: make(map[string]int, len(Fields)), width: len(header)}
+	for i, column := range header {
+		field, ok := lookup[normalize(column)]
+		if !ok {
+			continue
+		}
+		if prev, dup := m.index[field]; dup {
+			return nil, fmt.Errorf("columns %q and %q both map to field %q", header[prev], column, field)
+		}
+		m.index[field] = i
+	}
+
+	var missing []string
+	for field := range requiredFields {
+		if _, ok := m.index[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing required columns %s in header %v", strings.Join(missing, ", "), header)
+	}
+
+	return m, nil
+}
+
+// Width is the number of columns a row must have to be read with this mapping.
+func (m *Mapping) Width() int {
+	return m.width
+}
+
+// Value returns the column value for the field, or "" when the input has no such column.
+func (m *Mapping) Value(row []string, field string) string {
+	i, ok := m.index[field]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// normalize lowercases a header name and folds spaces and dashes into underscores.
+func normalize(name string) string {
+	name = strings.TrimPrefix(name, "\ufeff")
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+}
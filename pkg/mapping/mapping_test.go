@@ -0,0 +1,91 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	header := []string{"\ufeffUser ID", "E-Mail", "Given Name", "Surname", "notes", "Created", "PARENT"}
+
+	m, err := Resolve(header, DefaultAliases())
+	require.NoError(t, err)
+	assert.Equal(t, len(header), m.Width())
+
+	row := []string{"7", "jane@example.com", "Jane", "Doe", "ignored", "2024-01-01", "3"}
+	assert.Equal(t, "7", m.Value(row, FieldID))
+	assert.Equal(t, "jane@example.com", m.Value(row, FieldEmailAddress))
+	assert.Equal(t, "Jane", m.Value(row, FieldFirstName))
+	assert.Equal(t, "Doe", m.Value(row, FieldLastName))
+	assert.Equal(t, "2024-01-01", m.Value(row, FieldCreatedAt))
+	assert.Equal(t, "3", m.Value(row, FieldParentUserID))
+	// optional fields without a column and short rows read as empty
+	assert.Equal(t, "", m.Value(row, FieldDeletedAt))
+	assert.Equal(t, "", m.Value(row[:2], FieldLastName))
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []string
+		err    string
+	}{
+		{
+			name:   "missing required columns",
+			header: []string{"id", "email"},
+			err:    "missing required columns created_at, first_name, last_name",
+		},
+		{
+			name:   "field matched twice",
+			header: []string{"id", "email", "email_address", "first_name", "last_name", "created_at"},
+			err:    `columns "email" and "email_address" both map to field "email_address"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Resolve(tt.header, DefaultAliases())
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestPositional(t *testing.T) {
+	m := Positional()
+	row := []string{"1", "John", "Doe", "john@example.com", "100", "200", "300", "4"}
+
+	assert.Equal(t, len(Fields), m.Width())
+	for i, field := range Fields {
+		assert.Equal(t, row[i], m.Value(row, field), field)
+	}
+}
+
+func TestLoadAliases(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "aliases.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"email_address": ["contact_email"]}`), 0o644))
+
+	aliases, err := LoadAliases(path)
+	require.NoError(t, err)
+	// custom names are added to the defaults
+	assert.Contains(t, aliases[FieldEmailAddress], "email")
+	assert.Contains(t, aliases[FieldEmailAddress], "contact_email")
+
+	m, err := Resolve([]string{"id", "Contact Email", "first_name", "last_name", "created_at"}, aliases)
+	require.NoError(t, err)
+	assert.Equal(t, "a@example.com", m.Value([]string{"1", "a@example.com"}, FieldEmailAddress))
+
+	unknown := filepath.Join(dir, "unknown.json")
+	require.NoError(t, os.WriteFile(unknown, []byte(`{"phone": ["mobile"]}`), 0o644))
+	_, err = LoadAliases(unknown)
+	assert.ErrorContains(t, err, `unknown field "phone"`)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	require.NoError(t, os.WriteFile(invalid, []byte(`["email"]`), 0o644))
+	_, err = LoadAliases(invalid)
+	assert.ErrorContains(t, err, "invalid alias file")
+}
@@ -0,0 +1,114 @@
+package membroker
+
+import (
+	"context"
+	"errors"
+
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+// ListDeadLetters returns up to limit messages from the head of the dead-letter queue, without
+// their bodies. The messages stay in the queue.
+func (b *Broker) ListDeadLetters(_ context.Context, limit int) ([]models.DeadLetter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if limit <= 0 || limit > len(b.dead) {
+		limit = len(b.dead)
+	}
+	letters := make([]models.DeadLetter, 0, limit)
+	for _, env := range b.dead[:limit] {
+		letters = append(letters, models.DeadLetterOf(env.ID, env.Headers, env.Body, false))
+	}
+	return letters, nil
+}
+
+// PeekDeadLetter returns a dead-lettered message with its body, leaving it in the queue
+func (b *Broker) PeekDeadLetter(_ context.Context, id string) (*models.DeadLetter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, env := range b.dead {
+		if env.ID == id {
+			letter := models.DeadLetterOf(env.ID, env.Headers, env.Body, true)
+			return &letter, nil
+		}
+	}
+	return nil, models.ErrDeadLetterNotFound
+}
+
+// ReplayDeadLetters moves dead-lettered messages back to the main queue as fresh messages,
+// without their failure headers. An empty ids replays every dead-lettered message. A dead
+// letter leaves the journal before its fresh copy enters it, so a failed write can never
+// leave both behind to be replayed again after a restart.
+func (b *Broker) ReplayDeadLetters(_ context.Context, ids []string) (int, error) {
+	selected := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosed
+	}
+	defer b.maybeCompact()
+
+	replayed := 0
+	kept := b.dead[:0]
+	for i, env := range b.dead {
+		if len(selected) > 0 && !selected[env.ID] {
+			kept = append(kept, env)
+			continue
+		}
+
+		fresh := &envelope{ID: env.ID, Body: env.Body, Headers: replayHeaders(env.Headers), ContentType: env.ContentType}
+		if err := b.journal.remove(env.Seq); err != nil {
+			b.dead = append(kept, b.dead[i:]...)
+			return replayed, err
+		}
+		if err := b.enqueue(fresh); err != nil {
+			// keep the dead letter; compaction rewrites it even if journaling it again fails
+			b.dead = append(kept, b.dead[i:]...)
+			return replayed, errors.Join(err, b.journal.put(queueDead, env))
+		}
+		replayed++
+	}
+	b.dead = kept
+	return replayed, nil
+}
+
+// PurgeDeadLetters deletes every message in the dead-letter queue, returning how many were removed
+func (b *Broker) PurgeDeadLetters(_ context.Context) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosed
+	}
+	defer b.maybeCompact()
+
+	purged := 0
+	for _, env := range b.dead {
+		if err := b.journal.remove(env.Seq); err != nil {
+			b.dead = b.dead[purged:]
+			return purged, err
+		}
+		purged++
+	}
+	b.dead = nil
+	return purged, nil
+}
+
+// replayHeaders drops the failure and attempt headers so a replayed message starts over
+func replayHeaders(headers map[string]any) map[string]any {
+	out := map[string]any{}
+	for k, v := range headers {
+		switch k {
+		case models.HeaderFailureReason, models.HeaderExceptionClass, models.HeaderOriginalQueue,
+			models.HeaderAttemptCount, models.HeaderFailedAt, models.HeaderPgErrorCode:
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
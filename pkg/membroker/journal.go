@@ -0,0 +1,245 @@
+package membroker
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"go.uber.org/zap"
+)
+
+// Queues a journal record belongs to
+const (
+	queueMain = "main"
+	queueDead = "dead"
+)
+
+// journal appends every change of the queues to a file as JSON lines: a "put" record when a
+// message enters a queue and a "del" record when it leaves. Replaying the records on start
+// restores the messages that were queued, in flight or dead-lettered. A nil journal keeps
+// nothing.
+type journal struct {
+	file *os.File
+	w    *bufio.Writer
+	// removed counts the "del" records written since the journal was last compacted
+	removed int
+}
+
+type record struct {
+	Op    string    `json:"op"`
+	Seq   uint64    `json:"seq"`
+	Queue string    `json:"queue,omitempty"`
+	Msg   *envelope `json:"msg,omitempty"`
+}
+
+const (
+	opPut = "put"
+	opDel = "del"
+)
+
+// put records that env entered queue
+func (j *journal) put(queue string, env *envelope) error {
+	if j == nil {
+		return nil
+	}
+	return j.write(record{Op: opPut, Seq: env.Seq, Queue: queue, Msg: env})
+}
+
+// remove records that the message seq left its queue
+func (j *journal) remove(seq uint64) error {
+	if j == nil {
+		return nil
+	}
+	if err := j.write(record{Op: opDel, Seq: seq}); err != nil {
+		return err
+	}
+	j.removed++
+	return nil
+}
+
+// write appends a record; it is flushed to the file at once so a crash loses at most the
+// record being written
+func (j *journal) write(r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("memory broker journal: %w", err)
+	}
+	if _, err := j.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("memory broker journal: %w", err)
+	}
+	if err := j.w.Flush(); err != nil {
+		return fmt.Errorf("memory broker journal: %w", err)
+	}
+	return nil
+}
+
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	return errors.Join(j.w.Flush(), j.file.Sync(), j.file.Close())
+}
+
+// restore replays the journal into the queues and compacts it to the messages still queued
+func (b *Broker) restore() error {
+	live, err := readJournal(b.path, b.logger)
+	if err != nil {
+		return err
+	}
+
+	var main, dead []*envelope
+	for _, r := range live {
+		b.seq = max(b.seq, r.Seq)
+		if r.Queue == queueDead {
+			dead = append(dead, r.Msg)
+		} else {
+			main = append(main, r.Msg)
+		}
+	}
+	sortBySeq(main)
+	sortBySeq(dead)
+
+	if err := b.compact(main, dead); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, env := range main {
+		b.schedule(env)
+	}
+	b.dead = dead
+
+	if len(main)+len(dead) > 0 {
+		b.logger.Info("Memory broker restored from journal", zap.String("path", b.path),
+			zap.Int("queued", len(main)), zap.Int("dead_lettered", len(dead)))
+	}
+	return nil
+}
+
+// maybeCompact rewrites the journal from the queues once it holds at least compactThreshold
+// removed messages and more removed than live ones, so a long-running broker's journal does not
+// grow with every message it ever handled. A failed compaction keeps the current journal.
+// b.mu must be held and the queues must be consistent.
+func (b *Broker) maybeCompact() {
+	if b.journal == nil || b.compactThreshold <= 0 || b.journal.removed < b.compactThreshold {
+		return
+	}
+	if b.journal.removed < b.inFlight()+len(b.dead) {
+		return
+	}
+
+	main := append([]*envelope{}, b.ready...)
+	for _, env := range b.unacked {
+		main = append(main, env)
+	}
+	for _, env := range b.pending {
+		main = append(main, env)
+	}
+	sortBySeq(main)
+
+	old := b.journal
+	if err := b.compact(main, b.dead); err != nil {
+		b.logger.Warn("Failed to compact the memory broker journal", zap.String("path", b.path), zap.Error(err))
+		// the counter restarts so a persistent failure is not retried on every removal
+		old.removed = 0
+		return
+	}
+	if err := old.close(); err != nil {
+		b.logger.Warn("Failed to close the compacted memory broker journal", zap.String("path", b.path), zap.Error(err))
+	}
+}
+
+// readJournal returns the records of the messages that never left their queue. A record cut
+// short by a crash ends the journal.
+func readJournal(path string, logger *zap.Logger) (map[uint64]record, error) {
+	live := make(map[uint64]record)
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return live, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("memory broker journal: %w", err)
+	}
+	defer file.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var r record
+		err := dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			return live, nil
+		}
+		if err != nil {
+			logger.Warn("Ignoring the incomplete end of the memory broker journal", zap.String("path", path), zap.Error(err))
+			return live, nil
+		}
+
+		switch {
+		case r.Op == opPut && r.Msg != nil:
+			r.Msg.Seq = r.Seq
+			live[r.Seq] = r
+		case r.Op == opDel:
+			delete(live, r.Seq)
+		}
+	}
+}
+
+// compact rewrites the journal with only the given messages and opens it for appending
+func (b *Broker) compact(main, dead []*envelope) error {
+	if err := os.MkdirAll(filepath.Dir(b.path), 0o755); err != nil {
+		return fmt.Errorf("memory broker journal: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("memory broker journal: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	j := &journal{file: tmp, w: bufio.NewWriter(tmp)}
+	for _, env := range main {
+		if err := j.put(queueMain, env); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	for _, env := range dead {
+		if err := j.put(queueDead, env); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := j.close(); err != nil {
+		return fmt.Errorf("memory broker journal: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), b.path); err != nil {
+		return fmt.Errorf("memory broker journal: %w", err)
+	}
+
+	file, err := os.OpenFile(b.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("memory broker journal: %w", err)
+	}
+	b.journal = &journal{file: file, w: bufio.NewWriter(file)}
+	return nil
+}
+
+// sortBySeq orders messages as they were queued
+func sortBySeq(envs []*envelope) {
+	slices.SortFunc(envs, func(a, b *envelope) int {
+		switch {
+		case a.Seq < b.Seq:
+			return -1
+		case a.Seq > b.Seq:
+			return 1
+		}
+		return 0
+	})
+}
@@ -0,0 +1,558 @@
+package membroker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/viswals_backend_task/pkg/models"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrClosed is returned once Close has been called
+	ErrClosed = errors.New("memory broker closed")
+	// ErrSettled is returned when a message is settled twice, or after its subscription ended
+	ErrSettled = errors.New("message already settled")
+)
+
+const (
+	defaultCapacity = 10000
+	// defaultCompactThreshold is how many removed messages the journal collects before it is compacted
+	defaultCompactThreshold = 10000
+)
+
+// RetryPolicy bounds how often a message is attempted and how long it waits between attempts
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// DefaultRetryPolicy attempts a message up to 5 times, waiting from 1s to 1m between attempts
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 5, InitialDelay: time.Second, MaxDelay: time.Minute}
+}
+
+// delay is the wait before the attempt after attempt, doubling from InitialDelay up to MaxDelay
+func (p RetryPolicy) delay(attempt int32) time.Duration {
+	d := p.InitialDelay
+	for i := int32(1); i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// Broker is an in-process message broker for local runs and tests. Published messages wait in a
+// bounded queue until a subscriber acks them: Publish blocks while capacity messages are queued,
+// in flight or waiting for a retry. A message nacked with requeue, or still unacked when its
+// subscription ends, is delivered again. With a journal file the queues survive a restart.
+type Broker struct {
+	queue    string
+	capacity int
+	retry    RetryPolicy
+	path     string
+	logger   *zap.Logger
+
+	compactThreshold int
+
+	mu      sync.Mutex
+	seq     uint64
+	ready   []*envelope
+	unacked map[uint64]*envelope
+	delayed map[uint64]*time.Timer
+	pending map[uint64]*envelope
+	dead    []*envelope
+	subs    uint64
+	changed chan struct{}
+	journal *journal
+	closed  bool
+
+	closing chan struct{}
+	wg      sync.WaitGroup
+}
+
+// envelope is a queued message. Seq identifies it in the journal and changes whenever the
+// message moves to another queue.
+type envelope struct {
+	Seq         uint64         `json:"seq"`
+	ID          string         `json:"id"`
+	Body        []byte         `json:"body"`
+	Headers     map[string]any `json:"headers,omitempty"`
+	ContentType string         `json:"content_type,omitempty"`
+	// Due is when a message waiting for a retry returns to the queue
+	Due time.Time `json:"due,omitempty"`
+
+	owner uint64
+}
+
+type Option func(*Broker)
+
+// WithCapacity bounds the messages queued, in flight or waiting for a retry
+func WithCapacity(capacity int) Option {
+	return func(b *Broker) {
+		if capacity > 0 {
+			b.capacity = capacity
+		}
+	}
+}
+
+// WithRetryPolicy sets how often Retry attempts a message before dead-lettering it
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(b *Broker) {
+		b.retry = policy
+	}
+}
+
+// WithJournal keeps the queues in a file, so unacknowledged and dead-lettered messages
+// survive a restart. Only one process may use a journal at a time.
+func WithJournal(path string) Option {
+	return func(b *Broker) {
+		b.path = path
+	}
+}
+
+// WithCompactThreshold sets how many removed messages the journal collects before it is rewritten
+// with only the queued ones; zero or less compacts only on start
+func WithCompactThreshold(n int) Option {
+	return func(b *Broker) {
+		b.compactThreshold = n
+	}
+}
+
+// WithLogger sets the logger
+func WithLogger(logger *zap.Logger) Option {
+	return func(b *Broker) {
+		if logger != nil {
+			b.logger = logger
+		}
+	}
+}
+
+// New creates a broker for queue, restoring the journal when one is configured
+func New(queue string, opts ...Option) (*Broker, error) {
+	b := &Broker{
+		queue:            queue,
+		capacity:         defaultCapacity,
+		compactThreshold: defaultCompactThreshold,
+		retry:            DefaultRetryPolicy(),
+		logger:           zap.NewNop(),
+		unacked:          make(map[uint64]*envelope),
+		delayed:          make(map[uint64]*time.Timer),
+		pending:          make(map[uint64]*envelope),
+		changed:          make(chan struct{}),
+		closing:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.retry.MaxAttempts <= 0 || b.retry.InitialDelay <= 0 || b.retry.MaxDelay < b.retry.InitialDelay {
+		return nil, fmt.Errorf("memory broker: invalid retry policy %+v", b.retry)
+	}
+
+	if b.path != "" {
+		if err := b.restore(); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// OptionsFromEnv reads MEMORY_BROKER_CAPACITY, MEMORY_BROKER_FILE, MEMORY_BROKER_RETRY_MAX_ATTEMPTS,
+// MEMORY_BROKER_RETRY_DELAY and MEMORY_BROKER_RETRY_MAX_DELAY
+func OptionsFromEnv() ([]Option, error) {
+	var opts []Option
+
+	if v := os.Getenv("MEMORY_BROKER_CAPACITY"); v != "" {
+		capacity, err := strconv.Atoi(v)
+		if err != nil || capacity <= 0 {
+			return nil, fmt.Errorf("MEMORY_BROKER_CAPACITY: must be a positive integer, got %q", v)
+		}
+		opts = append(opts, WithCapacity(capacity))
+	}
+	if v := os.Getenv("MEMORY_BROKER_FILE"); v != "" {
+		opts = append(opts, WithJournal(v))
+	}
+	if v := os.Getenv("MEMORY_BROKER_COMPACT_THRESHOLD"); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("MEMORY_BROKER_COMPACT_THRESHOLD: must be an integer, got %q", v)
+		}
+		opts = append(opts, WithCompactThreshold(threshold))
+	}
+
+	retry := DefaultRetryPolicy()
+	var err error
+	if v := os.Getenv("MEMORY_BROKER_RETRY_MAX_ATTEMPTS"); v != "" {
+		if retry.MaxAttempts, err = strconv.Atoi(v); err != nil || retry.MaxAttempts <= 0 {
+			return nil, fmt.Errorf("MEMORY_BROKER_RETRY_MAX_ATTEMPTS: must be a positive integer, got %q", v)
+		}
+	}
+	if v := os.Getenv("MEMORY_BROKER_RETRY_DELAY"); v != "" {
+		if retry.InitialDelay, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("MEMORY_BROKER_RETRY_DELAY: %w", err)
+		}
+	}
+	if v := os.Getenv("MEMORY_BROKER_RETRY_MAX_DELAY"); v != "" {
+		if retry.MaxDelay, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("MEMORY_BROKER_RETRY_MAX_DELAY: %w", err)
+		}
+	}
+	return append(opts, WithRetryPolicy(retry)), nil
+}
+
+// Publish queues a message, waiting while the broker is at capacity
+func (b *Broker) Publish(ctx context.Context, data []byte) error {
+	env := &envelope{
+		ID:          uuid.NewString(),
+		Body:        data,
+		ContentType: "application/json",
+	}
+
+	for {
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return ErrClosed
+		}
+		if b.inFlight() < b.capacity {
+			err := b.enqueue(env)
+			b.mu.Unlock()
+			return err
+		}
+		wait := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-b.closing:
+			return ErrClosed
+		}
+	}
+}
+
+// Subscribe delivers queued messages until ctx is done or the broker is closed. Every message
+// must be acked, nacked or rejected; messages still unacked when ctx is done are requeued.
+func (b *Broker) Subscribe(ctx context.Context) (<-chan models.Message, error) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, ErrClosed
+	}
+	b.subs++
+	sub := b.subs
+	b.mu.Unlock()
+
+	out := make(chan models.Message)
+	b.wg.Add(1)
+	go b.deliver(ctx, sub, out)
+	return out, nil
+}
+
+// deliver hands the queued messages to one subscription
+func (b *Broker) deliver(ctx context.Context, sub uint64, out chan<- models.Message) {
+	defer b.wg.Done()
+	defer close(out)
+	defer b.release(sub)
+
+	for {
+		env, err := b.next(ctx, sub)
+		if err != nil {
+			return
+		}
+
+		select {
+		case out <- b.message(env):
+		case <-ctx.Done():
+			return
+		case <-b.closing:
+			return
+		}
+	}
+}
+
+// next takes the oldest ready message for sub, waiting until there is one
+func (b *Broker) next(ctx context.Context, sub uint64) (*envelope, error) {
+	for {
+		b.mu.Lock()
+		if b.closed {
+			b.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if len(b.ready) > 0 {
+			env := b.ready[0]
+			b.ready[0] = nil
+			b.ready = b.ready[1:]
+			env.owner = sub
+			b.unacked[env.Seq] = env
+			b.mu.Unlock()
+			return env, nil
+		}
+		wait := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-b.closing:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// release requeues the messages a finished subscription did not settle
+func (b *Broker) release(sub uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		// the journal still holds them for the next start
+		return
+	}
+
+	var requeued []*envelope
+	for seq, env := range b.unacked {
+		if env.owner == sub {
+			delete(b.unacked, seq)
+			requeued = append(requeued, env)
+		}
+	}
+	if len(requeued) == 0 {
+		return
+	}
+	sortBySeq(requeued)
+	b.ready = append(requeued, b.ready...)
+	b.notify()
+}
+
+// message wraps an envelope in a message settled through the broker
+func (b *Broker) message(env *envelope) models.Message {
+	seq := env.Seq
+	return models.Message{
+		ID:          env.ID,
+		Body:        env.Body,
+		Headers:     copyHeaders(env.Headers),
+		ContentType: env.ContentType,
+		AckFunc:     func() error { return b.settle(seq, false, false) },
+		NackFunc:    func(requeue bool) error { return b.settle(seq, requeue, true) },
+		RejectFunc:  func(requeue bool) error { return b.settle(seq, requeue, true) },
+	}
+}
+
+// settle acks an unacked message, or on a nack requeues it or, like a queue with a
+// dead-letter exchange, dead-letters it
+func (b *Broker) settle(seq uint64, requeue, nack bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	env, ok := b.unacked[seq]
+	if !ok || b.closed {
+		return ErrSettled
+	}
+	delete(b.unacked, seq)
+	defer b.notify()
+
+	switch {
+	case nack && requeue:
+		env.owner = 0
+		b.ready = append([]*envelope{env}, b.ready...)
+		return nil
+	case nack:
+		headers := copyHeaders(env.Headers)
+		if _, ok := headers[models.HeaderFailureReason]; !ok {
+			headers[models.HeaderFailureReason] = "rejected by broker"
+			headers[models.HeaderOriginalQueue] = b.queue
+			headers[models.HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+		}
+		dead := &envelope{ID: env.ID, Body: env.Body, Headers: headers, ContentType: env.ContentType}
+		if err := b.enqueueDead(dead); err != nil {
+			return err
+		}
+	}
+	if err := b.journal.remove(seq); err != nil {
+		return err
+	}
+	b.maybeCompact()
+	return nil
+}
+
+// Retry schedules a message that failed transiently for another attempt once the backoff of
+// its attempt has passed. A message on its last attempt is dead-lettered instead.
+func (b *Broker) Retry(ctx context.Context, m models.Message, failure models.Failure) error {
+	attempt := m.Attempt()
+	if attempt >= int32(b.retry.MaxAttempts) {
+		return b.DeadLetter(ctx, m, failure)
+	}
+
+	headers := copyHeaders(m.Headers)
+	headers[models.HeaderAttemptCount] = attempt + 1
+	headers[models.HeaderFailureReason] = failure.Reason
+	headers[models.HeaderExceptionClass] = failure.Class
+
+	env := &envelope{
+		ID:          m.ID,
+		Body:        m.Body,
+		Headers:     headers,
+		ContentType: m.ContentType,
+		Due:         time.Now().Add(b.retry.delay(attempt)),
+	}
+
+	b.mu.Lock()
+	err := ErrClosed
+	if !b.closed {
+		err = b.enqueue(env)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		// requeued at once rather than lost
+		return errors.Join(err, m.Nack(true))
+	}
+	return m.Ack()
+}
+
+// DeadLetter moves a message to the dead-letter queue with the failure headers and acks it
+func (b *Broker) DeadLetter(ctx context.Context, m models.Message, failure models.Failure) error {
+	if err := b.PublishDeadLetter(ctx, m, failure); err != nil {
+		return errors.Join(err, m.Nack(true))
+	}
+	return m.Ack()
+}
+
+// PublishDeadLetter adds a copy of a message carrying the failure headers to the dead-letter
+// queue, without settling the message
+func (b *Broker) PublishDeadLetter(_ context.Context, m models.Message, failure models.Failure) error {
+	headers := copyHeaders(m.Headers)
+	headers[models.HeaderFailureReason] = failure.Reason
+	headers[models.HeaderExceptionClass] = failure.Class
+	headers[models.HeaderOriginalQueue] = b.queue
+	headers[models.HeaderAttemptCount] = m.Attempt()
+	headers[models.HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+	if failure.Code != "" {
+		headers[models.HeaderPgErrorCode] = failure.Code
+	}
+
+	id := m.ID
+	if id == "" {
+		id = uuid.NewString()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	return b.enqueueDead(&envelope{ID: id, Body: m.Body, Headers: headers, ContentType: m.ContentType})
+}
+
+// Health reports whether the broker accepts messages
+func (b *Broker) Health() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Len returns the number of messages queued, in flight or waiting for a retry
+func (b *Broker) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inFlight()
+}
+
+// Close ends every subscription and closes the journal, which keeps the unacknowledged messages
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	for _, timer := range b.delayed {
+		timer.Stop()
+	}
+	close(b.closing)
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return b.journal.close()
+}
+
+// inFlight counts the messages that hold capacity; b.mu must be held
+func (b *Broker) inFlight() int {
+	return len(b.ready) + len(b.unacked) + len(b.pending)
+}
+
+// enqueue journals a message and adds it to the queue, or schedules it when it is due later;
+// b.mu must be held
+func (b *Broker) enqueue(env *envelope) error {
+	b.seq++
+	env.Seq = b.seq
+	if err := b.journal.put(queueMain, env); err != nil {
+		return err
+	}
+	b.schedule(env)
+	return nil
+}
+
+// schedule adds a journaled message to the queue or, until it is due, to the delayed messages;
+// b.mu must be held
+func (b *Broker) schedule(env *envelope) {
+	if wait := time.Until(env.Due); !env.Due.IsZero() && wait > 0 {
+		seq := env.Seq
+		b.pending[seq] = env
+		b.delayed[seq] = time.AfterFunc(wait, func() { b.promote(seq) })
+		return
+	}
+	b.ready = append(b.ready, env)
+	b.notify()
+}
+
+// promote moves a delayed message to the queue once it is due
+func (b *Broker) promote(seq uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	env, ok := b.pending[seq]
+	if !ok || b.closed {
+		return
+	}
+	delete(b.pending, seq)
+	delete(b.delayed, seq)
+	b.ready = append(b.ready, env)
+	b.notify()
+}
+
+// enqueueDead journals a message and adds it to the dead-letter queue; b.mu must be held
+func (b *Broker) enqueueDead(env *envelope) error {
+	b.seq++
+	env.Seq = b.seq
+	if err := b.journal.put(queueDead, env); err != nil {
+		return err
+	}
+	b.dead = append(b.dead, env)
+	return nil
+}
+
+// notify wakes every waiting publisher and subscriber; b.mu must be held
+func (b *Broker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// copyHeaders returns a copy of headers that can be modified for a requeued message
+func copyHeaders(headers map[string]any) map[string]any {
+	out := make(map[string]any, len(headers)+4)
+	for k, v := range headers {
+		out[k] = v
+	}
+	return out
+}
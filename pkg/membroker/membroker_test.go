@@ -0,0 +1,212 @@
+package membroker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals_backend_task/pkg/brokertest"
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: brokertest.MaxAttempts, InitialDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+func newTestBroker(t *testing.T, opts ...Option) *Broker {
+	b, err := New("users", append([]Option{WithRetryPolicy(testRetryPolicy)}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestBroker(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		return newTestBroker(t)
+	})
+}
+
+func TestBroker_Journal(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		return newTestBroker(t, WithJournal(filepath.Join(t.TempDir(), "queue.jsonl")))
+	})
+}
+
+func TestBroker_JournalCompactingOften(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		return newTestBroker(t, WithJournal(filepath.Join(t.TempDir(), "queue.jsonl")), WithCompactThreshold(1))
+	})
+}
+
+func TestBroker_Capacity(t *testing.T) {
+	b := newTestBroker(t, WithCapacity(1))
+	ctx := context.Background()
+
+	require.NoError(t, b.Publish(ctx, []byte("first")))
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.Publish(timeout, []byte("second")), context.DeadlineExceeded)
+
+	// acking the first message makes room for the second
+	messages, err := b.Subscribe(ctx)
+	require.NoError(t, err)
+	published := make(chan error, 1)
+	go func() { published <- b.Publish(ctx, []byte("second")) }()
+
+	require.NoError(t, (<-messages).Ack())
+	require.NoError(t, <-published)
+	assert.Equal(t, "second", string((<-messages).Body))
+}
+
+func TestBroker_RedeliverUnacked(t *testing.T) {
+	b := newTestBroker(t)
+	ctx := context.Background()
+	require.NoError(t, b.Publish(ctx, []byte("unacked")))
+
+	subCtx, cancel := context.WithCancel(ctx)
+	messages, err := b.Subscribe(subCtx)
+	require.NoError(t, err)
+	m := <-messages
+	cancel()
+	for range messages {
+	}
+
+	// the message is settled by the ended subscription's requeue, not by a late ack
+	assert.ErrorIs(t, m.Ack(), ErrSettled)
+
+	messages, err = b.Subscribe(ctx)
+	require.NoError(t, err)
+	again := <-messages
+	assert.Equal(t, "unacked", string(again.Body))
+	assert.NoError(t, again.Ack())
+	assert.ErrorIs(t, again.Ack(), ErrSettled)
+}
+
+func TestBroker_JournalRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	ctx := context.Background()
+
+	b, err := New("users", WithJournal(path), WithRetryPolicy(testRetryPolicy))
+	require.NoError(t, err)
+	for _, body := range []string{"acked", "unacked", "queued", "dead"} {
+		require.NoError(t, b.Publish(ctx, []byte(body)))
+	}
+	messages, err := b.Subscribe(ctx)
+	require.NoError(t, err)
+	require.NoError(t, (<-messages).Ack())
+	<-messages // left unacked
+	third := <-messages
+	require.NoError(t, third.Nack(true))
+	require.NoError(t, b.DeadLetter(ctx, <-messages, models.Failure{Reason: "bad"}))
+	require.NoError(t, b.Close())
+
+	restored := newTestBroker(t, WithJournal(path))
+	assert.Equal(t, 2, restored.Len())
+
+	messages, err = restored.Subscribe(ctx)
+	require.NoError(t, err)
+	for _, want := range []string{"unacked", "queued"} {
+		m := <-messages
+		assert.Equal(t, want, string(m.Body))
+		require.NoError(t, m.Ack())
+	}
+
+	letters, err := restored.ListDeadLetters(ctx, 0)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "bad", letters[0].Reason)
+}
+
+func TestBroker_JournalCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	ctx := context.Background()
+
+	b, err := New("users", WithJournal(path), WithRetryPolicy(testRetryPolicy), WithCompactThreshold(5))
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, b.Publish(ctx, []byte("acked")))
+	}
+	messages, err := b.Subscribe(ctx)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, (<-messages).Ack())
+	}
+	require.NoError(t, b.Publish(ctx, []byte("queued")))
+	require.NoError(t, b.Close())
+
+	// without compaction the journal would hold 41 records
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, bytes.Count(data, []byte("\n")), 6)
+
+	restored := newTestBroker(t, WithJournal(path))
+	assert.Equal(t, 1, restored.Len())
+	messages, err = restored.Subscribe(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "queued", string((<-messages).Body))
+}
+
+// failingWriter passes the first ok writes on to w and fails the rest, like a disk filling up
+type failingWriter struct {
+	w  io.Writer
+	ok int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.ok == 0 {
+		return 0, errors.New("disk full")
+	}
+	f.ok--
+	return f.w.Write(p)
+}
+
+func TestBroker_JournalReplayFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   int
+		dead int
+	}{
+		{"removing the dead letter", 0, 1},
+		// the dead letter is already gone from the journal, so nothing is replayed twice
+		{"queueing the fresh copy", 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "queue.jsonl")
+			ctx := context.Background()
+
+			b, err := New("users", WithJournal(path), WithRetryPolicy(testRetryPolicy))
+			require.NoError(t, err)
+			require.NoError(t, b.Publish(ctx, []byte("dead")))
+			messages, err := b.Subscribe(ctx)
+			require.NoError(t, err)
+			require.NoError(t, b.DeadLetter(ctx, <-messages, models.Failure{Reason: "bad"}))
+
+			b.mu.Lock()
+			b.journal.w = bufio.NewWriter(&failingWriter{w: b.journal.file, ok: tt.ok})
+			b.mu.Unlock()
+			replayed, err := b.ReplayDeadLetters(ctx, nil)
+			require.Error(t, err)
+			assert.Zero(t, replayed)
+			assert.Zero(t, b.Len())
+			letters, err := b.ListDeadLetters(ctx, 0)
+			require.NoError(t, err)
+			assert.Len(t, letters, 1)
+			b.Close()
+
+			restored := newTestBroker(t, WithJournal(path))
+			assert.Zero(t, restored.Len())
+			letters, err = restored.ListDeadLetters(ctx, 0)
+			require.NoError(t, err)
+			assert.Len(t, letters, tt.dead)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Failure describes why a message could not be processed and is dead-lettered
+type Failure struct {
+	// Reason is the error message
+	Reason string
+	// Class is the type of the underlying error, e.g. "*json.SyntaxError"
+	Class string
+	// Code is the SQLSTATE code of a database error, if any
+	Code string
+}
+
+// ErrDeadLetterNotFound is returned when no dead-lettered message has the requested ID
+var ErrDeadLetterNotFound = errors.New("dead-lettered message not found")
+
+// DeadLetter is a message in the dead-letter queue together with why it failed
+type DeadLetter struct {
+	ID            string     `json:"id"`
+	Reason        string     `json:"failure_reason"`
+	Class         string     `json:"exception_class,omitempty"`
+	Code          string     `json:"pg_error_code,omitempty"`
+	OriginalQueue string     `json:"original_queue"`
+	Attempts      int32      `json:"attempt_count"`
+	FailedAt      *time.Time `json:"failed_at,omitempty"`
+	Size          int        `json:"size"`
+	Body          string     `json:"body,omitempty"`
+}
+
+// DeadLetterOf reads the failure headers of a dead-lettered message; the body is only kept when withBody is set
+func DeadLetterOf(id string, headers map[string]any, body []byte, withBody bool) DeadLetter {
+	letter := DeadLetter{
+		ID:       id,
+		Attempts: AttemptCount(headers),
+		Size:     len(body),
+	}
+	letter.Reason, _ = headers[HeaderFailureReason].(string)
+	letter.Class, _ = headers[HeaderExceptionClass].(string)
+	letter.OriginalQueue, _ = headers[HeaderOriginalQueue].(string)
+	letter.Code, _ = headers[HeaderPgErrorCode].(string)
+	if v, ok := headers[HeaderFailedAt].(string); ok {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			letter.FailedAt = &t
+		}
+	}
+
+	if withBody {
+		letter.Body = string(body)
+	}
+	return letter
+}
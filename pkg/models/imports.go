@@ -0,0 +1,42 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrImportNotFound is returned for an unknown import job ID
+	ErrImportNotFound = errors.New("import not found")
+	// ErrInvalidImport is returned for an upload that cannot be ingested, such as an unknown format or missing columns
+	ErrInvalidImport = errors.New("invalid import")
+)
+
+type ImportStatus string
+
+const (
+	ImportQueued    ImportStatus = "queued"
+	ImportRunning   ImportStatus = "running"
+	ImportCompleted ImportStatus = "completed"
+	ImportFailed    ImportStatus = "failed"
+)
+
+// ImportJob reports the progress of a file uploaded through the imports API
+type ImportJob struct {
+	ID          string           `json:"id"`
+	FileName    string           `json:"file_name"`
+	Status      ImportStatus     `json:"status"`
+	Error       string           `json:"error,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	StartedAt   *time.Time       `json:"started_at,omitempty"`
+	FinishedAt  *time.Time       `json:"finished_at,omitempty"`
+	BytesRead   int64            `json:"bytes_read"`
+	BytesTotal  int64            `json:"bytes_total"`
+	RowsRead    int64            `json:"rows_read"`
+	Valid       int64            `json:"valid"`
+	Published   int64            `json:"published"`
+	Failed      int64            `json:"failed"`
+	Rejected    int64            `json:"rejected"`
+	Rejects     map[string]int64 `json:"reject_reasons,omitempty"`
+	RejectsFile string           `json:"rejects_file,omitempty"`
+}
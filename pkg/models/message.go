@@ -0,0 +1,83 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Headers recorded on retried and dead-lettered messages
+const (
+	HeaderFailureReason  = "x-failure-reason"
+	HeaderExceptionClass = "x-exception-class"
+	HeaderOriginalQueue  = "x-original-queue"
+	HeaderAttemptCount   = "x-attempt-count"
+	HeaderFailedAt       = "x-failed-at"
+	HeaderPgErrorCode    = "x-pg-error-code"
+)
+
+// ErrNotSettleable is returned when a message without settle functions is acked, nacked or rejected
+var ErrNotSettleable = errors.New("message cannot be settled")
+
+// Message is a message received from a message broker. The broker fills in the functions that
+// settle it, so consumers do not depend on the broker in use.
+type Message struct {
+	ID          string
+	Body        []byte
+	Headers     map[string]any
+	ContentType string
+
+	// AckFunc removes the message from the queue once it was processed
+	AckFunc func() error
+	// NackFunc returns the message to the queue, or drops it when requeue is false
+	NackFunc func(requeue bool) error
+	// RejectFunc refuses the message; the queue may dead-letter it when requeue is false
+	RejectFunc func(requeue bool) error
+}
+
+// Ack acknowledges the message
+func (m Message) Ack() error {
+	if m.AckFunc == nil {
+		return ErrNotSettleable
+	}
+	return m.AckFunc()
+}
+
+// Nack negatively acknowledges the message
+func (m Message) Nack(requeue bool) error {
+	if m.NackFunc == nil {
+		return ErrNotSettleable
+	}
+	return m.NackFunc(requeue)
+}
+
+// Reject rejects the message
+func (m Message) Reject(requeue bool) error {
+	if m.RejectFunc == nil {
+		return ErrNotSettleable
+	}
+	return m.RejectFunc(requeue)
+}
+
+// Attempt reads the x-attempt-count header of the message; a message seen for the first time is attempt 1
+func (m Message) Attempt() int32 {
+	return AttemptCount(m.Headers)
+}
+
+// AttemptCount reads the x-attempt-count header, which brokers may hand back as any integer type,
+// a float after a JSON round trip or a string
+func AttemptCount(headers map[string]any) int32 {
+	var n int64
+	switch v := headers[HeaderAttemptCount].(type) {
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case int:
+		n = int64(v)
+	case float64:
+		n = int64(v)
+	case string:
+		n, _ = strconv.ParseInt(v, 10, 32)
+	}
+	return int32(max(n, 1))
+}
@@ -2,6 +2,9 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
 )
 
 type UserDetails struct {
@@ -14,3 +17,54 @@ type UserDetails struct {
 	MergedAt     sql.NullTime `json:"merged_at" db:"merged_at"`
 	ParentUserId int64        `json:"parent_user_id" db:"parent_user_id"`
 }
+
+// Version is the latest of the user's timestamps: a user only changes by being deleted or
+// merged, so the copy with the latest timestamp is the newest. It is zero if none is set.
+func (u *UserDetails) Version() time.Time {
+	var version time.Time
+	for _, t := range []sql.NullTime{u.CreatedAt, u.DeletedAt, u.MergedAt} {
+		if t.Valid && t.Time.After(version) {
+			version = t.Time
+		}
+	}
+	return version
+}
+
+// ConflictPolicy decides what a bulk write does with a user whose ID already exists
+type ConflictPolicy string
+
+const (
+	// ConflictSkip keeps the stored user
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the stored user
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictNewest replaces the stored user only if the incoming one has a newer Version
+	ConflictNewest ConflictPolicy = "newest"
+)
+
+// ParseConflictPolicy validates a policy name; an empty name is ConflictSkip
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(strings.ToLower(strings.TrimSpace(name))); policy {
+	case "":
+		return ConflictSkip, nil
+	case ConflictSkip, ConflictOverwrite, ConflictNewest:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unsupported conflict policy %q", name)
+}
+
+// BulkResult counts what a bulk write did with each user of a batch
+type BulkResult struct {
+	Inserted int64 `json:"inserted"`
+	Updated  int64 `json:"updated"`
+	Skipped  int64 `json:"skipped"`
+	// Written holds the inserted and updated users
+	Written []*UserDetails `json:"-"`
+}
+
+// Add accumulates the counts of another result; Written is not kept
+func (r *BulkResult) Add(other BulkResult) {
+	r.Inserted += other.Inserted
+	r.Updated += other.Updated
+	r.Skipped += other.Skipped
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// transientClasses are the SQLSTATE classes of errors that may succeed when retried:
+// connection exceptions, transaction rollbacks (serialization failures and deadlocks),
+// insufficient resources, operator intervention (e.g. admin shutdown) and system errors.
+var transientClasses = map[pq.ErrorClass]bool{
+	"08": true,
+	"40": true,
+	"53": true,
+	"57": true,
+	"58": true,
+}
+
+// IsTransient reports whether a database error is worth retrying, such as a timeout or a
+// lost connection, as opposed to a data error that will fail again.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return transientClasses[pqErr.Code.Class()]
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF),
+		errors.As(err, &netErr):
+		return true
+	}
+	return false
+}
+
+// ErrorCode returns the SQLSTATE code of a Postgres error, or "" if err did not come from Postgres
+func ErrorCode(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package rabbitmq
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// connection is the part of *amqp.Connection the client uses
+type connection interface {
+	Channel() (channel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// channel is the part of *amqp.Channel the client uses
+type channel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueDeclarePassive(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	QueuePurge(name string, noWait bool) (int, error)
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Confirm(noWait bool) error
+
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	NotifyReturn(receiver chan amqp.Return) chan amqp.Return
+	NotifyPublish(receiver chan amqp.Confirmation) chan amqp.Confirmation
+
+	GetNextPublishSeqNo() uint64
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	ConsumeWithContext(ctx context.Context, queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Close() error
+}
+
+// dialer opens a connection to the broker at uri
+type dialer func(uri string) (connection, error)
+
+// amqpConnection adapts *amqp.Connection to connection
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+// Channel opens a channel on the connection
+func (c amqpConnection) Channel() (channel, error) {
+	ch, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
+
+// dialAMQP connects to a real broker
+func dialAMQP(uri string) (connection, error) {
+	conn, err := amqp.Dial(uri)
+	if err != nil {
+		return nil, err
+	}
+	return amqpConnection{conn}, nil
+}
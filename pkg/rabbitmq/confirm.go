@@ -0,0 +1,175 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// headerPublishTag carries the delivery tag of a publish so a basic.return, which has no
+// delivery tag of its own, can be matched to the publish it belongs to
+const headerPublishTag = "x-publish-tag"
+
+// session is one connection and the channel its publishes, confirms and consumers share
+type session struct {
+	conn connection
+	ch   channel
+
+	// publishMu keeps delivery tags in the order the messages are sent
+	publishMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint64]*pendingConfirm
+	ended   bool
+}
+
+// pendingConfirm is resolved by the confirm of a publish, or by the end of its session
+type pendingConfirm struct {
+	done     chan struct{}
+	acked    bool
+	returned *amqp.Return
+	err      error
+}
+
+// newSession tracks the confirms and returns of a channel already in confirm mode
+func newSession(conn connection, ch channel) *session {
+	s := &session{conn: conn, ch: ch, pending: make(map[uint64]*pendingConfirm)}
+	// unbuffered, so the reader of the connection hands over a return before the confirm after it
+	go s.watch(ch.NotifyReturn(make(chan amqp.Return)), ch.NotifyPublish(make(chan amqp.Confirmation)))
+	return s
+}
+
+// publish sends a mandatory message and returns the confirm to wait for. The confirm is
+// registered before the message is sent, so neither its return nor its ack can be missed.
+func (s *session) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) (*pendingConfirm, error) {
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	tag := s.ch.GetNextPublishSeqNo()
+	msg.Headers = copyHeaders(msg.Headers)
+	msg.Headers[headerPublishTag] = int64(tag)
+
+	p := &pendingConfirm{done: make(chan struct{})}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return nil, ErrNotConnected
+	}
+	s.pending[tag] = p
+	s.mu.Unlock()
+
+	if err := s.ch.PublishWithContext(ctx, exchange, key, true, false, msg); err != nil {
+		s.mu.Lock()
+		delete(s.pending, tag)
+		s.mu.Unlock()
+		return nil, err
+	}
+	return p, nil
+}
+
+// watch resolves pending publishes until the channel closes. Returns and confirms are read in
+// one goroutine: the broker sends the return of an unroutable message before its ack, and
+// both listeners are unbuffered, so the return is recorded before the ack resolves the publish.
+func (s *session) watch(returns <-chan amqp.Return, confirms <-chan amqp.Confirmation) {
+	for returns != nil || confirms != nil {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			s.returned(ret)
+		case c, ok := <-confirms:
+			if !ok {
+				confirms = nil
+				continue
+			}
+			s.confirmed(c)
+		}
+	}
+	s.end()
+}
+
+// returned records that the broker could not route a publish
+func (s *session) returned(ret amqp.Return) {
+	tag, ok := ret.Headers[headerPublishTag].(int64)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.pending[uint64(tag)]; ok {
+		p.returned = &ret
+	}
+}
+
+// confirmed resolves a publish. Publishes whose caller gave up are still resolved here,
+// which is what removes them.
+func (s *session) confirmed(c amqp.Confirmation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pending[c.DeliveryTag]
+	if !ok {
+		return
+	}
+	delete(s.pending, c.DeliveryTag)
+	p.acked = c.Ack
+	close(p.done)
+}
+
+// end fails every publish still waiting, as the closed channel will never confirm them
+func (s *session) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ended = true
+	for tag, p := range s.pending {
+		p.err = ErrNotConnected
+		close(p.done)
+		delete(s.pending, tag)
+	}
+}
+
+// close closes the channel and the connection of the session
+func (s *session) close() error {
+	var err error
+	if cerr := s.ch.Close(); cerr != nil && !errors.Is(cerr, amqp.ErrClosed) {
+		err = cerr
+	}
+	if cerr := s.conn.Close(); cerr != nil && !errors.Is(cerr, amqp.ErrClosed) {
+		err = errors.Join(err, cerr)
+	}
+	return err
+}
+
+// waiting reports how many publishes are still unconfirmed
+func (s *session) waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// wait blocks until the publish is resolved or ctx is done. A publish given up on stays
+// pending until its confirm arrives or the session ends.
+func (p *pendingConfirm) wait(ctx context.Context) error {
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	switch {
+	case p.err != nil:
+		return p.err
+	case !p.acked:
+		return ErrNacked
+	case p.returned != nil:
+		return fmt.Errorf("%w: %d %s", ErrUnroutable, p.returned.ReplyCode, p.returned.ReplyText)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+// ErrDeadLetterDisabled is returned when the topology has no dead-letter exchange
+var ErrDeadLetterDisabled = errors.New("dead-lettering is disabled")
+
+// maxDeadLetterScan bounds how many messages a single inspection or replay walks through
+const maxDeadLetterScan = 10000
+
+// DeadLetter moves a message to the dead-letter queue: a copy carrying the failure headers is
+// published to the dead-letter exchange and the original is acked once the copy is confirmed.
+// If the copy cannot be published the original is rejected without requeue, which the queue
+// still routes to the dead-letter exchange, only without the failure headers.
+func (r *RabbitMQ) DeadLetter(ctx context.Context, m models.Message, failure models.Failure) error {
+	if err := r.PublishDeadLetter(ctx, m, failure); err != nil {
+		return errors.Join(err, m.Nack(false))
+	}
+	return m.Ack()
+}
+
+// PublishDeadLetter publishes a copy of a message carrying the failure headers to the
+// dead-letter exchange, without settling the message. It dead-letters part of a message,
+// such as one bad record of a batch, by passing a message whose body holds only that part.
+func (r *RabbitMQ) PublishDeadLetter(ctx context.Context, m models.Message, failure models.Failure) error {
+	if r.topology.DeadLetterExchange == "" {
+		return ErrDeadLetterDisabled
+	}
+
+	headers := copyHeaders(m.Headers)
+	headers[models.HeaderFailureReason] = failure.Reason
+	headers[models.HeaderExceptionClass] = failure.Class
+	headers[models.HeaderOriginalQueue] = r.topology.Queue
+	headers[models.HeaderAttemptCount] = m.Attempt()
+	headers[models.HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+	if failure.Code != "" {
+		headers[models.HeaderPgErrorCode] = failure.Code
+	}
+
+	messageID := m.ID
+	if messageID == "" {
+		messageID = uuid.NewString()
+	}
+
+	msg := amqp.Publishing{
+		Headers:      headers,
+		MessageId:    messageID,
+		Body:         m.Body,
+		ContentType:  m.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    time.Now(),
+	}
+	return r.publish(ctx, r.topology.DeadLetterExchange, r.topology.Queue, msg)
+}
+
+// Retry schedules a message that failed transiently for another attempt once the backoff of
+// its attempt has passed. The attempt count travels in the x-attempt-count header, so it
+// survives consumer restarts. A message on its last attempt is dead-lettered instead.
+func (r *RabbitMQ) Retry(ctx context.Context, m models.Message, failure models.Failure) error {
+	attempt := m.Attempt()
+	if attempt >= int32(r.topology.Retry.MaxAttempts) {
+		return r.DeadLetter(ctx, m, failure)
+	}
+
+	headers := copyHeaders(m.Headers)
+	headers[models.HeaderAttemptCount] = attempt + 1
+	headers[models.HeaderFailureReason] = failure.Reason
+	headers[models.HeaderExceptionClass] = failure.Class
+
+	msg := amqp.Publishing{
+		Headers:      headers,
+		MessageId:    m.ID,
+		Body:         m.Body,
+		ContentType:  m.ContentType,
+		DeliveryMode: r.deliveryMode,
+	}
+	// the delay queue is addressed directly through the default exchange
+	if err := r.publish(ctx, "", r.topology.retryQueue(r.topology.retryDelay(attempt)), msg); err != nil {
+		// requeued at once rather than lost
+		return errors.Join(err, m.Nack(true))
+	}
+	return m.Ack()
+}
+
+// copyHeaders returns a copy of headers that can be modified for a republished message
+func copyHeaders(headers map[string]any) amqp.Table {
+	out := make(amqp.Table, len(headers)+4)
+	for k, v := range headers {
+		out[k] = v
+	}
+	return out
+}
+
+// ListDeadLetters returns up to limit messages from the head of the dead-letter queue, without
+// their bodies. The messages stay in the queue.
+func (r *RabbitMQ) ListDeadLetters(ctx context.Context, limit int) ([]models.DeadLetter, error) {
+	if limit <= 0 || limit > maxDeadLetterScan {
+		limit = maxDeadLetterScan
+	}
+
+	letters := []models.DeadLetter{}
+	err := r.scanDeadLetters(ctx, limit, func(d amqp.Delivery) (bool, error) {
+		letters = append(letters, deadLetterOf(d, false))
+		return false, nil
+	})
+	return letters, err
+}
+
+// PeekDeadLetter returns a dead-lettered message with its body, leaving it in the queue
+func (r *RabbitMQ) PeekDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error) {
+	var found *models.DeadLetter
+	err := r.scanDeadLetters(ctx, maxDeadLetterScan, func(d amqp.Delivery) (bool, error) {
+		if d.MessageId != id {
+			return false, nil
+		}
+		letter := deadLetterOf(d, true)
+		found = &letter
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, models.ErrDeadLetterNotFound
+	}
+	return found, nil
+}
+
+// ReplayDeadLetters publishes dead-lettered messages back to the main queue as fresh messages,
+// without their failure headers, and removes them from the dead-letter queue. An empty ids
+// replays every message that was in the queue when the replay started.
+func (r *RabbitMQ) ReplayDeadLetters(ctx context.Context, ids []string) (int, error) {
+	selected := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	replayed := 0
+	err := r.scanDeadLetters(ctx, maxDeadLetterScan, func(d amqp.Delivery) (bool, error) {
+		if len(selected) > 0 && !selected[d.MessageId] {
+			return false, nil
+		}
+
+		msg := amqp.Publishing{
+			Headers:      replayHeaders(d.Headers),
+			MessageId:    d.MessageId,
+			Body:         d.Body,
+			ContentType:  d.ContentType,
+			DeliveryMode: r.deliveryMode,
+		}
+		if err := r.publish(ctx, r.topology.Exchange, r.topology.routingKey(), msg); err != nil {
+			return true, err
+		}
+		if err := d.Ack(false); err != nil {
+			return true, err
+		}
+
+		replayed++
+		delete(selected, d.MessageId)
+		// a selective replay stops once every requested message was found
+		return len(ids) > 0 && len(selected) == 0, nil
+	})
+	return replayed, err
+}
+
+// PurgeDeadLetters deletes every message in the dead-letter queue, returning how many were removed
+func (r *RabbitMQ) PurgeDeadLetters(ctx context.Context) (int, error) {
+	if r.topology.DeadLetterExchange == "" {
+		return 0, ErrDeadLetterDisabled
+	}
+
+	ch, err := r.adminChannel(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+	return ch.QueuePurge(r.topology.DeadLetterQueue, false)
+}
+
+// scanDeadLetters gets the messages that are in the dead-letter queue when it starts, up to limit,
+// and calls visit for each until it returns true. Messages visit does not ack are requeued in
+// their original order when the scan ends.
+func (r *RabbitMQ) scanDeadLetters(ctx context.Context, limit int, visit func(amqp.Delivery) (bool, error)) error {
+	if r.topology.DeadLetterExchange == "" {
+		return ErrDeadLetterDisabled
+	}
+
+	ch, err := r.adminChannel(ctx)
+	if err != nil {
+		return err
+	}
+	// closing the channel requeues every message that was got but not acked
+	defer ch.Close()
+
+	// replayed messages may fail again while scanning, so only the current messages are visited
+	q, err := ch.QueueDeclarePassive(r.topology.DeadLetterQueue, r.topology.Durable, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	limit = min(limit, q.Messages)
+
+	for i := 0; i < limit; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		d, ok, err := ch.Get(r.topology.DeadLetterQueue, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+
+		stop, err := visit(d)
+		if err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+// adminChannel opens a channel of its own, so messages got from the dead-letter queue are not
+// mixed with the deliveries and confirms of the shared channel
+func (r *RabbitMQ) adminChannel(ctx context.Context) (channel, error) {
+	s, err := r.session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.conn.Channel()
+}
+
+// deadLetterOf reads the failure headers of a dead-lettered message. Messages rejected without
+// going through DeadLetter only carry the broker's x-death header, which is used instead.
+func deadLetterOf(d amqp.Delivery, withBody bool) models.DeadLetter {
+	letter := models.DeadLetterOf(d.MessageId, d.Headers, d.Body, withBody)
+
+	if deaths, ok := d.Headers["x-death"].([]interface{}); ok && len(deaths) > 0 {
+		if death, ok := deaths[0].(amqp.Table); ok {
+			if letter.Reason == "" {
+				reason, _ := death["reason"].(string)
+				letter.Reason = "rejected by broker: " + reason
+			}
+			if letter.OriginalQueue == "" {
+				letter.OriginalQueue, _ = death["queue"].(string)
+			}
+			if t, ok := death["time"].(time.Time); ok && letter.FailedAt == nil {
+				letter.FailedAt = &t
+			}
+		}
+	}
+	return letter
+}
+
+// replayHeaders drops the failure and attempt headers so a replayed message starts over
+func replayHeaders(headers amqp.Table) amqp.Table {
+	out := amqp.Table{}
+	for k, v := range headers {
+		switch {
+		case k == models.HeaderFailureReason, k == models.HeaderExceptionClass, k == models.HeaderOriginalQueue,
+			k == models.HeaderAttemptCount, k == models.HeaderFailedAt, k == models.HeaderPgErrorCode:
+			continue
+		case k == "x-death", strings.HasPrefix(k, "x-first-death-"), strings.HasPrefix(k, "x-last-death-"):
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
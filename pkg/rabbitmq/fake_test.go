@@ -0,0 +1,513 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// outcome is how the fake broker answers a publish
+type outcome int
+
+const (
+	outcomeAck outcome = iota
+	outcomeNack
+	// outcomeReturn returns the message as unroutable, then acks it like RabbitMQ does
+	outcomeReturn
+	// outcomeHold keeps the confirm back until release is called
+	outcomeHold
+)
+
+// errBrokerDown is returned by dial while the fake broker is down
+var errBrokerDown = errors.New("fake broker is down")
+
+// fakeBroker stands in for RabbitMQ with a single queue. Like amqp091, every channel hands its
+// returns and confirms to the listeners from a single goroutine, a return before the confirm
+// after it. Acked publishes are queued; deliveries left unacked by a closed channel are requeued.
+type fakeBroker struct {
+	mu        sync.Mutex
+	outcome   func(amqp.Publishing) outcome
+	published []amqp.Publishing
+	held      []func(returned bool)
+	conns     []*fakeConnection
+	down      bool
+
+	queue []queued
+	// queuedSignal is closed and replaced whenever a message is queued
+	queuedSignal chan struct{}
+}
+
+// queued is a message waiting in the queue of the fake broker
+type queued struct {
+	msg         amqp.Publishing
+	redelivered bool
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{
+		outcome:      func(amqp.Publishing) outcome { return outcomeAck },
+		queuedSignal: make(chan struct{}),
+	}
+}
+
+// dial opens a connection to the fake broker
+func (b *fakeBroker) dial(string) (connection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.down {
+		return nil, errBrokerDown
+	}
+
+	conn := &fakeConnection{broker: b}
+	b.conns = append(b.conns, conn)
+	return conn, nil
+}
+
+// drop closes the latest connection as a broker restart would, and keeps refusing new
+// connections while down is set
+func (b *fakeBroker) drop(down bool) {
+	b.mu.Lock()
+	b.down = down
+	conn := b.conns[len(b.conns)-1]
+	b.mu.Unlock()
+
+	conn.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED - broker forced connection closure"})
+}
+
+// up accepts connections again after drop
+func (b *fakeBroker) up() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.down = false
+}
+
+// enqueue appends messages to the queue, or puts them back at its head, and wakes the consumers.
+// It must be called with b.mu held.
+func (b *fakeBroker) enqueue(front bool, msgs ...queued) {
+	if front {
+		b.queue = append(append([]queued(nil), msgs...), b.queue...)
+	} else {
+		b.queue = append(b.queue, msgs...)
+	}
+	close(b.queuedSignal)
+	b.queuedSignal = make(chan struct{})
+}
+
+// next takes the message at the head of the queue, waiting for one until done is closed
+func (b *fakeBroker) next(done <-chan struct{}) (queued, bool) {
+	for {
+		b.mu.Lock()
+		if len(b.queue) > 0 {
+			q := b.queue[0]
+			b.queue = b.queue[1:]
+			b.mu.Unlock()
+			return q, true
+		}
+		signal := b.queuedSignal
+		b.mu.Unlock()
+
+		select {
+		case <-signal:
+		case <-done:
+			return queued{}, false
+		}
+	}
+}
+
+// answer sets how later publishes are answered
+func (b *fakeBroker) answer(fn func(amqp.Publishing) outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.outcome = fn
+}
+
+// release sends the confirms held back so far, returning each message first if returned is set
+func (b *fakeBroker) release(returned bool) {
+	b.mu.Lock()
+	held := b.held
+	b.held = nil
+	b.mu.Unlock()
+
+	for _, send := range held {
+		send(returned)
+	}
+}
+
+// publishes returns every message published so far
+func (b *fakeBroker) publishes() []amqp.Publishing {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]amqp.Publishing(nil), b.published...)
+}
+
+type fakeConnection struct {
+	broker *fakeBroker
+
+	mu       sync.Mutex
+	closed   bool
+	channels []*fakeChannel
+	closes   []chan *amqp.Error
+}
+
+func (c *fakeConnection) Channel() (channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	ch := &fakeChannel{conn: c, events: make(chan func(), 1024), done: make(chan struct{})}
+	c.channels = append(c.channels, ch)
+	go ch.dispatch()
+	return ch, nil
+}
+
+func (c *fakeConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+	c.closes = append(c.closes, receiver)
+	return receiver
+}
+
+func (c *fakeConnection) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+// shutdown closes the connection and its channels, reporting reason to the close listeners
+func (c *fakeConnection) shutdown(reason *amqp.Error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	channels, closes := c.channels, c.closes
+	c.mu.Unlock()
+
+	for _, ch := range channels {
+		ch.shutdown(reason)
+	}
+	for _, l := range closes {
+		if reason != nil {
+			l <- reason
+		}
+		close(l)
+	}
+}
+
+type fakeChannel struct {
+	conn *fakeConnection
+
+	// events are run in order by dispatch, like the frames read from a connection
+	events chan func()
+	done   chan struct{}
+
+	mu         sync.Mutex
+	closed     bool
+	confirming bool
+	published  uint64
+	delivered  uint64
+	unacked    map[uint64]queued
+	returns    []chan amqp.Return
+	confirms   []chan amqp.Confirmation
+	closes     []chan *amqp.Error
+}
+
+// dispatch runs the events of the channel until it is closed, then closes its listeners
+func (ch *fakeChannel) dispatch() {
+	for {
+		select {
+		case event := <-ch.events:
+			event()
+		case <-ch.done:
+			ch.mu.Lock()
+			defer ch.mu.Unlock()
+			for _, l := range ch.returns {
+				close(l)
+			}
+			for _, l := range ch.confirms {
+				close(l)
+			}
+			return
+		}
+	}
+}
+
+func (ch *fakeChannel) shutdown(reason *amqp.Error) {
+	ch.mu.Lock()
+	if ch.closed {
+		ch.mu.Unlock()
+		return
+	}
+	ch.closed = true
+	closes := ch.closes
+	unacked := ch.unacked
+	ch.unacked = nil
+	ch.mu.Unlock()
+
+	close(ch.done)
+	if len(unacked) > 0 {
+		ch.requeue(unacked)
+	}
+	for _, l := range closes {
+		if reason != nil {
+			l <- reason
+		}
+		close(l)
+	}
+}
+
+// emit queues an event, dropping it once the channel is closed
+func (ch *fakeChannel) emit(event func()) {
+	select {
+	case ch.events <- event:
+	case <-ch.done:
+	}
+}
+
+func (ch *fakeChannel) sendReturn(msg amqp.Publishing, exchange, key string) {
+	ch.mu.Lock()
+	listeners := ch.returns
+	ch.mu.Unlock()
+
+	ret := amqp.Return{ReplyCode: amqp.NoRoute, ReplyText: "NO_ROUTE", Exchange: exchange, RoutingKey: key,
+		MessageId: msg.MessageId, Headers: msg.Headers, Body: msg.Body}
+	for _, l := range listeners {
+		select {
+		case l <- ret:
+		case <-ch.done:
+		}
+	}
+}
+
+func (ch *fakeChannel) sendConfirm(tag uint64, ack bool) {
+	ch.mu.Lock()
+	listeners := ch.confirms
+	ch.mu.Unlock()
+
+	for _, l := range listeners {
+		select {
+		case l <- amqp.Confirmation{DeliveryTag: tag, Ack: ack}:
+		case <-ch.done:
+		}
+	}
+}
+
+func (ch *fakeChannel) ExchangeDeclare(string, string, bool, bool, bool, bool, amqp.Table) error {
+	return nil
+}
+
+func (ch *fakeChannel) QueueDeclare(name string, _, _, _, _ bool, _ amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *fakeChannel) QueueDeclarePassive(name string, _, _, _, _ bool, _ amqp.Table) (amqp.Queue, error) {
+	return amqp.Queue{Name: name}, nil
+}
+
+func (ch *fakeChannel) QueueBind(string, string, string, bool, amqp.Table) error {
+	return nil
+}
+
+func (ch *fakeChannel) QueuePurge(string, bool) (int, error) {
+	return 0, nil
+}
+
+func (ch *fakeChannel) Qos(int, int, bool) error {
+	return nil
+}
+
+func (ch *fakeChannel) Confirm(bool) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.confirming = true
+	return nil
+}
+
+func (ch *fakeChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		close(receiver)
+		return receiver
+	}
+	ch.closes = append(ch.closes, receiver)
+	return receiver
+}
+
+func (ch *fakeChannel) NotifyReturn(receiver chan amqp.Return) chan amqp.Return {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.returns = append(ch.returns, receiver)
+	return receiver
+}
+
+func (ch *fakeChannel) NotifyPublish(receiver chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ch.confirms = append(ch.confirms, receiver)
+	return receiver
+}
+
+func (ch *fakeChannel) GetNextPublishSeqNo() uint64 {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.published + 1
+}
+
+func (ch *fakeChannel) PublishWithContext(_ context.Context, exchange, key string, _, _ bool, msg amqp.Publishing) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.published++
+	tag := ch.published
+
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = append(b.published, msg)
+
+	switch b.outcome(msg) {
+	case outcomeAck:
+		b.enqueue(false, queued{msg: msg})
+		ch.emit(func() { ch.sendConfirm(tag, true) })
+	case outcomeNack:
+		ch.emit(func() { ch.sendConfirm(tag, false) })
+	case outcomeReturn:
+		ch.emit(func() { ch.sendReturn(msg, exchange, key) })
+		ch.emit(func() { ch.sendConfirm(tag, true) })
+	case outcomeHold:
+		b.held = append(b.held, func(returned bool) {
+			if returned {
+				ch.emit(func() { ch.sendReturn(msg, exchange, key) })
+			}
+			ch.emit(func() { ch.sendConfirm(tag, true) })
+		})
+	}
+	return nil
+}
+
+func (ch *fakeChannel) ConsumeWithContext(context.Context, string, string, bool, bool, bool, bool, amqp.Table) (<-chan amqp.Delivery, error) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+
+	deliveries := make(chan amqp.Delivery)
+	go ch.deliver(deliveries)
+	return deliveries, nil
+}
+
+// deliver hands queued messages to a consumer until the channel is closed
+func (ch *fakeChannel) deliver(deliveries chan<- amqp.Delivery) {
+	defer close(deliveries)
+
+	for {
+		q, ok := ch.conn.broker.next(ch.done)
+		if !ok {
+			return
+		}
+
+		ch.mu.Lock()
+		if ch.closed {
+			ch.mu.Unlock()
+			ch.requeue(map[uint64]queued{0: q})
+			return
+		}
+		ch.delivered++
+		tag := ch.delivered
+		if ch.unacked == nil {
+			ch.unacked = make(map[uint64]queued)
+		}
+		ch.unacked[tag] = q
+		ch.mu.Unlock()
+
+		select {
+		case deliveries <- amqp.Delivery{
+			Acknowledger: ch,
+			DeliveryTag:  tag,
+			Redelivered:  q.redelivered,
+			MessageId:    q.msg.MessageId,
+			Headers:      q.msg.Headers,
+			ContentType:  q.msg.ContentType,
+			Body:         q.msg.Body,
+		}:
+		case <-ch.done:
+			// shutdown requeues it with the other unacked deliveries
+			return
+		}
+	}
+}
+
+// requeue puts deliveries back at the head of the queue in their delivery order
+func (ch *fakeChannel) requeue(deliveries map[uint64]queued) {
+	tags := make([]uint64, 0, len(deliveries))
+	for tag := range deliveries {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+
+	msgs := make([]queued, 0, len(tags))
+	for _, tag := range tags {
+		q := deliveries[tag]
+		q.redelivered = true
+		msgs = append(msgs, q)
+	}
+
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.enqueue(true, msgs...)
+}
+
+// settle removes an unacked delivery, requeueing it if asked to
+func (ch *fakeChannel) settle(tag uint64, requeue bool) error {
+	ch.mu.Lock()
+	q, ok := ch.unacked[tag]
+	delete(ch.unacked, tag)
+	closed := ch.closed
+	ch.mu.Unlock()
+
+	if closed {
+		return amqp.ErrClosed
+	}
+	if !ok {
+		return errors.New("unknown delivery tag")
+	}
+	if requeue {
+		ch.requeue(map[uint64]queued{tag: q})
+	}
+	return nil
+}
+
+func (ch *fakeChannel) Ack(tag uint64, _ bool) error {
+	return ch.settle(tag, false)
+}
+
+func (ch *fakeChannel) Nack(tag uint64, _ bool, requeue bool) error {
+	return ch.settle(tag, requeue)
+}
+
+func (ch *fakeChannel) Reject(tag uint64, requeue bool) error {
+	return ch.settle(tag, requeue)
+}
+
+func (ch *fakeChannel) Get(string, bool) (amqp.Delivery, bool, error) {
+	return amqp.Delivery{}, false, nil
+}
+
+func (ch *fakeChannel) Close() error {
+	ch.shutdown(nil)
+	return nil
+}
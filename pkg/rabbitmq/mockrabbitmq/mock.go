@@ -2,8 +2,8 @@ package mockrabbitmq
 
 import (
 	"context"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/mock"
+	"github.com/viswals_backend_task/pkg/models"
 )
 
 type MockRabbitMQ struct {
@@ -15,9 +15,24 @@ func (m *MockRabbitMQ) Publish(ctx context.Context, data []byte) error {
 	return args.Error(0)
 }
 
-func (m *MockRabbitMQ) Subscribe(ctx context.Context) (<-chan amqp.Delivery, error) {
+func (m *MockRabbitMQ) Subscribe(ctx context.Context) (<-chan models.Message, error) {
 	args := m.Called()
-	return args.Get(0).(<-chan amqp.Delivery), args.Error(1)
+	return args.Get(0).(<-chan models.Message), args.Error(1)
+}
+
+func (m *MockRabbitMQ) Retry(ctx context.Context, msg models.Message, failure models.Failure) error {
+	args := m.Called(ctx, msg, failure)
+	return args.Error(0)
+}
+
+func (m *MockRabbitMQ) DeadLetter(ctx context.Context, msg models.Message, failure models.Failure) error {
+	args := m.Called(ctx, msg, failure)
+	return args.Error(0)
+}
+
+func (m *MockRabbitMQ) PublishDeadLetter(ctx context.Context, msg models.Message, failure models.Failure) error {
+	args := m.Called(ctx, msg, failure)
+	return args.Error(0)
 }
 
 func (m *MockRabbitMQ) Close() error {
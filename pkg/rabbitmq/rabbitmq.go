@@ -2,69 +2,499 @@ package rabbitmq
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/viswals_backend_task/pkg/models"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrNacked is returned when the broker negatively acknowledges a published message
+	ErrNacked = errors.New("message nacked by broker")
+	// ErrUnroutable is returned when a mandatory message could not be routed to any queue
+	ErrUnroutable = errors.New("message returned as unroutable")
+	// ErrNotConnected is reported by Health while the connection is being re-established
+	ErrNotConnected = errors.New("rabbitmq not connected")
+	// ErrClosed is returned once Close has been called
+	ErrClosed = errors.New("rabbitmq client closed")
 )
 
+const defaultPrefetch = 100
+
+// RetryPolicy controls how often and how fast a failed publish is retried.
+// Backoff doubles from InitialBackoff up to MaxBackoff with full jitter.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy retries up to 5 times, backing off from 100ms to 5s
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 5 * time.Second}
+}
+
+// DefaultReconnectPolicy reconnects forever, backing off from 500ms to 30s
+func DefaultReconnectPolicy() RetryPolicy {
+	return RetryPolicy{InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second}
+}
+
+// RabbitMQ is a supervised client: when the broker drops the connection it reconnects with
+// backoff, re-declares the topology and resumes every subscription on the same delivery channel.
 type RabbitMQ struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	queue   *amqp.Queue
+	uri          string
+	dial         dialer
+	topology     Topology
+	deliveryMode uint8
+	prefetch     int
+	retry        RetryPolicy
+	reconnect    RetryPolicy
+	logger       *zap.Logger
+
+	mu         sync.RWMutex
+	current    *session
+	ready      chan struct{} // closed while a session is up
+	connected  bool
+	lastErr    error
+	reconnects int64
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
 }
 
-func New(uri string, queueName string) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(uri)
-	if err != nil {
+// Option defines functional options for the RabbitMQ client
+type Option func(*RabbitMQ)
+
+// WithTopology declares the given exchange, queue and binding instead of the default durable queue.
+// An empty queue name is taken from New.
+func WithTopology(topology Topology) Option {
+	return func(r *RabbitMQ) {
+		if topology.Queue == "" {
+			topology.Queue = r.topology.Queue
+		}
+		r.topology = topology
+	}
+}
+
+// WithPersistent selects persistent (the default) or transient delivery mode for published messages
+func WithPersistent(persistent bool) Option {
+	return func(r *RabbitMQ) {
+		r.deliveryMode = amqp.Transient
+		if persistent {
+			r.deliveryMode = amqp.Persistent
+		}
+	}
+}
+
+// WithPrefetch bounds how many unacknowledged deliveries a subscription holds at once
+func WithPrefetch(count int) Option {
+	return func(r *RabbitMQ) {
+		if count > 0 {
+			r.prefetch = count
+		}
+	}
+}
+
+// WithRetryPolicy sets how failed publishes are retried
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(r *RabbitMQ) {
+		if policy.MaxAttempts > 0 {
+			r.retry = policy
+		}
+	}
+}
+
+// WithReconnectPolicy sets the backoff between reconnection attempts; MaxAttempts is ignored
+func WithReconnectPolicy(policy RetryPolicy) Option {
+	return func(r *RabbitMQ) {
+		if policy.InitialBackoff > 0 && policy.MaxBackoff > 0 {
+			r.reconnect = policy
+		}
+	}
+}
+
+// WithLogger reports connection losses and reconnects
+func WithLogger(logger *zap.Logger) Option {
+	return func(r *RabbitMQ) {
+		r.logger = logger
+	}
+}
+
+// withDialer replaces how connections are opened, so tests can run against a fake broker
+func withDialer(dial dialer) Option {
+	return func(r *RabbitMQ) {
+		r.dial = dial
+	}
+}
+
+// New connects to the broker and supervises the connection until Close is called.
+// The first connection must succeed; later losses are recovered in the background.
+func New(uri string, queueName string, opts ...Option) (*RabbitMQ, error) {
+	r := &RabbitMQ{
+		uri:          uri,
+		dial:         dialAMQP,
+		topology:     DefaultTopology(queueName),
+		deliveryMode: amqp.Persistent,
+		prefetch:     defaultPrefetch,
+		retry:        DefaultRetryPolicy(),
+		reconnect:    DefaultReconnectPolicy(),
+		logger:       zap.NewNop(),
+		ready:        make(chan struct{}),
+		closing:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if err := r.topology.validate(); err != nil {
 		return nil, err
 	}
 
-	ch, err := conn.Channel()
-	if err != nil {
+	if err := r.connect(); err != nil {
 		return nil, err
 	}
 
-	// creating a durable queue to ensure data persistence.
-	q, err := ch.QueueDeclare(queueName, false, true, false, false, nil)
+	r.wg.Add(1)
+	go r.supervise()
+	return r, nil
+}
+
+// connect dials the broker, declares the topology and publishes the new session
+func (r *RabbitMQ) connect() error {
+	conn, err := r.dial(r.uri)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
 	if err != nil {
-		// on error declare the queue
-		q, err = ch.QueueDeclare(queueName, false, true, false, false, nil)
-		if err != nil {
-			return nil, err
+		conn.Close()
+		return err
+	}
+
+	// the topology is declared on every connect so a restarted broker gets it back
+	if _, err := r.topology.declare(ch); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// unacknowledged deliveries are bounded so a slow consumer does not buffer the whole queue
+	if err := ch.Qos(r.prefetch, 0, false); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// every publish waits for the broker to confirm it
+	if err := ch.Confirm(false); err != nil {
+		conn.Close()
+		return err
+	}
+
+	s := newSession(conn, ch)
+
+	r.mu.Lock()
+	r.current = s
+	r.connected = true
+	r.lastErr = nil
+	close(r.ready)
+	r.mu.Unlock()
+	return nil
+}
+
+// supervise waits for the session to drop and reconnects until Close is called
+func (r *RabbitMQ) supervise() {
+	defer r.wg.Done()
+
+	for {
+		r.mu.RLock()
+		conn, ch := r.current.conn, r.current.ch
+		r.mu.RUnlock()
+
+		// a listener registered on an already closed connection is closed immediately
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+
+		var reason *amqp.Error
+		select {
+		case <-r.closing:
+			return
+		case reason = <-connClosed:
+		case reason = <-chClosed:
+		}
+
+		select {
+		case <-r.closing:
+			return
+		default:
+		}
+
+		var cause error = ErrNotConnected
+		if reason != nil {
+			cause = reason
+		}
+		r.markDown(cause)
+		r.logger.Warn("RabbitMQ connection lost, reconnecting", zap.Error(cause))
+
+		// a channel can close on its own, so the connection is dropped to start from a clean session
+		conn.Close()
+
+		if !r.redial() {
+			return
+		}
+	}
+}
+
+// redial reconnects with backoff, returning false if Close is called first
+func (r *RabbitMQ) redial() bool {
+	for attempt := 1; ; attempt++ {
+		delay := backoff(r.reconnect, attempt)
+		select {
+		case <-r.closing:
+			return false
+		case <-time.After(delay):
+		}
+
+		err := r.connect()
+		if err == nil {
+			r.mu.Lock()
+			r.reconnects++
+			r.mu.Unlock()
+			r.logger.Info("RabbitMQ reconnected", zap.Int("attempts", attempt))
+			return true
 		}
+
+		r.mu.Lock()
+		r.lastErr = err
+		r.mu.Unlock()
+		r.logger.Warn("RabbitMQ reconnect failed", zap.Error(err), zap.Int("attempt", attempt), zap.Duration("delay", delay))
+	}
+}
+
+// markDown records that the session dropped so callers wait for the next one
+func (r *RabbitMQ) markDown(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.connected {
+		r.connected = false
+		r.ready = make(chan struct{})
+	}
+	r.lastErr = err
+}
+
+// session waits until the client is connected and returns the current session
+func (r *RabbitMQ) session(ctx context.Context) (*session, error) {
+	r.mu.RLock()
+	ready := r.ready
+	r.mu.RUnlock()
+
+	select {
+	case <-ready:
+	case <-r.closing:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 
-	return &RabbitMQ{conn: conn, channel: ch, queue: &q}, nil
+	// ready is only closed once the session below has been stored
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current, nil
 }
 
+// Health returns nil while connected, or why the client is currently disconnected
+func (r *RabbitMQ) Health() error {
+	select {
+	case <-r.closing:
+		return ErrClosed
+	default:
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.connected {
+		return nil
+	}
+	if r.lastErr != nil {
+		return fmt.Errorf("%w: %w", ErrNotConnected, r.lastErr)
+	}
+	return ErrNotConnected
+}
+
+// Reconnects reports how many times the connection has been re-established
+func (r *RabbitMQ) Reconnects() int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.reconnects
+}
+
+// Publish sends a message and waits for the broker to confirm it, retrying nacked,
+// unroutable and failed publishes with exponential backoff until ctx is done.
+// While the connection is being re-established, publishes wait for it.
 func (r *RabbitMQ) Publish(ctx context.Context, data []byte) error {
-	// using default exchange as we only have one queue.
-	err := r.channel.PublishWithContext(ctx, "", r.queue.Name, true, false, amqp.Publishing{
-		Body:        data,
-		ContentType: "application/json",
-	})
+	// the same ID is used for every attempt so consumers can detect duplicates
+	msg := amqp.Publishing{
+		MessageId:    uuid.NewString(),
+		Body:         data,
+		ContentType:  "application/json",
+		DeliveryMode: r.deliveryMode,
+	}
+	return r.publish(ctx, r.topology.Exchange, r.topology.routingKey(), msg)
+}
+
+// publish sends msg to an exchange, retrying until it is confirmed or the retry policy is exhausted
+func (r *RabbitMQ) publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	var err error
+	for attempt := 0; attempt < r.retry.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			if waitErr := sleep(ctx, backoff(r.retry, attempt)); waitErr != nil {
+				return errors.Join(err, waitErr)
+			}
+		}
+
+		err = r.publishOnce(ctx, exchange, key, msg)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || errors.Is(err, ErrClosed) {
+			return err
+		}
+	}
+	return fmt.Errorf("publish failed after %d attempts: %w", r.retry.MaxAttempts, err)
+}
+
+// publishOnce publishes a message and waits for its confirmation
+func (r *RabbitMQ) publishOnce(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	s, err := r.session(ctx)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	confirm, err := s.publish(ctx, exchange, key, msg)
+	if err != nil {
+		return err
+	}
+	return confirm.wait(ctx)
 }
 
-func (r *RabbitMQ) Subscribe(ctx context.Context) (<-chan amqp.Delivery, error) {
-	consume, err := r.channel.ConsumeWithContext(ctx,r.queue.Name, "", true, false, false, false, nil)
+// Subscribe consumes the queue with manual acknowledgements: every delivery must be acked,
+// nacked or rejected by the caller. The returned channel survives reconnects: when the broker
+// drops the connection, consumption resumes on the next session and unacknowledged deliveries
+// are redelivered. It is closed by Close or ctx.
+func (r *RabbitMQ) Subscribe(ctx context.Context) (<-chan models.Message, error) {
+	deliveries, err := r.consume(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return consume, nil
+	out := make(chan models.Message)
+	r.wg.Add(1)
+	go r.forward(ctx, deliveries, out)
+	return out, nil
 }
 
-
-func (r *RabbitMQ) Close() error {
-	err := r.conn.Close()
+// consume starts a consumer on the current session
+func (r *RabbitMQ) consume(ctx context.Context) (<-chan amqp.Delivery, error) {
+	s, err := r.session(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = r.channel.Close()
-	if err != nil {
-		return err
+	return s.ch.ConsumeWithContext(ctx, r.topology.Queue, "", false, false, false, false, nil)
+}
+
+// forward copies deliveries to out as messages, re-consuming after every reconnect
+func (r *RabbitMQ) forward(ctx context.Context, deliveries <-chan amqp.Delivery, out chan<- models.Message) {
+	defer r.wg.Done()
+	defer close(out)
+
+	for {
+		for d := range deliveries {
+			select {
+			case out <- messageOf(d):
+			case <-r.closing:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// the session dropped; wait for the supervisor to bring up the next one
+		for attempt := 1; ; attempt++ {
+			var err error
+			deliveries, err = r.consume(ctx)
+			if err == nil {
+				r.logger.Info("RabbitMQ consumer resumed")
+				break
+			}
+			if errors.Is(err, ErrClosed) || ctx.Err() != nil {
+				return
+			}
+			// the session may still look ready until the supervisor notices it dropped
+			if sleep(ctx, backoff(r.reconnect, attempt)) != nil {
+				return
+			}
+		}
 	}
-	return nil
+}
+
+// messageOf wraps a delivery in a broker-neutral message settled through the delivery
+func messageOf(d amqp.Delivery) models.Message {
+	return models.Message{
+		ID:          d.MessageId,
+		Body:        d.Body,
+		Headers:     d.Headers,
+		ContentType: d.ContentType,
+		AckFunc:     func() error { return d.Ack(false) },
+		NackFunc:    func(requeue bool) error { return d.Nack(false, requeue) },
+		RejectFunc:  func(requeue bool) error { return d.Reject(requeue) },
+	}
+}
+
+// backoff returns a random delay of up to InitialBackoff * 2^(attempt-1), capped at MaxBackoff
+func backoff(policy RetryPolicy, attempt int) time.Duration {
+	limit := policy.InitialBackoff << (attempt - 1)
+	if limit <= 0 || limit > policy.MaxBackoff {
+		limit = policy.MaxBackoff
+	}
+	if limit <= 0 {
+		return 0
+	}
+	return rand.N(limit)
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Close stops the supervisor, ends every subscription and closes the connection
+func (r *RabbitMQ) Close() error {
+	r.closeOnce.Do(func() { close(r.closing) })
+
+	r.mu.Lock()
+	s := r.current
+	r.connected = false
+	r.mu.Unlock()
+
+	var err error
+	if s != nil {
+		err = s.close()
+	}
+
+	r.wg.Wait()
+	return err
 }
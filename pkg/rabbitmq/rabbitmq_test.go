@@ -0,0 +1,176 @@
+package rabbitmq
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals_backend_task/pkg/brokertest"
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+// TestRabbitMQ runs the broker suite against the server at RABBITMQ_TEST_CONNECTION_STRING.
+// Every test declares non-durable queues of its own, which the server drops on restart.
+func TestRabbitMQ(t *testing.T) {
+	uri := os.Getenv("RABBITMQ_TEST_CONNECTION_STRING")
+	if uri == "" {
+		t.Skip("RABBITMQ_TEST_CONNECTION_STRING is not set")
+	}
+
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		topology := DefaultTopology("brokertest." + uuid.NewString())
+		topology.Durable = false
+		topology.Retry = RetryPolicy{MaxAttempts: brokertest.MaxAttempts, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+
+		r, err := New(uri, topology.Queue, WithTopology(topology))
+		require.NoError(t, err)
+		t.Cleanup(func() { r.Close() })
+		return r
+	})
+}
+
+// newFake connects a client to a fake broker, retrying failed publishes once without delay
+func newFake(t *testing.T, opts ...Option) (*RabbitMQ, *fakeBroker) {
+	broker := newFakeBroker()
+	opts = append([]Option{withDialer(broker.dial),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})}, opts...)
+	r, err := New("amqp://fake", "users", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	return r, broker
+}
+
+func TestPublishConfirmed(t *testing.T) {
+	r, broker := newFake(t)
+
+	require.NoError(t, r.Publish(context.Background(), []byte(`{"id":1}`)))
+	require.Len(t, broker.publishes(), 1)
+	assert.Equal(t, 0, r.current.waiting())
+}
+
+func TestPublishNacked(t *testing.T) {
+	r, broker := newFake(t)
+	broker.answer(func(amqp.Publishing) outcome { return outcomeNack })
+
+	err := r.Publish(context.Background(), []byte(`{"id":1}`))
+	require.ErrorIs(t, err, ErrNacked)
+	// every attempt was published and answered
+	assert.Len(t, broker.publishes(), 2)
+	assert.Equal(t, 0, r.current.waiting())
+}
+
+func TestPublishUnroutable(t *testing.T) {
+	r, broker := newFake(t)
+	broker.answer(func(amqp.Publishing) outcome { return outcomeReturn })
+
+	err := r.Publish(context.Background(), []byte(`{"id":1}`))
+	require.ErrorIs(t, err, ErrUnroutable)
+	assert.Len(t, broker.publishes(), 2)
+
+	// a message returned once and routed on the retry is published
+	returned := false
+	broker.answer(func(amqp.Publishing) outcome {
+		if returned {
+			return outcomeAck
+		}
+		returned = true
+		return outcomeReturn
+	})
+	require.NoError(t, r.Publish(context.Background(), []byte(`{"id":2}`)))
+}
+
+func TestPublishReturnsMatchTheirOwnPublish(t *testing.T) {
+	r, broker := newFake(t)
+	broker.answer(func(msg amqp.Publishing) outcome {
+		if string(msg.Body) == "lost" {
+			return outcomeReturn
+		}
+		return outcomeAck
+	})
+
+	// messages without an ID must not be mistaken for each other
+	lost := amqp.Publishing{Body: []byte("lost")}
+	require.ErrorIs(t, r.publishOnce(context.Background(), "", "users", lost), ErrUnroutable)
+	require.NoError(t, r.publishOnce(context.Background(), "", "users", amqp.Publishing{Body: []byte("kept")}))
+}
+
+func TestPublishRetryAfterTimeout(t *testing.T) {
+	r, broker := newFake(t)
+	broker.answer(func(amqp.Publishing) outcome { return outcomeHold })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, r.Publish(ctx, []byte(`{"id":1}`)), context.DeadlineExceeded)
+	assert.Equal(t, 1, r.current.waiting())
+
+	// the late return and ack of the abandoned publish neither leak nor affect the retry
+	broker.answer(func(amqp.Publishing) outcome { return outcomeAck })
+	broker.release(true)
+	require.NoError(t, r.Publish(context.Background(), []byte(`{"id":1}`)))
+	assert.Eventually(t, func() bool { return r.current.waiting() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+// receive waits for the next message of a subscription
+func receive(t *testing.T, msgs <-chan models.Message) models.Message {
+	t.Helper()
+	select {
+	case m, ok := <-msgs:
+		require.True(t, ok, "subscription closed")
+		return m
+	case <-time.After(time.Second):
+		require.FailNow(t, "no message received")
+		return models.Message{}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	r, broker := newFake(t, WithReconnectPolicy(RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgs, err := r.Subscribe(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, r.Publish(ctx, []byte("1")))
+	unacked := receive(t, msgs)
+	assert.Equal(t, "1", string(unacked.Body))
+
+	// the broker goes away and refuses connections for a while
+	broker.drop(true)
+	require.Eventually(t, func() bool { return r.Health() != nil }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, r.Health(), ErrNotConnected)
+	assert.Error(t, unacked.Ack(), "a delivery of the dropped session cannot be acked")
+
+	// publishes wait for the next session instead of failing
+	published := make(chan error, 1)
+	go func() { published <- r.Publish(ctx, []byte("2")) }()
+	select {
+	case err := <-published:
+		require.FailNow(t, "publish finished while disconnected", "error: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	broker.up()
+	select {
+	case err := <-published:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "publish did not resume after reconnecting")
+	}
+	assert.NoError(t, r.Health())
+	assert.Equal(t, int64(1), r.Reconnects())
+
+	// the same subscription redelivers the unacked message, then the new one
+	redelivered := receive(t, msgs)
+	assert.Equal(t, "1", string(redelivered.Body))
+	require.NoError(t, redelivered.Ack())
+
+	next := receive(t, msgs)
+	assert.Equal(t, "2", string(next.Body))
+	require.NoError(t, next.Ack())
+}
@@ -0,0 +1,302 @@
+package rabbitmq
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	QueueTypeClassic = "classic"
+	QueueTypeQuorum  = "quorum"
+)
+
+// Topology describes the exchange, queue and binding declared on every (re)connect.
+// An empty Exchange publishes through the default exchange straight to the queue.
+// Messages that cannot be processed are routed to DeadLetterExchange, a fanout exchange
+// feeding DeadLetterQueue; an empty DeadLetterExchange disables dead-lettering.
+// Messages that failed transiently wait in a delay queue per backoff step before returning
+// to Queue, for up to Retry.MaxAttempts attempts.
+type Topology struct {
+	Exchange     string
+	ExchangeType string
+	RoutingKey   string
+
+	Queue      string
+	QueueType  string
+	Durable    bool
+	AutoDelete bool
+	MaxLength  int64
+	MessageTTL time.Duration
+
+	DeadLetterExchange string
+	DeadLetterQueue    string
+
+	Retry RetryPolicy
+}
+
+// DefaultTopology is a durable classic queue fed by the default exchange, dead-lettering
+// to "<queue>.dlx" and "<queue>.dlq"
+func DefaultTopology(queue string) Topology {
+	return Topology{
+		Queue:              queue,
+		QueueType:          QueueTypeClassic,
+		Durable:            true,
+		DeadLetterExchange: queue + ".dlx",
+		DeadLetterQueue:    queue + ".dlq",
+		Retry:              DefaultRedeliveryPolicy(),
+	}
+}
+
+// DefaultRedeliveryPolicy attempts a message up to 5 times, waiting from 1s to 1m between attempts
+func DefaultRedeliveryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: time.Minute}
+}
+
+// routingKey is the key messages are published with
+func (t Topology) routingKey() string {
+	if t.Exchange == "" || t.RoutingKey == "" {
+		return t.Queue
+	}
+	return t.RoutingKey
+}
+
+// validate rejects combinations the broker would refuse at declaration time
+func (t Topology) validate() error {
+	if t.Queue == "" {
+		return errors.New("rabbitmq topology: queue name is required")
+	}
+	switch t.QueueType {
+	case "", QueueTypeClassic:
+	case QueueTypeQuorum:
+		if !t.Durable || t.AutoDelete {
+			return errors.New("rabbitmq topology: quorum queues must be durable and cannot auto-delete")
+		}
+	default:
+		return fmt.Errorf("rabbitmq topology: unsupported queue type %q", t.QueueType)
+	}
+	switch t.ExchangeType {
+	case "", amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeFanout, amqp.ExchangeHeaders:
+	default:
+		return fmt.Errorf("rabbitmq topology: unsupported exchange type %q", t.ExchangeType)
+	}
+	if t.DeadLetterExchange != "" && t.DeadLetterQueue == "" {
+		return errors.New("rabbitmq topology: a dead-letter exchange needs a dead-letter queue")
+	}
+	if t.Retry.MaxAttempts > 1 && (t.Retry.InitialBackoff <= 0 || t.Retry.MaxBackoff < t.Retry.InitialBackoff) {
+		return errors.New("rabbitmq topology: retry delays must be positive and the maximum must not be below the initial delay")
+	}
+	if t.MaxLength < 0 || t.MessageTTL < 0 {
+		return errors.New("rabbitmq topology: max length and message TTL must not be negative")
+	}
+	return nil
+}
+
+// queueArgs builds the x-arguments of the queue declaration
+func (t Topology) queueArgs() amqp.Table {
+	args := amqp.Table{}
+	if t.QueueType != "" {
+		args[amqp.QueueTypeArg] = t.QueueType
+	}
+	if t.MaxLength > 0 {
+		args[amqp.QueueMaxLenArg] = t.MaxLength
+	}
+	if t.MessageTTL > 0 {
+		args[amqp.QueueMessageTTLArg] = t.MessageTTL.Milliseconds()
+	}
+	// messages rejected without requeue still end up in the dead-letter queue
+	if t.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = t.DeadLetterExchange
+	}
+	return args
+}
+
+// declare creates the exchange, queue and binding, which is a no-op if they already match
+func (t Topology) declare(ch channel) (amqp.Queue, error) {
+	if t.Exchange != "" {
+		kind := t.ExchangeType
+		if kind == "" {
+			kind = amqp.ExchangeDirect
+		}
+		if err := ch.ExchangeDeclare(t.Exchange, kind, t.Durable, false, false, false, nil); err != nil {
+			return amqp.Queue{}, err
+		}
+	}
+
+	q, err := ch.QueueDeclare(t.Queue, t.Durable, t.AutoDelete, false, false, t.queueArgs())
+	if err != nil {
+		return amqp.Queue{}, err
+	}
+
+	if t.Exchange != "" {
+		if err := ch.QueueBind(q.Name, t.routingKey(), t.Exchange, false, nil); err != nil {
+			return amqp.Queue{}, err
+		}
+	}
+
+	if err := t.declareDeadLetter(ch); err != nil {
+		return amqp.Queue{}, err
+	}
+	if err := t.declareRetry(ch); err != nil {
+		return amqp.Queue{}, err
+	}
+	return q, nil
+}
+
+// retryDelay is how long a message waits after its attempt-th failed attempt: the initial
+// delay doubled for every earlier attempt, capped at the maximum
+func (t Topology) retryDelay(attempt int32) time.Duration {
+	delay := t.Retry.InitialBackoff << (attempt - 1)
+	if delay <= 0 || delay > t.Retry.MaxBackoff {
+		return t.Retry.MaxBackoff
+	}
+	return delay
+}
+
+// retryQueue names the delay queue of a delay; it is named after the delay so changing the
+// policy declares new queues instead of conflicting with the old ones
+func (t Topology) retryQueue(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", t.Queue, delay)
+}
+
+// declareRetry creates a delay queue for every distinct retry delay. Messages expire from it
+// after the delay and are dead-lettered through the default exchange straight back to Queue.
+// A queue-wide TTL is used rather than per-message TTLs, which only expire at the head of a queue.
+func (t Topology) declareRetry(ch channel) error {
+	declared := make(map[time.Duration]bool)
+	for attempt := int32(1); attempt < int32(t.Retry.MaxAttempts); attempt++ {
+		delay := t.retryDelay(attempt)
+		if declared[delay] {
+			continue
+		}
+		declared[delay] = true
+
+		args := amqp.Table{
+			amqp.QueueTypeArg:           QueueTypeClassic,
+			amqp.QueueMessageTTLArg:     delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": t.Queue,
+		}
+		if _, err := ch.QueueDeclare(t.retryQueue(delay), t.Durable, false, false, false, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// declareDeadLetter creates the dead-letter exchange and queue. The queue is not limited
+// by length or TTL so failed messages are kept until they are inspected. It is always a
+// classic queue: inspecting it requeues messages, which a quorum queue counts towards its
+// delivery limit and would eventually drop.
+func (t Topology) declareDeadLetter(ch channel) error {
+	if t.DeadLetterExchange == "" {
+		return nil
+	}
+	if err := ch.ExchangeDeclare(t.DeadLetterExchange, amqp.ExchangeFanout, t.Durable, false, false, false, nil); err != nil {
+		return err
+	}
+
+	args := amqp.Table{amqp.QueueTypeArg: QueueTypeClassic}
+	if _, err := ch.QueueDeclare(t.DeadLetterQueue, t.Durable, false, false, false, args); err != nil {
+		return err
+	}
+	return ch.QueueBind(t.DeadLetterQueue, "", t.DeadLetterExchange, false, nil)
+}
+
+// OptionsFromEnv reads the topology, delivery mode and prefetch from RABBITMQ_* environment variables,
+// starting from DefaultTopology(queue):
+//
+//	RABBITMQ_EXCHANGE, RABBITMQ_EXCHANGE_TYPE, RABBITMQ_ROUTING_KEY,
+//	RABBITMQ_QUEUE_TYPE, RABBITMQ_QUEUE_DURABLE, RABBITMQ_QUEUE_AUTO_DELETE,
+//	RABBITMQ_QUEUE_MAX_LENGTH, RABBITMQ_MESSAGE_TTL, RABBITMQ_DEAD_LETTER_EXCHANGE,
+//	RABBITMQ_DEAD_LETTER_QUEUE, RABBITMQ_RETRY_MAX_ATTEMPTS, RABBITMQ_RETRY_DELAY,
+//	RABBITMQ_RETRY_MAX_DELAY, RABBITMQ_PERSISTENT and RABBITMQ_PREFETCH.
+//
+// Setting RABBITMQ_DEAD_LETTER_EXCHANGE to "none" disables dead-lettering.
+func OptionsFromEnv(queue string) ([]Option, error) {
+	t := DefaultTopology(queue)
+	t.Exchange = os.Getenv("RABBITMQ_EXCHANGE")
+	t.ExchangeType = strings.ToLower(os.Getenv("RABBITMQ_EXCHANGE_TYPE"))
+	t.RoutingKey = os.Getenv("RABBITMQ_ROUTING_KEY")
+	if v := os.Getenv("RABBITMQ_QUEUE_TYPE"); v != "" {
+		t.QueueType = strings.ToLower(v)
+	}
+
+	switch v := os.Getenv("RABBITMQ_DEAD_LETTER_EXCHANGE"); {
+	case strings.EqualFold(v, "none"):
+		t.DeadLetterExchange, t.DeadLetterQueue = "", ""
+	case v != "":
+		t.DeadLetterExchange = v
+	}
+	if v := os.Getenv("RABBITMQ_DEAD_LETTER_QUEUE"); v != "" && t.DeadLetterExchange != "" {
+		t.DeadLetterQueue = v
+	}
+
+	var err error
+	if t.Durable, err = envBool("RABBITMQ_QUEUE_DURABLE", t.Durable); err != nil {
+		return nil, err
+	}
+	if t.AutoDelete, err = envBool("RABBITMQ_QUEUE_AUTO_DELETE", t.AutoDelete); err != nil {
+		return nil, err
+	}
+	if v := os.Getenv("RABBITMQ_QUEUE_MAX_LENGTH"); v != "" {
+		if t.MaxLength, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("RABBITMQ_QUEUE_MAX_LENGTH: %w", err)
+		}
+	}
+	if v := os.Getenv("RABBITMQ_MESSAGE_TTL"); v != "" {
+		if t.MessageTTL, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("RABBITMQ_MESSAGE_TTL: %w", err)
+		}
+	}
+	if v := os.Getenv("RABBITMQ_RETRY_MAX_ATTEMPTS"); v != "" {
+		if t.Retry.MaxAttempts, err = strconv.Atoi(v); err != nil || t.Retry.MaxAttempts <= 0 {
+			return nil, fmt.Errorf("RABBITMQ_RETRY_MAX_ATTEMPTS: must be a positive integer, got %q", v)
+		}
+	}
+	if v := os.Getenv("RABBITMQ_RETRY_DELAY"); v != "" {
+		if t.Retry.InitialBackoff, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("RABBITMQ_RETRY_DELAY: %w", err)
+		}
+	}
+	if v := os.Getenv("RABBITMQ_RETRY_MAX_DELAY"); v != "" {
+		if t.Retry.MaxBackoff, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("RABBITMQ_RETRY_MAX_DELAY: %w", err)
+		}
+	}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	persistent, err := envBool("RABBITMQ_PERSISTENT", true)
+	if err != nil {
+		return nil, err
+	}
+	opts := []Option{WithTopology(t), WithPersistent(persistent)}
+
+	if v := os.Getenv("RABBITMQ_PREFETCH"); v != "" {
+		prefetch, err := strconv.Atoi(v)
+		if err != nil || prefetch <= 0 {
+			return nil, fmt.Errorf("RABBITMQ_PREFETCH: must be a positive integer, got %q", v)
+		}
+		opts = append(opts, WithPrefetch(prefetch))
+	}
+	return opts, nil
+}
+
+func envBool(name string, def bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,242 @@
+package rabbitmq
+
+import (
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var topologyEnv = []string{
+	"RABBITMQ_EXCHANGE", "RABBITMQ_EXCHANGE_TYPE", "RABBITMQ_ROUTING_KEY",
+	"RABBITMQ_QUEUE_TYPE", "RABBITMQ_QUEUE_DURABLE", "RABBITMQ_QUEUE_AUTO_DELETE",
+	"RABBITMQ_QUEUE_MAX_LENGTH", "RABBITMQ_MESSAGE_TTL", "RABBITMQ_DEAD_LETTER_EXCHANGE",
+	"RABBITMQ_DEAD_LETTER_QUEUE", "RABBITMQ_RETRY_MAX_ATTEMPTS", "RABBITMQ_RETRY_DELAY",
+	"RABBITMQ_RETRY_MAX_DELAY", "RABBITMQ_PERSISTENT", "RABBITMQ_PREFETCH",
+}
+
+func TestOptionsFromEnv(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		want         func(*Topology)
+		deliveryMode uint8
+		prefetch     int
+		err          string
+	}{
+		{
+			name: "defaults",
+			want: func(*Topology) {},
+		},
+		{
+			name: "exchange and quorum queue",
+			env: map[string]string{
+				"RABBITMQ_EXCHANGE": "users", "RABBITMQ_EXCHANGE_TYPE": "Topic", "RABBITMQ_ROUTING_KEY": "users.created",
+				"RABBITMQ_QUEUE_TYPE": "QUORUM", "RABBITMQ_QUEUE_MAX_LENGTH": "1000", "RABBITMQ_MESSAGE_TTL": "24h",
+			},
+			want: func(t *Topology) {
+				t.Exchange, t.ExchangeType, t.RoutingKey = "users", amqp.ExchangeTopic, "users.created"
+				t.QueueType, t.MaxLength, t.MessageTTL = QueueTypeQuorum, 1000, 24*time.Hour
+			},
+		},
+		{
+			name: "dead-letter names",
+			env:  map[string]string{"RABBITMQ_DEAD_LETTER_EXCHANGE": "failed", "RABBITMQ_DEAD_LETTER_QUEUE": "failed.users"},
+			want: func(t *Topology) { t.DeadLetterExchange, t.DeadLetterQueue = "failed", "failed.users" },
+		},
+		{
+			name: "dead-lettering disabled",
+			env:  map[string]string{"RABBITMQ_DEAD_LETTER_EXCHANGE": "none", "RABBITMQ_DEAD_LETTER_QUEUE": "ignored"},
+			want: func(t *Topology) { t.DeadLetterExchange, t.DeadLetterQueue = "", "" },
+		},
+		{
+			name: "retry policy",
+			env:  map[string]string{"RABBITMQ_RETRY_MAX_ATTEMPTS": "3", "RABBITMQ_RETRY_DELAY": "2s", "RABBITMQ_RETRY_MAX_DELAY": "10s"},
+			want: func(t *Topology) {
+				t.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: 2 * time.Second, MaxBackoff: 10 * time.Second}
+			},
+		},
+		{
+			name:         "transient classic queue",
+			env:          map[string]string{"RABBITMQ_QUEUE_DURABLE": "false", "RABBITMQ_QUEUE_AUTO_DELETE": "true", "RABBITMQ_PERSISTENT": "false", "RABBITMQ_PREFETCH": "500"},
+			want:         func(t *Topology) { t.Durable, t.AutoDelete = false, true },
+			deliveryMode: amqp.Transient,
+			prefetch:     500,
+		},
+		{name: "invalid bool", env: map[string]string{"RABBITMQ_QUEUE_DURABLE": "maybe"}, err: "RABBITMQ_QUEUE_DURABLE"},
+		{name: "invalid max length", env: map[string]string{"RABBITMQ_QUEUE_MAX_LENGTH": "many"}, err: "RABBITMQ_QUEUE_MAX_LENGTH"},
+		{name: "invalid ttl", env: map[string]string{"RABBITMQ_MESSAGE_TTL": "1 day"}, err: "RABBITMQ_MESSAGE_TTL"},
+		{name: "zero attempts", env: map[string]string{"RABBITMQ_RETRY_MAX_ATTEMPTS": "0"}, err: "RABBITMQ_RETRY_MAX_ATTEMPTS"},
+		{name: "zero prefetch", env: map[string]string{"RABBITMQ_PREFETCH": "0"}, err: "RABBITMQ_PREFETCH"},
+		{name: "unknown queue type", env: map[string]string{"RABBITMQ_QUEUE_TYPE": "stream"}, err: "unsupported queue type"},
+		{name: "transient quorum queue", env: map[string]string{"RABBITMQ_QUEUE_TYPE": "quorum", "RABBITMQ_QUEUE_DURABLE": "false"}, err: "quorum queues must be durable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range topologyEnv {
+				t.Setenv(name, "")
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			opts, err := OptionsFromEnv("users")
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			r := &RabbitMQ{topology: DefaultTopology("users"), prefetch: defaultPrefetch}
+			for _, opt := range opts {
+				opt(r)
+			}
+
+			want := DefaultTopology("users")
+			tt.want(&want)
+			assert.Equal(t, want, r.topology)
+
+			deliveryMode, prefetch := tt.deliveryMode, tt.prefetch
+			if deliveryMode == 0 {
+				deliveryMode = amqp.Persistent
+			}
+			if prefetch == 0 {
+				prefetch = defaultPrefetch
+			}
+			assert.Equal(t, deliveryMode, r.deliveryMode)
+			assert.Equal(t, prefetch, r.prefetch)
+		})
+	}
+}
+
+func TestTopologyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Topology)
+		err    string
+	}{
+		{name: "default", modify: func(*Topology) {}},
+		{name: "quorum", modify: func(t *Topology) { t.QueueType = QueueTypeQuorum }},
+		{name: "no retries", modify: func(t *Topology) { t.Retry = RetryPolicy{MaxAttempts: 1} }},
+		{name: "no queue", modify: func(t *Topology) { t.Queue = "" }, err: "queue name is required"},
+		{name: "auto-deleted quorum", modify: func(t *Topology) { t.QueueType, t.AutoDelete = QueueTypeQuorum, true }, err: "quorum"},
+		{name: "unknown exchange type", modify: func(t *Topology) { t.ExchangeType = "x-delayed-message" }, err: "unsupported exchange type"},
+		{name: "dead-letter exchange without queue", modify: func(t *Topology) { t.DeadLetterQueue = "" }, err: "dead-letter queue"},
+		{name: "retry without delay", modify: func(t *Topology) { t.Retry.InitialBackoff = 0 }, err: "retry delays"},
+		{name: "retry maximum below initial", modify: func(t *Topology) { t.Retry.MaxBackoff = time.Millisecond }, err: "retry delays"},
+		{name: "negative max length", modify: func(t *Topology) { t.MaxLength = -1 }, err: "must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topology := DefaultTopology("users")
+			tt.modify(&topology)
+
+			err := topology.validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestQueueArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Topology)
+		want   amqp.Table
+	}{
+		{
+			name:   "classic",
+			modify: func(*Topology) {},
+			want:   amqp.Table{amqp.QueueTypeArg: QueueTypeClassic, "x-dead-letter-exchange": "users.dlx"},
+		},
+		{
+			name: "quorum with limits",
+			modify: func(t *Topology) {
+				t.QueueType, t.MaxLength, t.MessageTTL = QueueTypeQuorum, 1000, time.Hour
+			},
+			want: amqp.Table{
+				amqp.QueueTypeArg: QueueTypeQuorum, amqp.QueueMaxLenArg: int64(1000),
+				amqp.QueueMessageTTLArg: int64(3600000), "x-dead-letter-exchange": "users.dlx",
+			},
+		},
+		{
+			name:   "without type or dead-lettering",
+			modify: func(t *Topology) { t.QueueType, t.DeadLetterExchange, t.DeadLetterQueue = "", "", "" },
+			want:   amqp.Table{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topology := DefaultTopology("users")
+			tt.modify(&topology)
+			assert.Equal(t, tt.want, topology.queueArgs())
+		})
+	}
+}
+
+// declared is one exchange, queue or binding declared on a recordingChannel
+type declared struct {
+	kind, name, target string
+	args               amqp.Table
+}
+
+// recordingChannel records the declarations made on it; other channel methods are not used
+type recordingChannel struct {
+	channel
+	declared []declared
+}
+
+func (c *recordingChannel) ExchangeDeclare(name, kind string, _, _, _, _ bool, _ amqp.Table) error {
+	c.declared = append(c.declared, declared{kind: "exchange", name: name, target: kind})
+	return nil
+}
+
+func (c *recordingChannel) QueueDeclare(name string, _, _, _, _ bool, args amqp.Table) (amqp.Queue, error) {
+	c.declared = append(c.declared, declared{kind: "queue", name: name, args: args})
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *recordingChannel) QueueBind(name, _, exchange string, _ bool, _ amqp.Table) error {
+	c.declared = append(c.declared, declared{kind: "binding", name: name, target: exchange})
+	return nil
+}
+
+func TestDeclare(t *testing.T) {
+	topology := DefaultTopology("users")
+	topology.Exchange = "users"
+	topology.Retry = RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Second, MaxBackoff: 3 * time.Second}
+
+	ch := &recordingChannel{}
+	q, err := topology.declare(ch)
+	require.NoError(t, err)
+	assert.Equal(t, "users", q.Name)
+
+	retryArgs := func(delay time.Duration) amqp.Table {
+		return amqp.Table{
+			amqp.QueueTypeArg:           QueueTypeClassic,
+			amqp.QueueMessageTTLArg:     delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": "users",
+		}
+	}
+	assert.Equal(t, []declared{
+		{kind: "exchange", name: "users", target: amqp.ExchangeDirect},
+		{kind: "queue", name: "users", args: topology.queueArgs()},
+		{kind: "binding", name: "users", target: "users"},
+		{kind: "exchange", name: "users.dlx", target: amqp.ExchangeFanout},
+		{kind: "queue", name: "users.dlq", args: amqp.Table{amqp.QueueTypeArg: QueueTypeClassic}},
+		{kind: "binding", name: "users.dlq", target: "users.dlx"},
+		// the delays 1s, 2s, 3s and 3s share a queue per distinct delay
+		{kind: "queue", name: "users.retry.1s", args: retryArgs(time.Second)},
+		{kind: "queue", name: "users.retry.2s", args: retryArgs(2 * time.Second)},
+		{kind: "queue", name: "users.retry.3s", args: retryArgs(3 * time.Second)},
+	}, ch.declared)
+}
@@ -0,0 +1,88 @@
+package recordsource
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression identifies a stream compression format.
+type Compression string
+
+const (
+	CompressionNone  Compression = "none"
+	CompressionGzip  Compression = "gzip"
+	CompressionZstd  Compression = "zstd"
+	CompressionBzip2 Compression = "bzip2"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+)
+
+// compressedExtensions are stripped before the input format is detected from the file name.
+var compressedExtensions = map[string]bool{
+	".gz":   true,
+	".gzip": true,
+	".zst":  true,
+	".zstd": true,
+	".bz2":  true,
+}
+
+// stripCompressionExt turns "users.csv.gz" into "users.csv".
+func stripCompressionExt(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if compressedExtensions[ext] {
+		return strings.TrimSuffix(path, filepath.Ext(path))
+	}
+	return path
+}
+
+// decompress sniffs the magic bytes of r and wraps it in a streaming
+// decompressor when needed. The returned closer releases the decompressor.
+func decompress(r io.Reader) (io.Reader, func(), Compression, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, nil, "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return gz, func() { gz.Close() }, CompressionGzip, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		return zr, zr.Close, CompressionZstd, nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(buffered), func() {}, CompressionBzip2, nil
+	}
+	return buffered, func() {}, CompressionNone, nil
+}
+
+// countingReader counts the bytes read from the underlying file, i.e. compressed bytes for compressed input.
+type countingReader struct {
+	r    io.Reader
+	read atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
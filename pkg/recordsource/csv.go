@@ -0,0 +1,77 @@
+package recordsource
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/viswals_backend_task/pkg/csvutils"
+)
+
+// CSV reads delimiter-separated records.
+type CSV struct {
+	reader *csv.Reader
+	header []string
+	line   int64
+	closer io.Closer
+}
+
+// NewCSV reads the header row from r and returns a source for the remaining rows.
+func NewCSV(r io.Reader, delimiter rune) (*CSV, error) {
+	reader, header, err := csvutils.NewReader(r, delimiter)
+	if err != nil {
+		return nil, err
+	}
+
+	src := FromCSVReader(reader, header)
+	if c, ok := r.(io.Closer); ok {
+		src.closer = c
+	}
+	return src, nil
+}
+
+// FromCSVReader wraps an already positioned CSV reader. The header may be nil
+// when the input has none.
+func FromCSVReader(reader *csv.Reader, header []string) *CSV {
+	return &CSV{reader: reader, header: header}
+}
+
+// Header returns the header row, if any.
+func (c *CSV) Header() []string {
+	return c.header
+}
+
+// Next returns the next row.
+func (c *CSV) Next() ([]string, error) {
+	rows, invalid, err := csvutils.ReadRows(c.reader, 1)
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			c.line = int64(parseErr.StartLine)
+			return nil, fmt.Errorf("%w: %v", ErrMalformedRecord, err)
+		}
+		return nil, err
+	}
+
+	line, _ := c.reader.FieldPos(0)
+	c.line = int64(line)
+
+	if invalid != nil {
+		return invalid, fmt.Errorf("%w: %w", ErrMalformedRecord, ErrFieldCount)
+	}
+	return rows[0], nil
+}
+
+// Line returns the line on which the last record started.
+func (c *CSV) Line() int64 {
+	return c.line
+}
+
+// Close closes the underlying file.
+func (c *CSV) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
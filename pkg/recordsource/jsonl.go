@@ -0,0 +1,171 @@
+package recordsource
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// JSONL reads newline-delimited JSON objects. The header is taken from the
+// keys of the first object; later objects are projected onto those keys. A
+// later object with a key the first one lacks is malformed rather than losing
+// that value, so the first object must carry every key, null where it has no value.
+type JSONL struct {
+	reader      *bufio.Reader
+	header      []string
+	columns     map[string]int
+	pending     []string
+	pendingLine int64
+	line        int64
+	read        int64
+	closer      io.Closer
+}
+
+// NewJSONL reads the first object from r to establish the header.
+func NewJSONL(r io.Reader) (*JSONL, error) {
+	src := &JSONL{reader: bufio.NewReader(r)}
+	if c, ok := r.(io.Closer); ok {
+		src.closer = c
+	}
+
+	line, err := src.nextLine()
+	if errors.Is(err, io.EOF) {
+		return src, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys, values, err := decodeObject(line)
+	if err != nil {
+		return nil, fmt.Errorf("invalid first JSON line: %w", err)
+	}
+
+	src.header = keys
+	src.columns = make(map[string]int, len(keys))
+	for i, key := range keys {
+		src.columns[key] = i
+	}
+	src.pending = values
+	src.pendingLine = src.read
+	return src, nil
+}
+
+// Header returns the keys of the first object.
+func (j *JSONL) Header() []string {
+	return j.header
+}
+
+// Next returns the values of the next object in header order.
+func (j *JSONL) Next() ([]string, error) {
+	if j.pending != nil {
+		row := j.pending
+		j.pending = nil
+		j.line = j.pendingLine
+		return row, nil
+	}
+
+	line, err := j.nextLine()
+	if err != nil {
+		return nil, err
+	}
+	j.line = j.read
+
+	keys, values, err := decodeObject(line)
+	if err != nil {
+		return []string{string(line)}, fmt.Errorf("%w: %v", ErrMalformedRecord, err)
+	}
+
+	row := make([]string, len(j.header))
+	for i, key := range keys {
+		column, ok := j.columns[key]
+		if !ok {
+			return []string{string(line)}, fmt.Errorf("%w: key %q is not in the first object", ErrMalformedRecord, key)
+		}
+		row[column] = values[i]
+	}
+	return row, nil
+}
+
+// Line returns the line number of the last object.
+func (j *JSONL) Line() int64 {
+	return j.line
+}
+
+// Close closes the underlying file.
+func (j *JSONL) Close() error {
+	if j.closer == nil {
+		return nil
+	}
+	return j.closer.Close()
+}
+
+// nextLine returns the next non-blank line without its line terminator.
+func (j *JSONL) nextLine() ([]byte, error) {
+	for {
+		line, err := j.reader.ReadBytes('\n')
+		if len(line) > 0 {
+			j.read++
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// decodeObject decodes a single JSON object, keeping its keys in document order.
+func decodeObject(line []byte) ([]string, []string, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return nil, nil, errors.New("expected a JSON object")
+	}
+
+	var keys, values []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, _ := tok.(string)
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, key)
+		values = append(values, jsonText(raw))
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return keys, values, nil
+}
+
+// jsonText converts a JSON value to the text a CSV cell would hold.
+func jsonText(raw json.RawMessage) string {
+	switch {
+	case bytes.Equal(raw, []byte("null")):
+		return ""
+	case len(raw) > 0 && raw[0] == '"':
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+	}
+	return string(raw)
+}
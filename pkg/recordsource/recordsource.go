@@ -0,0 +1,200 @@
+package recordsource
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Format identifies the encoding of an input file.
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatTSV   Format = "tsv"
+	FormatJSONL Format = "jsonl"
+	FormatXLSX  Format = "xlsx"
+)
+
+// ErrMalformedRecord marks a record that could not be decoded. The source has
+// already moved past it, so the caller may continue reading.
+var ErrMalformedRecord = errors.New("malformed record")
+
+// ErrFieldCount marks a malformed record whose number of fields differs from the header.
+var ErrFieldCount = errors.New("wrong number of fields")
+
+// ErrTooLarge is returned for an XLSX input above Options.MaxXLSXBytes.
+var ErrTooLarge = errors.New("input too large")
+
+// DefaultMaxXLSXBytes caps XLSX inputs when Options.MaxXLSXBytes is not set.
+const DefaultMaxXLSXBytes = 100 << 20
+
+// Source yields the records of an input file as rows of string fields.
+// Next returns io.EOF once the input is exhausted. Line reports the line (or
+// sheet row) in the input where the record returned by the last Next started.
+type Source interface {
+	Header() []string
+	Next() ([]string, error)
+	Line() int64
+	Close() error
+}
+
+// Options controls how Open reads a file.
+type Options struct {
+	// Format overrides detection by file extension when set.
+	Format Format
+	// Delimiter overrides the field separator of CSV and TSV inputs.
+	Delimiter rune
+	// Sheet selects the worksheet of an XLSX input; defaults to the first one.
+	Sheet string
+	// MaxXLSXBytes caps the size of an XLSX input, which is read into memory as a whole;
+	// defaults to DefaultMaxXLSXBytes.
+	MaxXLSXBytes int64
+}
+
+// ParseFormat validates a format name such as "csv" or "ndjson".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "csv":
+		return FormatCSV, nil
+	case "tsv", "tab":
+		return FormatTSV, nil
+	case "jsonl", "ndjson":
+		return FormatJSONL, nil
+	case "xlsx", "excel":
+		return FormatXLSX, nil
+	}
+	return "", fmt.Errorf("unsupported input format %q", name)
+}
+
+// DetectFormat infers the format from the file extension, ignoring a trailing
+// compression extension such as ".gz".
+func DetectFormat(path string) (Format, error) {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(stripCompressionExt(path))), ".")
+	if ext == "" {
+		return "", fmt.Errorf("cannot detect input format of %s: no file extension", path)
+	}
+	return ParseFormat(ext)
+}
+
+// File is a record source read from disk. Gzip, zstd and bzip2 inputs are
+// detected by their magic bytes and decompressed while streaming.
+type File struct {
+	Source
+	file        *os.File
+	counter     *countingReader
+	size        int64
+	release     func()
+	compression Compression
+}
+
+// Open opens the file at path as a record source.
+func Open(path string, opts Options) (*File, error) {
+	format := opts.Format
+	if format == "" {
+		var err error
+		format, err = DetectFormat(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	counter := &countingReader{r: file}
+	input, release, compression, err := decompress(counter)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// hide any Close method so the inner source leaves the file to us.
+	src, err := newSource(format, struct{ io.Reader }{input}, opts)
+	if err != nil {
+		release()
+		file.Close()
+		return nil, err
+	}
+
+	return &File{
+		Source:      src,
+		file:        file,
+		counter:     counter,
+		size:        info.Size(),
+		release:     release,
+		compression: compression,
+	}, nil
+}
+
+// Compression reports how the file was compressed on disk.
+func (f *File) Compression() Compression {
+	return f.compression
+}
+
+// Progress returns the number of bytes consumed from disk and the file size.
+// For compressed input both values count compressed bytes.
+func (f *File) Progress() (int64, int64) {
+	return f.counter.read.Load(), f.size
+}
+
+// Close releases the source, the decompressor and the file.
+func (f *File) Close() error {
+	err := f.Source.Close()
+	f.release()
+	return errors.Join(err, f.file.Close())
+}
+
+// newSource creates the record source for the given format.
+func newSource(format Format, r io.Reader, opts Options) (Source, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSV(r, delimiter(opts.Delimiter, ','))
+	case FormatTSV:
+		return NewCSV(r, delimiter(opts.Delimiter, '\t'))
+	case FormatJSONL:
+		return NewJSONL(r)
+	case FormatXLSX:
+		// XLSX is a zip archive the Excel library reads into memory as a whole, unlike the
+		// streamed formats, so its size is capped. Worksheets are still unzipped to temporary files.
+		limit := opts.MaxXLSXBytes
+		if limit <= 0 {
+			limit = DefaultMaxXLSXBytes
+		}
+		return NewXLSX(&cappedReader{r: r, left: limit}, opts.Sheet)
+	}
+	return nil, fmt.Errorf("unsupported input format %q", format)
+}
+
+func delimiter(configured, fallback rune) rune {
+	if configured != 0 {
+		return configured
+	}
+	return fallback
+}
+
+// cappedReader fails with ErrTooLarge once more than left bytes are read.
+type cappedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if c.left < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,184 @@
+package recordsource
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		path string
+		want Format
+		err  bool
+	}{
+		{path: "users.csv", want: FormatCSV},
+		{path: "users.TSV", want: FormatTSV},
+		{path: "users.jsonl", want: FormatJSONL},
+		{path: "users.ndjson", want: FormatJSONL},
+		{path: "users.xlsx", want: FormatXLSX},
+		{path: "users.csv.gz", want: FormatCSV},
+		{path: "users.jsonl.zst", want: FormatJSONL},
+		{path: "users.tsv.bz2", want: FormatTSV},
+		{path: "users", err: true},
+		{path: "users.gz", err: true},
+		{path: "users.parquet", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := DetectFormat(tt.path)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// bzip2CSV is "id,first_name\n1,John\n" compressed with bzip2, which the standard library cannot write
+var bzip2CSV = []byte{0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x5b, 0x5d, 0x9f, 0x00, 0x00, 0x00, 0x06, 0x5f, 0x80, 0x00, 0x10, 0x00, 0x04, 0x20, 0x00, 0x00, 0x10, 0x00, 0x00, 0xa7, 0x63, 0x9c, 0x00, 0x20, 0x00, 0x22, 0x83, 0xd2, 0x06, 0x65, 0x34, 0x28, 0x69, 0xa6, 0x00, 0x35, 0x03, 0x61, 0xa5, 0xa0, 0x85, 0x92, 0x52, 0x5c, 0xf4, 0xfc, 0x5d, 0xc9, 0x14, 0xe1, 0x42, 0x41, 0x6d, 0x76, 0x7c, 0x00}
+
+func TestOpenDetectsCompressionByMagicBytes(t *testing.T) {
+	const data = "id,first_name\n1,John\n"
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, err := gw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gw.Close())
+
+	zw, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	zst := zw.EncodeAll([]byte(data), nil)
+	require.NoError(t, zw.Close())
+
+	// the names carry no compression extension, so only the magic bytes tell them apart
+	tests := []struct {
+		name string
+		data []byte
+		want Compression
+	}{
+		{name: "plain", data: []byte(data), want: CompressionNone},
+		{name: "gzip", data: gz.Bytes(), want: CompressionGzip},
+		{name: "zstd", data: zst, want: CompressionZstd},
+		{name: "bzip2", data: bzip2CSV, want: CompressionBzip2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.csv")
+			require.NoError(t, os.WriteFile(path, tt.data, 0o644))
+
+			src, err := Open(path, Options{})
+			require.NoError(t, err)
+			defer src.Close()
+
+			assert.Equal(t, tt.want, src.Compression())
+			assert.Equal(t, []string{"id", "first_name"}, src.Header())
+			row, err := src.Next()
+			require.NoError(t, err)
+			assert.Equal(t, []string{"1", "John"}, row)
+			_, err = src.Next()
+			assert.ErrorIs(t, err, io.EOF)
+
+			read, size := src.Progress()
+			assert.Equal(t, int64(len(tt.data)), size)
+			assert.Equal(t, size, read)
+		})
+	}
+}
+
+func TestJSONL(t *testing.T) {
+	input := strings.Join([]string{
+		`{"id":1,"email":"a@example.com","deleted_at":null,"score":1.5}`,
+		"",
+		`{"email":"b@example.com","id":2}`,
+		`{"id":3,"email":"c@example.com","merged_at":"2024-01-01"}`,
+		`not json`,
+		`{"id":4,"email":"d@example.com","nested":{"a":[1,2]}}`,
+	}, "\n")
+
+	src, err := NewJSONL(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "email", "deleted_at", "score"}, src.Header())
+
+	row, err := src.Next()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "a@example.com", "", "1.5"}, row)
+	assert.Equal(t, int64(1), src.Line())
+
+	// keys are matched by name, and missing keys are empty
+	row, err = src.Next()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2", "b@example.com", "", ""}, row)
+	assert.Equal(t, int64(3), src.Line())
+
+	// a key the first object lacks is rejected instead of dropping its value
+	row, err = src.Next()
+	assert.ErrorIs(t, err, ErrMalformedRecord)
+	assert.ErrorContains(t, err, `"merged_at"`)
+	assert.Equal(t, []string{`{"id":3,"email":"c@example.com","merged_at":"2024-01-01"}`}, row)
+	assert.Equal(t, int64(4), src.Line())
+
+	_, err = src.Next()
+	assert.ErrorIs(t, err, ErrMalformedRecord)
+	assert.Equal(t, int64(5), src.Line())
+
+	_, err = src.Next()
+	assert.ErrorIs(t, err, ErrMalformedRecord)
+
+	_, err = src.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestCSVFieldCount(t *testing.T) {
+	src, err := NewCSV(strings.NewReader("id,name\n1,John\n2\n3,Jane\n"), ',')
+	require.NoError(t, err)
+
+	_, err = src.Next()
+	require.NoError(t, err)
+
+	row, err := src.Next()
+	assert.ErrorIs(t, err, ErrFieldCount)
+	assert.ErrorIs(t, err, ErrMalformedRecord)
+	assert.Equal(t, []string{"2"}, row)
+	assert.Equal(t, int64(3), src.Line())
+
+	row, err = src.Next()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3", "Jane"}, row)
+}
+
+func TestOpenCapsXLSXSize(t *testing.T) {
+	workbook := excelize.NewFile()
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A1", &[]string{"id", "first_name"}))
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A2", &[]string{"1", "John"}))
+	data, err := workbook.WriteToBuffer()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "users.xlsx")
+	require.NoError(t, os.WriteFile(path, data.Bytes(), 0o644))
+
+	_, err = Open(path, Options{MaxXLSXBytes: int64(data.Len() - 1)})
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	src, err := Open(path, Options{MaxXLSXBytes: int64(data.Len())})
+	require.NoError(t, err)
+	defer src.Close()
+	assert.Equal(t, []string{"id", "first_name"}, src.Header())
+	row, err := src.Next()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "John"}, row)
+}
@@ -0,0 +1,97 @@
+package recordsource
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// XLSX reads the rows of a single worksheet. The first row is the header.
+type XLSX struct {
+	file   *excelize.File
+	rows   *excelize.Rows
+	header []string
+	line   int64
+	closer io.Closer
+}
+
+// NewXLSX opens the workbook in r and positions the source after the header
+// row of the given sheet, or of the first sheet when sheet is empty.
+func NewXLSX(r io.Reader, sheet string) (*XLSX, error) {
+	file, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if sheet == "" {
+		sheets := file.GetSheetList()
+		if len(sheets) == 0 {
+			file.Close()
+			return nil, errors.New("workbook has no sheets")
+		}
+		sheet = sheets[0]
+	}
+
+	rows, err := file.Rows(sheet)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("unable to read sheet %q: %w", sheet, err)
+	}
+
+	src := &XLSX{file: file, rows: rows}
+	if c, ok := r.(io.Closer); ok {
+		src.closer = c
+	}
+
+	if rows.Next() {
+		src.line++
+		src.header, err = rows.Columns()
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+// Header returns the first row of the sheet.
+func (x *XLSX) Header() []string {
+	return x.header
+}
+
+// Next returns the next row, padded to the header width since trailing empty cells are omitted.
+func (x *XLSX) Next() ([]string, error) {
+	if !x.rows.Next() {
+		if err := x.rows.Error(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+	x.line++
+
+	row, err := x.rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedRecord, err)
+	}
+
+	for len(row) < len(x.header) {
+		row = append(row, "")
+	}
+	return row, nil
+}
+
+// Line returns the sheet row number of the last row.
+func (x *XLSX) Line() int64 {
+	return x.line
+}
+
+// Close releases the workbook and the underlying file.
+func (x *XLSX) Close() error {
+	err := errors.Join(x.rows.Close(), x.file.Close())
+	if x.closer != nil {
+		err = errors.Join(err, x.closer.Close())
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package redisstream
+
+import (
+	"context"
+
+	redis "github.com/redis/go-redis/v9"
+	"github.com/viswals_backend_task/pkg/models"
+	"go.uber.org/zap"
+)
+
+// maxDeadLetterScan bounds how many messages a single inspection or replay walks through
+const maxDeadLetterScan = 10000
+
+// ListDeadLetters returns up to limit messages from the head of the dead-letter stream, without
+// their bodies. The messages stay in the stream.
+func (b *Broker) ListDeadLetters(ctx context.Context, limit int) ([]models.DeadLetter, error) {
+	if limit <= 0 || limit > maxDeadLetterScan {
+		limit = maxDeadLetterScan
+	}
+
+	letters := []models.DeadLetter{}
+	err := b.scanDeadLetters(ctx, limit, func(entryID string, env envelope) (bool, error) {
+		letters = append(letters, models.DeadLetterOf(env.ID, env.Headers, env.Body, false))
+		return false, nil
+	})
+	return letters, err
+}
+
+// PeekDeadLetter returns a dead-lettered message with its body, leaving it in the stream
+func (b *Broker) PeekDeadLetter(ctx context.Context, id string) (*models.DeadLetter, error) {
+	var found *models.DeadLetter
+	err := b.scanDeadLetters(ctx, maxDeadLetterScan, func(entryID string, env envelope) (bool, error) {
+		if env.ID != id {
+			return false, nil
+		}
+		letter := models.DeadLetterOf(env.ID, env.Headers, env.Body, true)
+		found = &letter
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, models.ErrDeadLetterNotFound
+	}
+	return found, nil
+}
+
+// ReplayDeadLetters adds dead-lettered messages back to the main stream as fresh messages,
+// without their failure headers, and removes them from the dead-letter stream. An empty ids
+// replays every dead-lettered message.
+func (b *Broker) ReplayDeadLetters(ctx context.Context, ids []string) (int, error) {
+	selected := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	replayed := 0
+	err := b.scanDeadLetters(ctx, maxDeadLetterScan, func(entryID string, env envelope) (bool, error) {
+		if len(selected) > 0 && !selected[env.ID] {
+			return false, nil
+		}
+
+		env.Headers = replayHeaders(env.Headers)
+		_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if err := b.add(ctx, pipe, b.stream, env); err != nil {
+				return err
+			}
+			return pipe.XDel(ctx, b.deadQueue, entryID).Err()
+		})
+		if err != nil {
+			return true, err
+		}
+
+		replayed++
+		delete(selected, env.ID)
+		// a selective replay stops once every requested message was found
+		return len(ids) > 0 && len(selected) == 0, nil
+	})
+	return replayed, err
+}
+
+// PurgeDeadLetters deletes the dead-letter stream, returning how many messages were removed
+func (b *Broker) PurgeDeadLetters(ctx context.Context) (int, error) {
+	var length *redis.IntCmd
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		length = pipe.XLen(ctx, b.deadQueue)
+		return pipe.Del(ctx, b.deadQueue).Err()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(length.Val()), nil
+}
+
+// scanDeadLetters reads the dead-letter stream from its head, up to limit entries, and calls
+// visit for each until it returns true
+func (b *Broker) scanDeadLetters(ctx context.Context, limit int, visit func(entryID string, env envelope) (bool, error)) error {
+	start := "-"
+	for seen := 0; seen < limit; {
+		entries, err := b.client.XRangeN(ctx, b.deadQueue, start, "+", int64(min(limit-seen, defaultReadCount))).Result()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		for _, entry := range entries {
+			seen++
+			env, err := decode(entry)
+			if err != nil {
+				b.logger.Warn("Skipping malformed dead-letter entry", zap.String("entry_id", entry.ID), zap.Error(err))
+				continue
+			}
+			stop, err := visit(entry.ID, env)
+			if err != nil || stop {
+				return err
+			}
+		}
+		// the next page starts after the last entry read
+		start = "(" + entries[len(entries)-1].ID
+	}
+	return nil
+}
+
+// replayHeaders drops the failure and attempt headers so a replayed message starts over
+func replayHeaders(headers map[string]any) map[string]any {
+	out := map[string]any{}
+	for k, v := range headers {
+		switch k {
+		case models.HeaderFailureReason, models.HeaderExceptionClass, models.HeaderOriginalQueue,
+			models.HeaderAttemptCount, models.HeaderFailedAt, models.HeaderPgErrorCode:
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,639 @@
+package redisstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	redis "github.com/redis/go-redis/v9"
+	"github.com/viswals_backend_task/pkg/models"
+	"go.uber.org/zap"
+)
+
+// ErrClosed is returned once Close has been called
+var ErrClosed = errors.New("redis stream broker closed")
+
+const (
+	defaultGroup     = "consumers"
+	defaultClaimIdle = time.Minute
+	defaultReadCount = 100
+	defaultBlock     = time.Second
+
+	// messageField is the stream entry field holding the encoded message
+	messageField = "msg"
+	// moveBatch bounds how many due retries are moved back to the stream at once
+	moveBatch = 100
+)
+
+// RetryPolicy bounds how often a message is attempted and how long it waits between attempts
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// DefaultRetryPolicy attempts a message up to 5 times, waiting from 1s to 1m between attempts
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 5, InitialDelay: time.Second, MaxDelay: time.Minute}
+}
+
+// delay is the wait before the attempt after attempt, doubling from InitialDelay up to MaxDelay
+func (p RetryPolicy) delay(attempt int32) time.Duration {
+	d := p.InitialDelay
+	for i := int32(1); i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// Broker uses a Redis stream as the queue. Messages are added with XADD and read through a
+// consumer group with XREADGROUP; an acked message is removed with XACK and XDEL. Messages a
+// consumer read but never settled stay pending and are claimed with XAUTOCLAIM by any consumer
+// of the group once they have been idle for ClaimIdle, so a dead consumer loses nothing.
+//
+// The stream is named "{<queue>}". Messages waiting for a retry are kept in the sorted set
+// "{<queue>}:retry", scored by when they are due, and dead-lettered messages in the stream
+// "{<queue>}:dlq". The braces are a hash tag that keeps the three keys in one Redis Cluster slot,
+// since the retry script touches two of them at once.
+type Broker struct {
+	client    *redis.Client
+	stream    string
+	retrySet  string
+	deadQueue string
+	group     string
+	consumer  string
+	maxLen    int64
+	claimIdle time.Duration
+	block     time.Duration
+	retry     RetryPolicy
+	logger    *zap.Logger
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// envelope is the message stored in a stream entry
+type envelope struct {
+	ID          string         `json:"id"`
+	Body        []byte         `json:"body"`
+	Headers     map[string]any `json:"headers,omitempty"`
+	ContentType string         `json:"content_type,omitempty"`
+	// Nonce tells apart retries of the same message in the retry set
+	Nonce string `json:"nonce,omitempty"`
+}
+
+type Option func(*Broker)
+
+// WithGroup sets the consumer group; every consumer of a group shares its messages
+func WithGroup(group string) Option {
+	return func(b *Broker) {
+		if group != "" {
+			b.group = group
+		}
+	}
+}
+
+// WithConsumerName names this consumer within the group; it defaults to the hostname and a random suffix
+func WithConsumerName(name string) Option {
+	return func(b *Broker) {
+		if name != "" {
+			b.consumer = name
+		}
+	}
+}
+
+// WithMaxLength caps the stream at about n entries, dropping the oldest
+func WithMaxLength(n int64) Option {
+	return func(b *Broker) {
+		b.maxLen = n
+	}
+}
+
+// WithClaimIdle sets how long a message stays pending before another consumer claims it
+func WithClaimIdle(idle time.Duration) Option {
+	return func(b *Broker) {
+		if idle > 0 {
+			b.claimIdle = idle
+		}
+	}
+}
+
+// WithBlock sets how long a read waits for new messages before checking for claims and Close
+func WithBlock(block time.Duration) Option {
+	return func(b *Broker) {
+		if block > 0 {
+			b.block = block
+		}
+	}
+}
+
+// WithRetryPolicy sets how often Retry attempts a message before dead-lettering it
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(b *Broker) {
+		b.retry = policy
+	}
+}
+
+// WithLogger sets the logger
+func WithLogger(logger *zap.Logger) Option {
+	return func(b *Broker) {
+		if logger != nil {
+			b.logger = logger
+		}
+	}
+}
+
+// New connects to Redis, creates the consumer group of the queue's stream and starts moving
+// due retries back to the stream
+func New(connectionString, queue string, opts ...Option) (*Broker, error) {
+	if queue == "" {
+		return nil, errors.New("redis stream broker: queue name is required")
+	}
+	conf, err := redis.ParseURL(connectionString)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	tag := "{" + queue + "}"
+	b := &Broker{
+		client:    redis.NewClient(conf),
+		stream:    tag,
+		retrySet:  tag + ":retry",
+		deadQueue: tag + ":dlq",
+		group:     defaultGroup,
+		consumer:  fmt.Sprintf("%s-%s", hostname, uuid.NewString()[:8]),
+		claimIdle: defaultClaimIdle,
+		block:     defaultBlock,
+		retry:     DefaultRetryPolicy(),
+		logger:    zap.NewNop(),
+		closing:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	if b.retry.MaxAttempts <= 0 || b.retry.InitialDelay <= 0 || b.retry.MaxDelay < b.retry.InitialDelay {
+		b.client.Close()
+		return nil, fmt.Errorf("redis stream broker: invalid retry policy %+v", b.retry)
+	}
+
+	ctx := context.Background()
+	err = b.client.XGroupCreateMkStream(ctx, b.stream, b.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		b.client.Close()
+		return nil, err
+	}
+
+	b.wg.Add(1)
+	go b.moveRetries()
+	return b, nil
+}
+
+// OptionsFromEnv reads REDIS_STREAM_GROUP, REDIS_STREAM_CONSUMER, REDIS_STREAM_MAX_LENGTH,
+// REDIS_STREAM_CLAIM_IDLE, REDIS_STREAM_RETRY_MAX_ATTEMPTS, REDIS_STREAM_RETRY_DELAY and
+// REDIS_STREAM_RETRY_MAX_DELAY
+func OptionsFromEnv() ([]Option, error) {
+	opts := []Option{
+		WithGroup(os.Getenv("REDIS_STREAM_GROUP")),
+		WithConsumerName(os.Getenv("REDIS_STREAM_CONSUMER")),
+	}
+
+	if v := os.Getenv("REDIS_STREAM_MAX_LENGTH"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("REDIS_STREAM_MAX_LENGTH: must be a positive integer, got %q", v)
+		}
+		opts = append(opts, WithMaxLength(n))
+	}
+	if v := os.Getenv("REDIS_STREAM_CLAIM_IDLE"); v != "" {
+		idle, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("REDIS_STREAM_CLAIM_IDLE: %w", err)
+		}
+		opts = append(opts, WithClaimIdle(idle))
+	}
+
+	retry := DefaultRetryPolicy()
+	var err error
+	if v := os.Getenv("REDIS_STREAM_RETRY_MAX_ATTEMPTS"); v != "" {
+		if retry.MaxAttempts, err = strconv.Atoi(v); err != nil || retry.MaxAttempts <= 0 {
+			return nil, fmt.Errorf("REDIS_STREAM_RETRY_MAX_ATTEMPTS: must be a positive integer, got %q", v)
+		}
+	}
+	if v := os.Getenv("REDIS_STREAM_RETRY_DELAY"); v != "" {
+		if retry.InitialDelay, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("REDIS_STREAM_RETRY_DELAY: %w", err)
+		}
+	}
+	if v := os.Getenv("REDIS_STREAM_RETRY_MAX_DELAY"); v != "" {
+		if retry.MaxDelay, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("REDIS_STREAM_RETRY_MAX_DELAY: %w", err)
+		}
+	}
+	return append(opts, WithRetryPolicy(retry)), nil
+}
+
+// Publish adds a message to the stream
+func (b *Broker) Publish(ctx context.Context, data []byte) error {
+	return b.add(ctx, b.client, b.stream, envelope{
+		ID:          uuid.NewString(),
+		Body:        data,
+		ContentType: "application/json",
+	})
+}
+
+// add appends env to a stream through c, which may be a transaction
+func (b *Broker) add(ctx context.Context, c redis.Cmdable, stream string, env envelope) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	args := &redis.XAddArgs{Stream: stream, Values: []string{messageField, string(data)}}
+	if stream == b.stream && b.maxLen > 0 {
+		args.MaxLen, args.Approx = b.maxLen, true
+	}
+	return c.XAdd(ctx, args).Err()
+}
+
+// Subscribe reads the stream as a consumer of the group until ctx is done or the broker is
+// closed. Every message must be acked, nacked or rejected; messages left unsettled are claimed
+// again once they have been idle for ClaimIdle.
+func (b *Broker) Subscribe(ctx context.Context) (<-chan models.Message, error) {
+	select {
+	case <-b.closing:
+		return nil, ErrClosed
+	default:
+	}
+
+	out := make(chan models.Message)
+	b.wg.Add(1)
+	go b.read(ctx, out)
+	return out, nil
+}
+
+// read delivers claimed and new messages to out
+func (b *Broker) read(ctx context.Context, out chan<- models.Message) {
+	defer b.wg.Done()
+	defer close(out)
+
+	ctx, cancel := b.withClosing(ctx)
+	defer cancel()
+
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		var entries []redis.XMessage
+		var err error
+
+		// pending messages of dead consumers are claimed before new ones are read
+		if time.Since(lastClaim) >= b.claimIdle/2 {
+			entries, err = b.claim(ctx)
+			lastClaim = time.Now()
+		}
+		if err == nil && len(entries) == 0 {
+			entries, err = b.readNew(ctx)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.logger.Warn("Redis stream read failed", zap.Error(err))
+			if sleep(ctx, b.block) != nil {
+				return
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			m, err := b.message(entry)
+			if err != nil {
+				b.dropMalformed(ctx, entry, err)
+				continue
+			}
+			select {
+			case out <- m:
+			case <-ctx.Done():
+				// the message stays pending and is claimed again
+				return
+			}
+		}
+	}
+}
+
+// claim takes over the messages that have been pending for longer than ClaimIdle
+func (b *Broker) claim(ctx context.Context) ([]redis.XMessage, error) {
+	var claimed []redis.XMessage
+	start := "0-0"
+	for {
+		entries, next, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   b.stream,
+			Group:    b.group,
+			Consumer: b.consumer,
+			MinIdle:  b.claimIdle,
+			Start:    start,
+			Count:    defaultReadCount,
+		}).Result()
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, entries...)
+		if next == "0-0" || next == "" || len(claimed) >= defaultReadCount {
+			if len(claimed) > 0 {
+				b.logger.Info("Claimed pending Redis stream messages", zap.Int("count", len(claimed)))
+			}
+			return claimed, nil
+		}
+		start = next
+	}
+}
+
+// readNew waits up to the block time for messages never delivered to the group
+func (b *Broker) readNew(ctx context.Context) ([]redis.XMessage, error) {
+	streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    b.group,
+		Consumer: b.consumer,
+		Streams:  []string{b.stream, ">"},
+		Count:    defaultReadCount,
+		Block:    b.block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []redis.XMessage
+	for _, s := range streams {
+		entries = append(entries, s.Messages...)
+	}
+	return entries, nil
+}
+
+// message decodes a stream entry into a message settled through the broker
+func (b *Broker) message(entry redis.XMessage) (models.Message, error) {
+	env, err := decode(entry)
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	entryID := entry.ID
+	settle := func(requeue bool) error {
+		if requeue {
+			return b.requeue(entryID, env)
+		}
+		return b.reject(entryID, env)
+	}
+	return models.Message{
+		ID:          env.ID,
+		Body:        env.Body,
+		Headers:     env.Headers,
+		ContentType: env.ContentType,
+		AckFunc:     func() error { return b.ack(context.Background(), b.client, entryID) },
+		NackFunc:    settle,
+		RejectFunc:  settle,
+	}, nil
+}
+
+// ack removes a delivered entry from the group's pending list and from the stream
+func (b *Broker) ack(ctx context.Context, c redis.Cmdable, entryID string) error {
+	if err := c.XAck(ctx, b.stream, b.group, entryID).Err(); err != nil {
+		return err
+	}
+	return c.XDel(ctx, b.stream, entryID).Err()
+}
+
+// requeue adds a delivered message back to the end of the stream and acks the original entry
+func (b *Broker) requeue(entryID string, env envelope) error {
+	ctx := context.Background()
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err := b.add(ctx, pipe, b.stream, env); err != nil {
+			return err
+		}
+		return b.ack(ctx, pipe, entryID)
+	})
+	return err
+}
+
+// reject moves a delivered message to the dead-letter stream, as a queue with a dead-letter
+// exchange does with rejected messages
+func (b *Broker) reject(entryID string, env envelope) error {
+	headers := copyHeaders(env.Headers)
+	if _, ok := headers[models.HeaderFailureReason]; !ok {
+		headers[models.HeaderFailureReason] = "rejected by broker"
+		headers[models.HeaderOriginalQueue] = b.stream
+		headers[models.HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+	}
+	env.Headers = headers
+
+	ctx := context.Background()
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err := b.add(ctx, pipe, b.deadQueue, env); err != nil {
+			return err
+		}
+		return b.ack(ctx, pipe, entryID)
+	})
+	return err
+}
+
+// dropMalformed dead-letters an entry that was not written by this broker
+func (b *Broker) dropMalformed(ctx context.Context, entry redis.XMessage, cause error) {
+	b.logger.Error("Dead-lettering malformed Redis stream entry", zap.String("entry_id", entry.ID), zap.Error(cause))
+
+	raw, _ := json.Marshal(entry.Values)
+	env := envelope{ID: entry.ID, Body: raw, Headers: map[string]any{
+		models.HeaderFailureReason:  cause.Error(),
+		models.HeaderExceptionClass: fmt.Sprintf("%T", cause),
+		models.HeaderOriginalQueue:  b.stream,
+		models.HeaderFailedAt:       time.Now().UTC().Format(time.RFC3339),
+	}}
+	_, err := b.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err := b.add(ctx, pipe, b.deadQueue, env); err != nil {
+			return err
+		}
+		return b.ack(ctx, pipe, entry.ID)
+	})
+	if err != nil {
+		b.logger.Error("Failed to dead-letter malformed Redis stream entry", zap.String("entry_id", entry.ID), zap.Error(err))
+	}
+}
+
+// Retry schedules a message that failed transiently for another attempt once the backoff of
+// its attempt has passed. A message on its last attempt is dead-lettered instead.
+func (b *Broker) Retry(ctx context.Context, m models.Message, failure models.Failure) error {
+	attempt := m.Attempt()
+	if attempt >= int32(b.retry.MaxAttempts) {
+		return b.DeadLetter(ctx, m, failure)
+	}
+
+	headers := copyHeaders(m.Headers)
+	headers[models.HeaderAttemptCount] = attempt + 1
+	headers[models.HeaderFailureReason] = failure.Reason
+	headers[models.HeaderExceptionClass] = failure.Class
+
+	data, err := json.Marshal(envelope{
+		ID:          m.ID,
+		Body:        m.Body,
+		Headers:     headers,
+		ContentType: m.ContentType,
+		Nonce:       uuid.NewString(),
+	})
+	if err != nil {
+		return err
+	}
+
+	due := time.Now().Add(b.retry.delay(attempt))
+	if err := b.client.ZAdd(ctx, b.retrySet, redis.Z{Score: float64(due.UnixMilli()), Member: string(data)}).Err(); err != nil {
+		// requeued at once rather than lost
+		return errors.Join(err, m.Nack(true))
+	}
+	return m.Ack()
+}
+
+// moveScript moves the due members of the retry set KEYS[1] to the stream KEYS[2] atomically
+var moveScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, member in ipairs(due) do
+	redis.call('XADD', KEYS[2], '*', ARGV[3], member)
+	redis.call('ZREM', KEYS[1], member)
+end
+return #due
+`)
+
+// moveRetries returns due retries to the stream until the broker is closed
+func (b *Broker) moveRetries() {
+	defer b.wg.Done()
+
+	ctx, cancel := b.withClosing(context.Background())
+	defer cancel()
+
+	interval := min(max(b.retry.InitialDelay/4, 10*time.Millisecond), time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			moved, err := moveScript.Run(ctx, b.client, []string{b.retrySet, b.stream},
+				time.Now().UnixMilli(), moveBatch, messageField).Int()
+			if err != nil {
+				if ctx.Err() == nil {
+					b.logger.Warn("Failed to move due retries to the Redis stream", zap.Error(err))
+				}
+				break
+			}
+			if moved < moveBatch {
+				break
+			}
+		}
+	}
+}
+
+// DeadLetter moves a message to the dead-letter stream with the failure headers and acks it
+func (b *Broker) DeadLetter(ctx context.Context, m models.Message, failure models.Failure) error {
+	if err := b.PublishDeadLetter(ctx, m, failure); err != nil {
+		return errors.Join(err, m.Nack(true))
+	}
+	return m.Ack()
+}
+
+// PublishDeadLetter adds a copy of a message carrying the failure headers to the dead-letter
+// stream, without settling the message
+func (b *Broker) PublishDeadLetter(ctx context.Context, m models.Message, failure models.Failure) error {
+	headers := copyHeaders(m.Headers)
+	headers[models.HeaderFailureReason] = failure.Reason
+	headers[models.HeaderExceptionClass] = failure.Class
+	headers[models.HeaderOriginalQueue] = b.stream
+	headers[models.HeaderAttemptCount] = m.Attempt()
+	headers[models.HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339)
+	if failure.Code != "" {
+		headers[models.HeaderPgErrorCode] = failure.Code
+	}
+
+	id := m.ID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	return b.add(ctx, b.client, b.deadQueue, envelope{ID: id, Body: m.Body, Headers: headers, ContentType: m.ContentType})
+}
+
+// Health pings Redis
+func (b *Broker) Health() error {
+	select {
+	case <-b.closing:
+		return ErrClosed
+	default:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return b.client.Ping(ctx).Err()
+}
+
+// Close ends every subscription and closes the connection. Messages that were delivered but
+// not settled stay pending for the other consumers of the group.
+func (b *Broker) Close() error {
+	b.closeOnce.Do(func() { close(b.closing) })
+	b.wg.Wait()
+	return b.client.Close()
+}
+
+// withClosing returns a context that is also canceled by Close
+func (b *Broker) withClosing(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-b.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// decode reads the envelope of a stream entry
+func decode(entry redis.XMessage) (envelope, error) {
+	var env envelope
+	raw, ok := entry.Values[messageField].(string)
+	if !ok {
+		return env, fmt.Errorf("stream entry %s has no %q field", entry.ID, messageField)
+	}
+	if err := json.Unmarshal([]byte(raw), &env); err != nil {
+		return env, err
+	}
+	env.Nonce = ""
+	return env, nil
+}
+
+// copyHeaders returns a copy of headers that can be modified for a republished message
+func copyHeaders(headers map[string]any) map[string]any {
+	out := make(map[string]any, len(headers)+4)
+	for k, v := range headers {
+		out[k] = v
+	}
+	return out
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
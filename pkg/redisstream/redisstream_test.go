@@ -0,0 +1,73 @@
+package redisstream
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals_backend_task/pkg/brokertest"
+)
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: brokertest.MaxAttempts, InitialDelay: 20 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+func newTestBroker(t *testing.T, uri string, opts ...Option) *Broker {
+	opts = append([]Option{WithRetryPolicy(testRetryPolicy), WithBlock(20 * time.Millisecond)}, opts...)
+	b, err := New(uri, "users", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestBroker(t *testing.T) {
+	brokertest.Run(t, func(t *testing.T) brokertest.Broker {
+		return newTestBroker(t, "redis://"+miniredis.RunT(t).Addr())
+	})
+}
+
+// TestBroker_ClaimPending checks messages a consumer read but never settled are delivered
+// to another consumer of the group once they have been idle long enough.
+func TestBroker_ClaimPending(t *testing.T) {
+	uri := "redis://" + miniredis.RunT(t).Addr()
+	ctx := context.Background()
+
+	dead := newTestBroker(t, uri, WithConsumerName("dead"))
+	require.NoError(t, dead.Publish(ctx, []byte("orphaned")))
+
+	deadCtx, cancel := context.WithCancel(ctx)
+	messages, err := dead.Subscribe(deadCtx)
+	require.NoError(t, err)
+	orphaned := <-messages
+	cancel()
+	require.NoError(t, dead.Close())
+
+	alive := newTestBroker(t, uri, WithConsumerName("alive"), WithClaimIdle(50*time.Millisecond))
+	messages, err = alive.Subscribe(ctx)
+	require.NoError(t, err)
+
+	select {
+	case m := <-messages:
+		assert.Equal(t, orphaned.ID, m.ID)
+		assert.Equal(t, "orphaned", string(m.Body))
+		require.NoError(t, m.Ack())
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending message was not claimed")
+	}
+}
+
+// TestBroker_HashTaggedKeys checks every key of a queue shares the queue's hash tag, so the keys
+// land in one Redis Cluster slot.
+func TestBroker_HashTaggedKeys(t *testing.T) {
+	s := miniredis.RunT(t)
+	b := newTestBroker(t, "redis://"+s.Addr())
+	ctx := context.Background()
+
+	require.NoError(t, b.Publish(ctx, []byte("tagged")))
+	assert.Equal(t, []string{"{users}"}, s.Keys())
+	for _, key := range []string{b.stream, b.retrySet, b.deadQueue} {
+		assert.True(t, strings.HasPrefix(key, "{users}"), key)
+	}
+}
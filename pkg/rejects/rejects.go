@@ -0,0 +1,293 @@
+package rejects
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Reason is a machine-readable code describing why a row was rejected.
+type Reason string
+
+const (
+	ReasonMalformed    Reason = "malformed_record"
+	ReasonFieldCount   Reason = "field_count"
+	ReasonBadInteger   Reason = "bad_integer"
+	ReasonBadTimestamp Reason = "bad_timestamp"
+	ReasonBadEmail     Reason = "bad_email"
+	ReasonValidation   Reason = "validation_failed"
+)
+
+// Error is a row-level failure carrying its reason code, the offending field
+// and, for validation failures, the rule that failed.
+type Error struct {
+	Reason Reason
+	Field  string
+	Rule   string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+	}
+	return fmt.Sprintf("%s in %s: %v", e.Reason, e.Field, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Reject is a single rejected input row.
+type Reject struct {
+	Line   int64    `json:"line"`
+	Reason Reason   `json:"reason"`
+	Field  string   `json:"field,omitempty"`
+	Rule   string   `json:"rule,omitempty"`
+	Detail string   `json:"detail,omitempty"`
+	Raw    []string `json:"raw"`
+}
+
+// Writer records rejected rows.
+type Writer interface {
+	Write(reject Reject) error
+	Close() error
+}
+
+// Trimmer is implemented by writers that can drop the rejects already recorded
+// past an input line, so a run resumed from a checkpoint does not record them twice.
+type Trimmer interface {
+	TrimAfter(line int64) error
+}
+
+// Open creates a rejects file at path, as JSON Lines when the extension is
+// .jsonl or .ndjson and as CSV otherwise. Rows are appended to an existing
+// file unless truncate is set. The input header is used for the CSV header.
+// The returned writer implements Trimmer.
+func Open(path string, header []string, truncate bool) (Writer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return &fileWriter{Writer: NewJSONLWriter(file), file: file, jsonl: true}, nil
+	}
+
+	w := NewCSVWriter(file)
+	if info.Size() == 0 {
+		if err := w.WriteHeader(header); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return &fileWriter{Writer: w, file: file}, nil
+}
+
+// fileWriter is a rejects writer on a file opened by Open
+type fileWriter struct {
+	Writer
+	file  *os.File
+	jsonl bool
+}
+
+// TrimAfter rewrites the file without the rejects of lines after line, keeping the CSV header.
+// It must not be called concurrently with Write.
+func (f *fileWriter) TrimAfter(line int64) error {
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f.file)
+	if err != nil {
+		return err
+	}
+
+	var kept []byte
+	if f.jsonl {
+		kept, err = trimJSONL(data, line)
+	} else {
+		kept, err = trimCSV(data, line)
+	}
+	if err != nil {
+		return err
+	}
+
+	// the file is opened for appending, so writes land at the new end once it is truncated
+	if err := f.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.file.Write(kept)
+	return err
+}
+
+// trimJSONL keeps the JSON Lines rejects of lines up to line, unchanged
+func trimJSONL(data []byte, line int64) ([]byte, error) {
+	var kept []byte
+	for _, raw := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		var reject Reject
+		if err := json.Unmarshal(raw, &reject); err != nil {
+			return nil, err
+		}
+		if reject.Line <= line {
+			kept = append(kept, raw...)
+		}
+	}
+	return kept, nil
+}
+
+// trimCSV keeps the header and the CSV rejects of lines up to line
+func trimCSV(data []byte, line int64) ([]byte, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	var out bytes.Buffer
+	writer := csv.NewWriter(&out)
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !first {
+			n, err := strconv.ParseInt(record[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("reject line %q: %w", record[0], err)
+			}
+			if n > line {
+				continue
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	return out.Bytes(), writer.Error()
+}
+
+// metaColumns precede the original row in CSV rejects. The original columns keep their
+// header names, so a corrected file can be re-submitted as is.
+var metaColumns = []string{"reject_line", "reject_reason", "reject_field", "reject_rule", "reject_detail"}
+
+// CSVWriter writes rejects as CSV rows: the reject details followed by the raw fields.
+type CSVWriter struct {
+	mu     sync.Mutex
+	writer *csv.Writer
+	closer io.Closer
+}
+
+// NewCSVWriter creates a CSV rejects writer on w.
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	c := &CSVWriter{writer: csv.NewWriter(w)}
+	if closer, ok := w.(io.Closer); ok {
+		c.closer = closer
+	}
+	return c
+}
+
+// WriteHeader writes the header row for the given input header.
+func (c *CSVWriter) WriteHeader(header []string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.writer.Write(append(append([]string{}, metaColumns...), header...)); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// Write appends a reject.
+func (c *CSVWriter) Write(reject Reject) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record := append([]string{
+		strconv.FormatInt(reject.Line, 10),
+		string(reject.Reason),
+		reject.Field,
+		reject.Rule,
+		reject.Detail,
+	}, reject.Raw...)
+
+	if err := c.writer.Write(record); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// Close flushes and closes the underlying file.
+func (c *CSVWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writer.Flush()
+	err := c.writer.Error()
+	if c.closer != nil {
+		if cerr := c.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// JSONLWriter writes one JSON object per reject.
+type JSONLWriter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+// NewJSONLWriter creates a JSON Lines rejects writer on w.
+func NewJSONLWriter(w io.Writer) *JSONLWriter {
+	j := &JSONLWriter{encoder: json.NewEncoder(w)}
+	if closer, ok := w.(io.Closer); ok {
+		j.closer = closer
+	}
+	return j
+}
+
+// Write appends a reject.
+func (j *JSONLWriter) Write(reject Reject) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.encoder.Encode(reject)
+}
+
+// Close closes the underlying file.
+func (j *JSONLWriter) Close() error {
+	if j.closer == nil {
+		return nil
+	}
+	return j.closer.Close()
+}
@@ -0,0 +1,88 @@
+package rejects
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func write(t *testing.T, w Writer, lines ...int64) {
+	t.Helper()
+	for _, line := range lines {
+		require.NoError(t, w.Write(Reject{Line: line, Reason: ReasonBadEmail, Field: "email", Detail: "no @", Raw: []string{"1", "a,b"}}))
+	}
+}
+
+func TestCSVTrimAfter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejects.csv")
+	w, err := Open(path, []string{"id", "email"}, true)
+	require.NoError(t, err)
+	write(t, w, 2, 5, 9, 12)
+
+	require.NoError(t, w.(Trimmer).TrimAfter(9))
+	// later rejects are appended after the kept ones
+	write(t, w, 10)
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "reject_line,reject_reason,reject_field,reject_rule,reject_detail,id,email\n"+
+		"2,bad_email,email,,no @,1,\"a,b\"\n"+
+		"5,bad_email,email,,no @,1,\"a,b\"\n"+
+		"9,bad_email,email,,no @,1,\"a,b\"\n"+
+		"10,bad_email,email,,no @,1,\"a,b\"\n", string(data))
+}
+
+func TestJSONLTrimAfter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejects.jsonl")
+	w, err := Open(path, []string{"id", "email"}, true)
+	require.NoError(t, err)
+	write(t, w, 3, 7)
+
+	require.NoError(t, w.(Trimmer).TrimAfter(5))
+	write(t, w, 6)
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var lines []int64
+	for _, raw := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var reject Reject
+		require.NoError(t, json.Unmarshal([]byte(raw), &reject))
+		lines = append(lines, reject.Line)
+	}
+	assert.Equal(t, []int64{3, 6}, lines)
+}
+
+func TestOpenAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "rejects.csv")
+
+	w, err := Open(path, []string{"id"}, false)
+	require.NoError(t, err)
+	write(t, w, 1)
+	require.NoError(t, w.Close())
+
+	// reopening without truncate keeps the rows and does not repeat the header
+	w, err = Open(path, []string{"id"}, false)
+	require.NoError(t, err)
+	write(t, w, 2)
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+	assert.True(t, strings.HasPrefix(string(data), "reject_line,"))
+
+	w, err = Open(path, []string{"id"}, true)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "reject_line,reject_reason,reject_field,reject_rule,reject_detail,id\n", string(data))
+}
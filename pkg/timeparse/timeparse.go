@@ -0,0 +1,239 @@
+package timeparse
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format identifies how a timestamp column is encoded.
+type Format string
+
+const (
+	FormatUnixSeconds Format = "unix_s"
+	FormatUnixMillis  Format = "unix_ms"
+	FormatUnixMicros  Format = "unix_us"
+	FormatRFC3339     Format = "rfc3339"
+	FormatLayout      Format = "layout"
+	FormatAuto        Format = "auto"
+)
+
+// layoutPrefix introduces a custom Go reference layout, e.g. "layout:2006-01-02 15:04:05".
+const layoutPrefix = "layout:"
+
+// DefaultNullValues are the raw values treated as a missing timestamp when none are configured.
+var DefaultNullValues = []string{"-1"}
+
+// Magnitude thresholds used by FormatAuto. Second timestamps stay below 1e11
+// until the year 5138, so each unit is told apart by its number of digits.
+const (
+	maxAutoSeconds = 1e11
+	maxAutoMillis  = 1e14
+	maxAutoMicros  = 1e17
+)
+
+// autoLayouts are tried in order by FormatAuto for values that are not integers.
+var autoLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Parser converts raw column values into timestamps.
+type Parser struct {
+	format Format
+	layout string
+	nulls  map[string]struct{}
+}
+
+// New builds a parser for a format name and the values that mean "no timestamp".
+// Empty values are always null. A nil nullValues uses DefaultNullValues.
+func New(format string, nullValues []string) (*Parser, error) {
+	f, layout, err := ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	if nullValues == nil {
+		nullValues = DefaultNullValues
+	}
+
+	p := &Parser{format: f, layout: layout, nulls: make(map[string]struct{}, len(nullValues))}
+	for _, v := range nullValues {
+		p.nulls[strings.ToLower(strings.TrimSpace(v))] = struct{}{}
+	}
+	return p, nil
+}
+
+// Default returns the parser used when no format is configured: FormatAuto with "-1" as null.
+// It reads the Unix seconds of the bundled sample data as well as the milliseconds of earlier
+// exports, whose values from 1973 on are above maxAutoSeconds.
+func Default() *Parser {
+	p, _ := New(string(FormatAuto), nil)
+	return p
+}
+
+// ParseFormat validates a format name such as "unix_ms", "rfc3339" or "layout:2006-01-02".
+// An empty name is FormatAuto. The returned layout is only set for custom layouts.
+func ParseFormat(name string) (Format, string, error) {
+	trimmed := strings.TrimSpace(name)
+	if len(trimmed) > len(layoutPrefix) && strings.EqualFold(trimmed[:len(layoutPrefix)], layoutPrefix) {
+		return FormatLayout, trimmed[len(layoutPrefix):], nil
+	}
+
+	switch strings.ToLower(trimmed) {
+	case "unix_s", "unix", "seconds", "s":
+		return FormatUnixSeconds, "", nil
+	case "unix_ms", "milliseconds", "ms":
+		return FormatUnixMillis, "", nil
+	case "unix_us", "microseconds", "us":
+		return FormatUnixMicros, "", nil
+	case "rfc3339", "iso8601":
+		return FormatRFC3339, "", nil
+	case "auto", "":
+		return FormatAuto, "", nil
+	}
+	return "", "", fmt.Errorf("unsupported timestamp format %q", name)
+}
+
+// Format reports the format the parser was built with.
+func (p *Parser) Format() Format {
+	return p.format
+}
+
+// IsNull reports whether a raw value is empty or one of the configured null values.
+func (p *Parser) IsNull(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return true
+	}
+	_, ok := p.nulls[value]
+	return ok
+}
+
+// Parse converts a raw value into a timestamp. ok is false for null values.
+func (p *Parser) Parse(value string) (t time.Time, ok bool, err error) {
+	if p.IsNull(value) {
+		return time.Time{}, false, nil
+	}
+	value = strings.TrimSpace(value)
+
+	switch p.format {
+	case FormatUnixSeconds:
+		t, err = parseUnix(value, time.Second)
+	case FormatUnixMillis:
+		t, err = parseUnix(value, time.Millisecond)
+	case FormatUnixMicros:
+		t, err = parseUnix(value, time.Microsecond)
+	case FormatRFC3339:
+		t, err = time.Parse(time.RFC3339Nano, value)
+	case FormatLayout:
+		t, err = time.Parse(p.layout, value)
+	case FormatAuto:
+		t, err = parseAuto(value)
+	default:
+		err = fmt.Errorf("unsupported timestamp format %q", p.format)
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t, true, nil
+}
+
+// parseUnix reads an integer count of unit since the Unix epoch.
+func parseUnix(value string, unit time.Duration) (time.Time, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a Unix timestamp", value)
+	}
+	return fromUnit(n, unit), nil
+}
+
+// parseAuto picks the Unix unit of an integer by its magnitude and falls back to common layouts.
+func parseAuto(value string) (time.Time, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// compared as integers: converting to float64 rounds values just below a threshold up to it
+		abs := uint64(n)
+		if n < 0 {
+			abs = uint64(-(n + 1)) + 1
+		}
+		switch {
+		case abs < maxAutoSeconds:
+			return fromUnit(n, time.Second), nil
+		case abs < maxAutoMillis:
+			return fromUnit(n, time.Millisecond), nil
+		case abs < maxAutoMicros:
+			return fromUnit(n, time.Microsecond), nil
+		default:
+			return time.Unix(0, n), nil
+		}
+	}
+
+	for _, layout := range autoLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a recognised timestamp", value)
+}
+
+func fromUnit(n int64, unit time.Duration) time.Time {
+	switch unit {
+	case time.Second:
+		return time.Unix(n, 0)
+	case time.Millisecond:
+		return time.UnixMilli(n)
+	default:
+		return time.UnixMicro(n)
+	}
+}
+
+// Columns selects the parser of each timestamp column, falling back to Default.
+type Columns struct {
+	Default *Parser
+	Fields  map[string]*Parser
+}
+
+// DefaultColumns parses every column with the Default parser.
+func DefaultColumns() *Columns {
+	return &Columns{Default: Default()}
+}
+
+// For returns the parser configured for a column.
+func (c *Columns) For(field string) *Parser {
+	if p, ok := c.Fields[field]; ok {
+		return p
+	}
+	return c.Default
+}
+
+// FromEnv reads TIMESTAMP_FORMAT, a TIMESTAMP_FORMAT_<FIELD> override for each of the given
+// fields and the comma-separated TIMESTAMP_NULL_VALUES.
+func FromEnv(fields ...string) (*Columns, error) {
+	var nulls []string
+	if v, ok := os.LookupEnv("TIMESTAMP_NULL_VALUES"); ok {
+		nulls = strings.Split(v, ",")
+	}
+
+	def, err := New(os.Getenv("TIMESTAMP_FORMAT"), nulls)
+	if err != nil {
+		return nil, fmt.Errorf("TIMESTAMP_FORMAT: %w", err)
+	}
+
+	columns := &Columns{Default: def, Fields: make(map[string]*Parser)}
+	for _, field := range fields {
+		name := "TIMESTAMP_FORMAT_" + strings.ToUpper(field)
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		parser, err := New(v, nulls)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		columns.Fields[field] = parser
+	}
+	return columns, nil
+}
@@ -0,0 +1,131 @@
+package timeparse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoThresholds(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"1612137600", time.Unix(1612137600, 0)},
+		{"99999999999", time.Unix(99999999999, 0)},
+		// from 1e11 on values are milliseconds
+		{"100000000000", time.UnixMilli(100000000000)},
+		{"1612137600000", time.UnixMilli(1612137600000)},
+		{"99999999999999", time.UnixMilli(99999999999999)},
+		// from 1e14 on microseconds
+		{"100000000000000", time.UnixMicro(100000000000000)},
+		{"1612137600000000", time.UnixMicro(1612137600000000)},
+		{"99999999999999999", time.UnixMicro(99999999999999999)},
+		// from 1e17 on nanoseconds
+		{"100000000000000000", time.Unix(0, 100000000000000000)},
+		{"1612137600000000000", time.Unix(0, 1612137600000000000)},
+		{"-1612137600", time.Unix(-1612137600, 0)},
+		{"2021-02-01T00:00:00Z", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"2021-02-01 12:30:00", time.Date(2021, 2, 1, 12, 30, 0, 0, time.UTC)},
+		{"2021-02-01", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	p, err := New("auto", nil)
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok, err := p.Parse(tt.value)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.True(t, tt.want.Equal(got), "got %s, want %s", got, tt.want)
+		})
+	}
+
+	_, _, err = p.Parse("yesterday")
+	assert.Error(t, err)
+}
+
+func TestDefaultIsAuto(t *testing.T) {
+	p := Default()
+	assert.Equal(t, FormatAuto, p.Format())
+
+	// the seconds of the sample data and the milliseconds of earlier exports both parse to 2021
+	for _, value := range []string{"1612137600", "1612137600000"} {
+		got, ok, err := p.Parse(value)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 2021, got.UTC().Year(), value)
+	}
+
+	f, _, err := ParseFormat("")
+	require.NoError(t, err)
+	assert.Equal(t, FormatAuto, f)
+}
+
+func TestFixedFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		value  string
+		want   time.Time
+	}{
+		{"unix_s", "1612137600", time.Unix(1612137600, 0)},
+		{"unix_ms", "1612137600", time.UnixMilli(1612137600)},
+		{"unix_us", "1612137600000000", time.UnixMicro(1612137600000000)},
+		{"rfc3339", "2021-02-01T00:00:00+02:00", time.Date(2021, 1, 31, 22, 0, 0, 0, time.UTC)},
+		{"layout:02/01/2006", "01/02/2021", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			p, err := New(tt.format, nil)
+			require.NoError(t, err)
+			got, ok, err := p.Parse(tt.value)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.True(t, tt.want.Equal(got), "got %s, want %s", got, tt.want)
+		})
+	}
+
+	_, err := New("julian", nil)
+	assert.Error(t, err)
+}
+
+func TestNullValues(t *testing.T) {
+	// the default null list only holds -1; empty values are always null
+	p := Default()
+	for _, value := range []string{"", "  ", "-1", " -1 "} {
+		assert.True(t, p.IsNull(value), "%q", value)
+		_, ok, err := p.Parse(value)
+		assert.NoError(t, err)
+		assert.False(t, ok, "%q", value)
+	}
+	assert.False(t, p.IsNull("0"))
+
+	// a configured list replaces the default and is matched case-insensitively
+	p, err := New("auto", []string{"NULL", " n/a ", "0"})
+	require.NoError(t, err)
+	for _, value := range []string{"", "null", "Null", "N/A", "0"} {
+		assert.True(t, p.IsNull(value), "%q", value)
+	}
+	_, ok, err := p.Parse("-1")
+	require.NoError(t, err)
+	assert.True(t, ok, "-1 is a timestamp once the null list is replaced")
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("TIMESTAMP_FORMAT", "")
+	t.Setenv("TIMESTAMP_FORMAT_DELETED_AT", "rfc3339")
+	t.Setenv("TIMESTAMP_NULL_VALUES", "never,-1")
+
+	columns, err := FromEnv("created_at", "deleted_at")
+	require.NoError(t, err)
+	assert.Equal(t, FormatAuto, columns.For("created_at").Format())
+	assert.Equal(t, FormatRFC3339, columns.For("deleted_at").Format())
+	assert.True(t, columns.For("deleted_at").IsNull("never"))
+
+	t.Setenv("TIMESTAMP_FORMAT_CREATED_AT", "weeks")
+	_, err = FromEnv("created_at")
+	assert.ErrorContains(t, err, "TIMESTAMP_FORMAT_CREATED_AT")
+}
@@ -0,0 +1,91 @@
+package validation
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/viswals_backend_task/pkg/mapping"
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+const (
+	kindString = "string"
+	kindInt    = "integer"
+	kindTime   = "timestamp"
+)
+
+// fieldKinds lists the user fields rules may refer to.
+var fieldKinds = map[string]string{
+	mapping.FieldID:           kindInt,
+	mapping.FieldFirstName:    kindString,
+	mapping.FieldLastName:     kindString,
+	mapping.FieldEmailAddress: kindString,
+	mapping.FieldCreatedAt:    kindTime,
+	mapping.FieldDeletedAt:    kindTime,
+	mapping.FieldMergedAt:     kindTime,
+	mapping.FieldParentUserID: kindInt,
+}
+
+func stringField(u *models.UserDetails, field string) string {
+	switch field {
+	case mapping.FieldFirstName:
+		return u.FirstName
+	case mapping.FieldLastName:
+		return u.LastName
+	case mapping.FieldEmailAddress:
+		return u.EmailAddress
+	}
+	return ""
+}
+
+func intField(u *models.UserDetails, field string) int64 {
+	switch field {
+	case mapping.FieldID:
+		return u.ID
+	case mapping.FieldParentUserID:
+		return u.ParentUserId
+	}
+	return 0
+}
+
+func timeField(u *models.UserDetails, field string) sql.NullTime {
+	switch field {
+	case mapping.FieldCreatedAt:
+		return u.CreatedAt
+	case mapping.FieldDeletedAt:
+		return u.DeletedAt
+	case mapping.FieldMergedAt:
+		return u.MergedAt
+	}
+	return sql.NullTime{}
+}
+
+// isZero reports whether the field holds its empty value.
+func isZero(u *models.UserDetails, field string) bool {
+	switch fieldKinds[field] {
+	case kindString:
+		return stringField(u, field) == ""
+	case kindInt:
+		return intField(u, field) == 0
+	case kindTime:
+		return !timeField(u, field).Valid
+	}
+	return true
+}
+
+// fieldString renders any field for equality comparisons.
+func fieldString(u *models.UserDetails, field string) string {
+	switch fieldKinds[field] {
+	case kindString:
+		return stringField(u, field)
+	case kindInt:
+		return fmt.Sprint(intField(u, field))
+	case kindTime:
+		t := timeField(u, field)
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.UTC().String()
+	}
+	return ""
+}
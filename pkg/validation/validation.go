@@ -0,0 +1,239 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/viswals_backend_task/pkg/mapping"
+	"github.com/viswals_backend_task/pkg/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule types understood by the validator.
+const (
+	TypeRequired  = "required"
+	TypeEmail     = "email"
+	TypeNotFuture = "not_future"
+	TypeNotBefore = "not_before"
+	TypeNotEqual  = "not_equal"
+)
+
+// RuleConfig declares a single rule. Field and Other name user fields by
+// their JSON names, e.g. "created_at".
+type RuleConfig struct {
+	Name      string   `json:"name" yaml:"name"`
+	Type      string   `json:"type" yaml:"type"`
+	Field     string   `json:"field" yaml:"field"`
+	Other     string   `json:"other,omitempty" yaml:"other,omitempty"`
+	Tolerance Duration `json:"tolerance,omitempty" yaml:"tolerance,omitempty"`
+	Disabled  bool     `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// Config is the rule set loaded from a YAML or JSON file.
+type Config struct {
+	Rules []RuleConfig `json:"rules" yaml:"rules"`
+}
+
+// Duration is a time.Duration written as a string such as "5m" in config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.parse(node.Value)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultConfig returns the rules applied when no rules file is configured.
+func DefaultConfig() Config {
+	return Config{Rules: []RuleConfig{
+		{Name: "email_syntax", Type: TypeEmail, Field: mapping.FieldEmailAddress},
+		{Name: "created_at_not_future", Type: TypeNotFuture, Field: mapping.FieldCreatedAt, Tolerance: Duration(5 * time.Minute)},
+		{Name: "deleted_at_after_created_at", Type: TypeNotBefore, Field: mapping.FieldDeletedAt, Other: mapping.FieldCreatedAt},
+		{Name: "merged_at_after_created_at", Type: TypeNotBefore, Field: mapping.FieldMergedAt, Other: mapping.FieldCreatedAt},
+		{Name: "parent_not_self", Type: TypeNotEqual, Field: mapping.FieldParentUserID, Other: mapping.FieldID},
+	}}
+}
+
+// LoadConfig reads a rules file, as YAML for .yaml/.yml files and as JSON otherwise.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	default:
+		err = json.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Violation describes a rule a user record failed.
+type Violation struct {
+	Rule    string
+	Field   string
+	Message string
+}
+
+// Validator runs a set of rules against user records.
+type Validator struct {
+	rules []rule
+	now   func() time.Time
+}
+
+type rule struct {
+	RuleConfig
+	check func(v *Validator, user *models.UserDetails) string
+}
+
+// New compiles the rule set, rejecting unknown rule types or fields.
+func New(cfg Config) (*Validator, error) {
+	v := &Validator{now: time.Now}
+
+	for i, rc := range cfg.Rules {
+		if rc.Disabled {
+			continue
+		}
+		if rc.Name == "" {
+			rc.Name = fmt.Sprintf("%s_%s", rc.Type, rc.Field)
+		}
+
+		check, err := compile(rc)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i+1, rc.Name, err)
+		}
+		v.rules = append(v.rules, rule{RuleConfig: rc, check: check})
+	}
+	return v, nil
+}
+
+// Validate returns every rule the user violates, in rule order.
+func (v *Validator) Validate(user *models.UserDetails) []Violation {
+	var violations []Violation
+	for _, r := range v.rules {
+		if msg := r.check(v, user); msg != "" {
+			violations = append(violations, Violation{Rule: r.Name, Field: r.Field, Message: msg})
+		}
+	}
+	return violations
+}
+
+// compile turns a rule declaration into a check returning a message on failure.
+func compile(rc RuleConfig) (func(*Validator, *models.UserDetails) string, error) {
+	kind, ok := fieldKinds[rc.Field]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %q", rc.Field)
+	}
+	if rc.Other != "" {
+		if otherKind, ok := fieldKinds[rc.Other]; !ok {
+			return nil, fmt.Errorf("unknown field %q", rc.Other)
+		} else if otherKind != kind {
+			return nil, fmt.Errorf("fields %q and %q have different types", rc.Field, rc.Other)
+		}
+	}
+
+	requireKind := func(want string) error {
+		if kind != want {
+			return fmt.Errorf("rule type %q needs a %s field, %q is a %s", rc.Type, want, rc.Field, kind)
+		}
+		return nil
+	}
+	requireOther := func() error {
+		if rc.Other == "" {
+			return fmt.Errorf("rule type %q needs an 'other' field", rc.Type)
+		}
+		return nil
+	}
+
+	switch rc.Type {
+	case TypeRequired:
+		return func(_ *Validator, u *models.UserDetails) string {
+			if isZero(u, rc.Field) {
+				return fmt.Sprintf("%s is required", rc.Field)
+			}
+			return ""
+		}, nil
+
+	case TypeEmail:
+		if err := requireKind(kindString); err != nil {
+			return nil, err
+		}
+		return func(_ *Validator, u *models.UserDetails) string {
+			value := stringField(u, rc.Field)
+			addr, err := mail.ParseAddress(value)
+			if err != nil || addr.Address != value {
+				return fmt.Sprintf("%s %q is not a valid email address", rc.Field, value)
+			}
+			return ""
+		}, nil
+
+	case TypeNotFuture:
+		if err := requireKind(kindTime); err != nil {
+			return nil, err
+		}
+		return func(v *Validator, u *models.UserDetails) string {
+			t := timeField(u, rc.Field)
+			if t.Valid && t.Time.After(v.now().Add(time.Duration(rc.Tolerance))) {
+				return fmt.Sprintf("%s %s is in the future", rc.Field, t.Time.UTC().Format(time.RFC3339))
+			}
+			return ""
+		}, nil
+
+	case TypeNotBefore:
+		if err := errors.Join(requireKind(kindTime), requireOther()); err != nil {
+			return nil, err
+		}
+		return func(_ *Validator, u *models.UserDetails) string {
+			t, other := timeField(u, rc.Field), timeField(u, rc.Other)
+			if t.Valid && other.Valid && t.Time.Before(other.Time) {
+				return fmt.Sprintf("%s is before %s", rc.Field, rc.Other)
+			}
+			return ""
+		}, nil
+
+	case TypeNotEqual:
+		if err := requireOther(); err != nil {
+			return nil, err
+		}
+		return func(_ *Validator, u *models.UserDetails) string {
+			if !isZero(u, rc.Field) && fieldString(u, rc.Field) == fieldString(u, rc.Other) {
+				return fmt.Sprintf("%s must differ from %s", rc.Field, rc.Other)
+			}
+			return ""
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown rule type %q", rc.Type)
+}
@@ -0,0 +1,192 @@
+package validation
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals_backend_task/pkg/mapping"
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+var now = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func at(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: true}
+}
+
+// validUser passes every default rule
+func validUser() *models.UserDetails {
+	return &models.UserDetails{
+		ID:           1,
+		FirstName:    "Jane",
+		LastName:     "Doe",
+		EmailAddress: "jane@example.com",
+		CreatedAt:    at(now.Add(-time.Hour)),
+		ParentUserId: 2,
+	}
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   RuleConfig
+		modify func(*models.UserDetails)
+		want   string
+	}{
+		{
+			name:   "required string",
+			rule:   RuleConfig{Type: TypeRequired, Field: mapping.FieldLastName},
+			modify: func(u *models.UserDetails) { u.LastName = "" },
+			want:   "last_name is required",
+		},
+		{
+			name:   "required timestamp",
+			rule:   RuleConfig{Type: TypeRequired, Field: mapping.FieldCreatedAt},
+			modify: func(u *models.UserDetails) { u.CreatedAt = sql.NullTime{} },
+			want:   "created_at is required",
+		},
+		{name: "required present", rule: RuleConfig{Type: TypeRequired, Field: mapping.FieldID}, modify: func(*models.UserDetails) {}},
+		{
+			name:   "email",
+			rule:   RuleConfig{Type: TypeEmail, Field: mapping.FieldEmailAddress},
+			modify: func(u *models.UserDetails) { u.EmailAddress = "jane.example.com" },
+			want:   `email_address "jane.example.com" is not a valid email address`,
+		},
+		{
+			name:   "email with display name",
+			rule:   RuleConfig{Type: TypeEmail, Field: mapping.FieldEmailAddress},
+			modify: func(u *models.UserDetails) { u.EmailAddress = "Jane <jane@example.com>" },
+			want:   `email_address "Jane <jane@example.com>" is not a valid email address`,
+		},
+		{
+			name:   "not future",
+			rule:   RuleConfig{Type: TypeNotFuture, Field: mapping.FieldCreatedAt, Tolerance: Duration(time.Minute)},
+			modify: func(u *models.UserDetails) { u.CreatedAt = at(now.Add(2 * time.Minute)) },
+			want:   "created_at 2024-06-01T12:02:00Z is in the future",
+		},
+		{
+			name:   "not future within tolerance",
+			rule:   RuleConfig{Type: TypeNotFuture, Field: mapping.FieldCreatedAt, Tolerance: Duration(time.Minute)},
+			modify: func(u *models.UserDetails) { u.CreatedAt = at(now.Add(30 * time.Second)) },
+		},
+		{
+			name:   "not before",
+			rule:   RuleConfig{Type: TypeNotBefore, Field: mapping.FieldDeletedAt, Other: mapping.FieldCreatedAt},
+			modify: func(u *models.UserDetails) { u.DeletedAt = at(now.Add(-2 * time.Hour)) },
+			want:   "deleted_at is before created_at",
+		},
+		{
+			name:   "not before with a null field",
+			rule:   RuleConfig{Type: TypeNotBefore, Field: mapping.FieldDeletedAt, Other: mapping.FieldCreatedAt},
+			modify: func(u *models.UserDetails) { u.CreatedAt = sql.NullTime{}; u.DeletedAt = at(now) },
+		},
+		{
+			name:   "not equal",
+			rule:   RuleConfig{Type: TypeNotEqual, Field: mapping.FieldParentUserID, Other: mapping.FieldID},
+			modify: func(u *models.UserDetails) { u.ParentUserId = u.ID },
+			want:   "parent_user_id must differ from id",
+		},
+		{
+			name:   "not equal with an empty field",
+			rule:   RuleConfig{Type: TypeNotEqual, Field: mapping.FieldParentUserID, Other: mapping.FieldID},
+			modify: func(u *models.UserDetails) { u.ID, u.ParentUserId = 0, 0 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := New(Config{Rules: []RuleConfig{tt.rule}})
+			require.NoError(t, err)
+			v.now = func() time.Time { return now }
+
+			user := validUser()
+			assert.Empty(t, v.Validate(user), "the unmodified user is valid")
+
+			tt.modify(user)
+			violations := v.Validate(user)
+			if tt.want == "" {
+				assert.Empty(t, violations)
+				return
+			}
+			require.Len(t, violations, 1)
+			assert.Equal(t, tt.rule.Type+"_"+tt.rule.Field, violations[0].Rule)
+			assert.Equal(t, tt.rule.Field, violations[0].Field)
+			assert.Equal(t, tt.want, violations[0].Message)
+		})
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	v, err := New(DefaultConfig())
+	require.NoError(t, err)
+	v.now = func() time.Time { return now }
+
+	user := validUser()
+	assert.Empty(t, v.Validate(user))
+
+	user.EmailAddress = "nope"
+	user.MergedAt = at(now.Add(-2 * time.Hour))
+	user.ParentUserId = user.ID
+
+	var rules []string
+	for _, violation := range v.Validate(user) {
+		rules = append(rules, violation.Rule)
+	}
+	assert.Equal(t, []string{"email_syntax", "merged_at_after_created_at", "parent_not_self"}, rules)
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule RuleConfig
+		err  string
+	}{
+		{name: "unknown type", rule: RuleConfig{Type: "unique", Field: mapping.FieldID}, err: `unknown rule type "unique"`},
+		{name: "unknown field", rule: RuleConfig{Type: TypeRequired, Field: "phone"}, err: `unknown field "phone"`},
+		{name: "unknown other", rule: RuleConfig{Type: TypeNotEqual, Field: mapping.FieldID, Other: "phone"}, err: `unknown field "phone"`},
+		{name: "email on integer", rule: RuleConfig{Type: TypeEmail, Field: mapping.FieldID}, err: "needs a string field"},
+		{name: "not future on string", rule: RuleConfig{Type: TypeNotFuture, Field: mapping.FieldFirstName}, err: "needs a timestamp field"},
+		{name: "not before without other", rule: RuleConfig{Type: TypeNotBefore, Field: mapping.FieldDeletedAt}, err: "needs an 'other' field"},
+		{name: "mixed types", rule: RuleConfig{Type: TypeNotEqual, Field: mapping.FieldID, Other: mapping.FieldFirstName}, err: "different types"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(Config{Rules: []RuleConfig{tt.rule}})
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+
+	// disabled rules are not compiled at all
+	_, err := New(Config{Rules: []RuleConfig{{Type: "unique", Field: mapping.FieldID, Disabled: true}}})
+	assert.NoError(t, err)
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"rules.yaml": "rules:\n  - name: future\n    type: not_future\n    field: created_at\n    tolerance: 1h\n",
+		"rules.json": `{"rules":[{"name":"future","type":"not_future","field":"created_at","tolerance":"1h"}]}`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+			cfg, err := LoadConfig(path)
+			require.NoError(t, err)
+			assert.Equal(t, []RuleConfig{{Name: "future", Type: TypeNotFuture, Field: mapping.FieldCreatedAt, Tolerance: Duration(time.Hour)}}, cfg.Rules)
+		})
+	}
+
+	path := filepath.Join(dir, "bad.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"rules":[{"tolerance":"soon"}]}`), 0o644))
+	_, err := LoadConfig(path)
+	assert.ErrorContains(t, err, "invalid rules file")
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/lib/pq"
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+// stagingTable receives COPYed users before they are merged; it is dropped with the transaction
+const stagingTable = "user_details_staging"
+
+// CopyBulkUsers upserts users through the COPY protocol: they are streamed into a temporary
+// staging table and merged into user_details with the repository's conflict policy, all in one
+// transaction. Unlike a multi-row INSERT it is not bound by the parameter limit of a statement.
+func (r *Repository) CopyBulkUsers(ctx context.Context, users []*models.UserDetails) (models.BulkResult, error) {
+	unique := dedupeUsers(users, r.conflictPolicy)
+	result := models.BulkResult{Skipped: int64(len(users) - len(unique))}
+	if len(unique) == 0 {
+		return result, nil
+	}
+
+	clause, ok := upsertClauses[r.conflictPolicy]
+	if !ok {
+		return result, fmt.Errorf("unsupported conflict policy %q", r.conflictPolicy)
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	// LIKE always copies the NOT NULL constraints of user_details, so a null in a required column
+	// already fails the COPY; indexes, including the primary key, and check constraints are not copied
+	if _, err := tx.ExecContext(ctx, "CREATE TEMP TABLE "+stagingTable+" (LIKE user_details INCLUDING DEFAULTS EXCLUDING INDEXES EXCLUDING CONSTRAINTS) ON COMMIT DROP"); err != nil {
+		return result, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(stagingTable, "id", "first_name", "last_name", "email_address", "created_at", "deleted_at", "merged_at", "parent_user_id"))
+	if err != nil {
+		return result, err
+	}
+	for _, user := range unique {
+		if _, err := stmt.ExecContext(ctx, user.ID, user.FirstName, user.LastName, user.EmailAddress, user.CreatedAt, user.DeletedAt, user.MergedAt, user.ParentUserId); err != nil {
+			stmt.Close()
+			log.Println(err)
+			return result, bulkError(err)
+		}
+	}
+	// an Exec without arguments flushes the COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		log.Println(err)
+		return result, bulkError(err)
+	}
+	if err := stmt.Close(); err != nil {
+		return result, err
+	}
+
+	rows, err := tx.QueryContext(ctx, "INSERT INTO user_details ("+userColumns+") SELECT "+userColumns+" FROM "+stagingTable+clause)
+	if err != nil {
+		log.Println(err)
+		return result, bulkError(err)
+	}
+	if err := collectWritten(rows, unique, &result); err != nil {
+		return models.BulkResult{Skipped: int64(len(users) - len(unique))}, err
+	}
+	return result, tx.Commit()
+}
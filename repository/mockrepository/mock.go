@@ -20,9 +20,16 @@ func (db *MockRepository) CreateUser(ctx context.Context, user *models.UserDetai
 	return args.Error(0)
 }
 
-func (db *MockRepository) CreateBulkUsers(ctx context.Context, user []*models.UserDetails) error {
+func (db *MockRepository) CreateBulkUsers(ctx context.Context, user []*models.UserDetails) (models.BulkResult, error) {
 	args := db.Called(ctx, user)
-	return args.Error(0)
+	result, _ := args.Get(0).(models.BulkResult)
+	return result, args.Error(1)
+}
+
+func (db *MockRepository) CopyBulkUsers(ctx context.Context, user []*models.UserDetails) (models.BulkResult, error) {
+	args := db.Called(ctx, user)
+	result, _ := args.Get(0).(models.BulkResult)
+	return result, args.Error(1)
 }
 
 func (db *MockRepository) GetAllUsers(ctx context.Context) ([]*models.UserDetails, error) {
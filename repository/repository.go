@@ -17,15 +17,41 @@ var (
 	ErrNoData          = errors.New("requested data does not exist")
 	ErrDuplicate       = errors.New("data to create already exists")
 	DefaultFieldsCount = 8
+	// maxInsertRows keeps a multi-row INSERT under the 65535 parameters Postgres allows per statement
+	maxInsertRows = 65535 / DefaultFieldsCount
 )
 
+const userColumns = "id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id"
+
+// queryer runs a query on the database or inside a transaction
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
 type Repository struct {
 	postgres.Postgres
+	conflictPolicy models.ConflictPolicy
+}
+
+// Option defines functional options for the repository
+type Option func(*Repository)
+
+// WithConflictPolicy sets what CreateBulkUsers does with users whose ID already exists
+func WithConflictPolicy(policy models.ConflictPolicy) Option {
+	return func(r *Repository) {
+		if policy != "" {
+			r.conflictPolicy = policy
+		}
+	}
 }
 
 // NewRepository initializes a new repository instance.
-func NewRepository(postgres postgres.Postgres) *Repository {
-	return &Repository{postgres}
+func NewRepository(postgres postgres.Postgres, opts ...Option) *Repository {
+	r := &Repository{Postgres: postgres, conflictPolicy: models.ConflictSkip}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 // CreateUser inserts a single user record into the database.
@@ -38,12 +64,68 @@ func (r *Repository) CreateUser(ctx context.Context, user *models.UserDetails) e
 	return nil
 }
 
-// CreateBulkUsers inserts multiple user records into the database in a single query.
-func (r *Repository) CreateBulkUsers(ctx context.Context, users []*models.UserDetails) error {
+// upsertClauses resolves an existing ID according to each conflict policy. xmax is 0 only for
+// rows the statement inserted, which tells inserted and updated rows apart; rows left alone
+// are not returned at all.
+//
+// The source data has no version or update time, so newest compares the latest of a user's
+// timestamps, like UserDetails.Version: a user is created once and only changes afterwards by
+// being deleted or merged, which sets a later timestamp. A copy whose timestamps are not later,
+// such as a corrected email address, keeps the stored user; overwrite applies those.
+var upsertClauses = map[models.ConflictPolicy]string{
+	models.ConflictSkip:      " ON CONFLICT (id) DO NOTHING RETURNING id,(xmax = 0)",
+	models.ConflictOverwrite: " ON CONFLICT (id) DO UPDATE SET " + upsertSet + " RETURNING id,(xmax = 0)",
+	models.ConflictNewest: " ON CONFLICT (id) DO UPDATE SET " + upsertSet +
+		" WHERE COALESCE(GREATEST(EXCLUDED.created_at,EXCLUDED.deleted_at,EXCLUDED.merged_at),'-infinity')" +
+		" > COALESCE(GREATEST(user_details.created_at,user_details.deleted_at,user_details.merged_at),'-infinity')" +
+		" RETURNING id,(xmax = 0)",
+}
+
+const upsertSet = "first_name = EXCLUDED.first_name,last_name = EXCLUDED.last_name,email_address = EXCLUDED.email_address," +
+	"created_at = EXCLUDED.created_at,deleted_at = EXCLUDED.deleted_at,merged_at = EXCLUDED.merged_at,parent_user_id = EXCLUDED.parent_user_id"
+
+// CreateBulkUsers upserts multiple user records with multi-row INSERTs, resolving existing IDs
+// with the repository's conflict policy, and reports how many users were inserted, updated and
+// skipped. Batches over the parameter limit of a statement are split into chunks written in
+// one transaction.
+func (r *Repository) CreateBulkUsers(ctx context.Context, users []*models.UserDetails) (models.BulkResult, error) {
+	// a statement cannot update the same row twice, so repeated IDs are resolved up front
+	unique := dedupeUsers(users, r.conflictPolicy)
+	result := models.BulkResult{Skipped: int64(len(users) - len(unique))}
+	if len(unique) == 0 {
+		return result, nil
+	}
+
+	clause, ok := upsertClauses[r.conflictPolicy]
+	if !ok {
+		return result, fmt.Errorf("unsupported conflict policy %q", r.conflictPolicy)
+	}
+
+	if len(unique) <= maxInsertRows {
+		err := r.insertUsers(ctx, r.DB, unique, clause, &result)
+		return result, err
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(unique); start += maxInsertRows {
+		end := min(start+maxInsertRows, len(unique))
+		if err := r.insertUsers(ctx, tx, unique[start:end], clause, &result); err != nil {
+			return models.BulkResult{Skipped: int64(len(users) - len(unique))}, err
+		}
+	}
+	return result, tx.Commit()
+}
 
-	query := "INSERT INTO user_details (id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id) VALUES "
+// insertUsers upserts users with a single multi-row INSERT and adds the outcome to result
+func (r *Repository) insertUsers(ctx context.Context, q queryer, users []*models.UserDetails, clause string, result *models.BulkResult) error {
+	query := "INSERT INTO user_details (" + userColumns + ") VALUES "
 
-	var queryHolders = make([]string, 0, len(users)*DefaultFieldsCount)
+	var queryHolders = make([]string, 0, len(users))
 	var valueHolder = make([]interface{}, 0, len(users)*DefaultFieldsCount)
 
 	for i, user := range users {
@@ -51,22 +133,85 @@ func (r *Repository) CreateBulkUsers(ctx context.Context, users []*models.UserDe
 		valueHolder = append(valueHolder, user.ID, user.FirstName, user.LastName, user.EmailAddress, user.CreatedAt, user.DeletedAt, user.MergedAt, user.ParentUserId)
 	}
 
-	query += strings.Join(queryHolders, ",")
+	query += strings.Join(queryHolders, ",") + clause
 
-	// insert data in database.
-	_, err := r.DB.ExecContext(ctx, query, valueHolder...)
+	// upsert data in database.
+	rows, err := q.QueryContext(ctx, query, valueHolder...)
 	if err != nil {
 		log.Println(err)
-		// check for data already exists.
-		var e *pq.Error
-		if errors.As(err, &e) && e.Code == "23505" {
-			return ErrDuplicate
+		return bulkError(err)
+	}
+	return collectWritten(rows, users, result)
+}
+
+// bulkError marks duplicates with ErrDuplicate, keeping the Postgres error so its code can be reported
+func bulkError(err error) error {
+	var e *pq.Error
+	if errors.As(err, &e) && e.Code == "23505" {
+		return fmt.Errorf("%w: %w", ErrDuplicate, err)
+	}
+	return err
+}
+
+// collectWritten reads the rows returned by an upsert into result. Users that were not
+// returned were left alone by the conflict policy and are counted as skipped.
+func collectWritten(rows *sql.Rows, users []*models.UserDetails, result *models.BulkResult) error {
+	defer rows.Close()
+
+	byID := make(map[int64]*models.UserDetails, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	var written int
+	for rows.Next() {
+		var id int64
+		var inserted bool
+		if err := rows.Scan(&id, &inserted); err != nil {
+			return err
 		}
-		return err
+		written++
+		result.Written = append(result.Written, byID[id])
+		if inserted {
+			result.Inserted++
+		} else {
+			result.Updated++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return bulkError(err)
 	}
+
+	result.Skipped += int64(len(users) - written)
 	return nil
 }
 
+// dedupeUsers keeps one user per ID, chosen the way the policy resolves a conflict: the first
+// one for skip, the last one for overwrite and the newest one for newest. Order is preserved.
+func dedupeUsers(users []*models.UserDetails, policy models.ConflictPolicy) []*models.UserDetails {
+	index := make(map[int64]int, len(users))
+	unique := make([]*models.UserDetails, 0, len(users))
+
+	for _, user := range users {
+		i, seen := index[user.ID]
+		if !seen {
+			index[user.ID] = len(unique)
+			unique = append(unique, user)
+			continue
+		}
+
+		switch policy {
+		case models.ConflictOverwrite:
+			unique[i] = user
+		case models.ConflictNewest:
+			if user.Version().After(unique[i].Version()) {
+				unique[i] = user
+			}
+		}
+	}
+	return unique
+}
+
 // GetUserByID fetches a user by ID from the database.
 func (r *Repository) GetUserByID(ctx context.Context, id string) (*models.UserDetails, error) {
 	var userDetails models.UserDetails
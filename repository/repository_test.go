@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals_backend_task/pkg/models"
+	"github.com/viswals_backend_task/pkg/postgres"
+)
+
+// newMockRepository returns a repository whose queries must match the expected ones exactly
+func newMockRepository(t *testing.T, policy models.ConflictPolicy) (*Repository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, mock.ExpectationsWereMet())
+		db.Close()
+	})
+	return NewRepository(postgres.Postgres{DB: sqlx.NewDb(db, "postgres")}, WithConflictPolicy(policy)), mock
+}
+
+// insertQuery is the multi-row INSERT CreateBulkUsers sends for n users
+func insertQuery(n int, clause string) string {
+	holders := make([]string, n)
+	for i := range holders {
+		p := i * DefaultFieldsCount
+		holders[i] = fmt.Sprintf("($%d,$%d,$%d,$%d,$%d,$%d,$%d,$%d)", p+1, p+2, p+3, p+4, p+5, p+6, p+7, p+8)
+	}
+	return "INSERT INTO user_details (" + userColumns + ") VALUES " + strings.Join(holders, ",") + clause
+}
+
+func at(day int) sql.NullTime {
+	return sql.NullTime{Time: time.Date(2024, 1, day, 0, 0, 0, 0, time.UTC), Valid: true}
+}
+
+func user(id int64, name string, created, deleted, merged sql.NullTime) *models.UserDetails {
+	return &models.UserDetails{ID: id, FirstName: name, CreatedAt: created, DeletedAt: deleted, MergedAt: merged}
+}
+
+func TestUpsertClauses(t *testing.T) {
+	tests := []struct {
+		policy models.ConflictPolicy
+		clause string
+	}{
+		{models.ConflictSkip, " ON CONFLICT (id) DO NOTHING RETURNING id,(xmax = 0)"},
+		{models.ConflictOverwrite, " ON CONFLICT (id) DO UPDATE SET first_name = EXCLUDED.first_name,last_name = EXCLUDED.last_name," +
+			"email_address = EXCLUDED.email_address,created_at = EXCLUDED.created_at,deleted_at = EXCLUDED.deleted_at," +
+			"merged_at = EXCLUDED.merged_at,parent_user_id = EXCLUDED.parent_user_id RETURNING id,(xmax = 0)"},
+		{models.ConflictNewest, " ON CONFLICT (id) DO UPDATE SET first_name = EXCLUDED.first_name,last_name = EXCLUDED.last_name," +
+			"email_address = EXCLUDED.email_address,created_at = EXCLUDED.created_at,deleted_at = EXCLUDED.deleted_at," +
+			"merged_at = EXCLUDED.merged_at,parent_user_id = EXCLUDED.parent_user_id" +
+			" WHERE COALESCE(GREATEST(EXCLUDED.created_at,EXCLUDED.deleted_at,EXCLUDED.merged_at),'-infinity')" +
+			" > COALESCE(GREATEST(user_details.created_at,user_details.deleted_at,user_details.merged_at),'-infinity')" +
+			" RETURNING id,(xmax = 0)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			assert.Equal(t, tt.clause, upsertClauses[tt.policy])
+
+			repo, mock := newMockRepository(t, tt.policy)
+			mock.ExpectQuery(insertQuery(1, tt.clause)).WillReturnRows(sqlmock.NewRows([]string{"id", "inserted"}).AddRow(1, true))
+
+			result, err := repo.CreateBulkUsers(context.Background(), []*models.UserDetails{user(1, "a", at(1), sql.NullTime{}, sql.NullTime{})})
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), result.Inserted)
+		})
+	}
+}
+
+func TestCreateBulkUsersCountsWrittenRows(t *testing.T) {
+	repo, mock := newMockRepository(t, models.ConflictOverwrite)
+	users := []*models.UserDetails{
+		user(1, "inserted", at(1), sql.NullTime{}, sql.NullTime{}),
+		user(2, "updated", at(1), sql.NullTime{}, sql.NullTime{}),
+		user(3, "left alone", at(1), sql.NullTime{}, sql.NullTime{}),
+		user(2, "updated again", at(2), sql.NullTime{}, sql.NullTime{}),
+	}
+
+	// xmax = 0 marks rows the statement inserted; rows the policy left alone are not returned
+	mock.ExpectQuery(insertQuery(3, upsertClauses[models.ConflictOverwrite])).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "inserted"}).AddRow(1, true).AddRow(2, false))
+
+	result, err := repo.CreateBulkUsers(context.Background(), users)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Inserted)
+	assert.Equal(t, int64(1), result.Updated)
+	// the repeated ID and the row left alone
+	assert.Equal(t, int64(2), result.Skipped)
+	assert.Equal(t, []*models.UserDetails{users[0], users[3]}, result.Written)
+}
+
+func TestCreateBulkUsersSplitsLargeBatches(t *testing.T) {
+	repo, mock := newMockRepository(t, models.ConflictSkip)
+	users := make([]*models.UserDetails, maxInsertRows+1)
+	for i := range users {
+		users[i] = user(int64(i+1), "a", at(1), sql.NullTime{}, sql.NullTime{})
+	}
+
+	clause := upsertClauses[models.ConflictSkip]
+	mock.ExpectBegin()
+	mock.ExpectQuery(insertQuery(maxInsertRows, clause)).WillReturnRows(sqlmock.NewRows([]string{"id", "inserted"}).AddRow(1, true))
+	mock.ExpectQuery(insertQuery(1, clause)).WillReturnRows(sqlmock.NewRows([]string{"id", "inserted"}).AddRow(maxInsertRows+1, true))
+	mock.ExpectCommit()
+
+	result, err := repo.CreateBulkUsers(context.Background(), users)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result.Inserted)
+	assert.Equal(t, int64(maxInsertRows-1), result.Skipped)
+}
+
+func TestDedupeUsers(t *testing.T) {
+	first := user(1, "first", at(3), sql.NullTime{}, sql.NullTime{})
+	deleted := user(1, "deleted", at(1), at(5), sql.NullTime{})
+	merged := user(1, "merged", at(1), sql.NullTime{}, at(4))
+	other := user(2, "other", sql.NullTime{}, sql.NullTime{}, sql.NullTime{})
+	undated := user(2, "undated", sql.NullTime{}, sql.NullTime{}, sql.NullTime{})
+	users := []*models.UserDetails{first, other, deleted, merged, undated}
+
+	tests := []struct {
+		policy models.ConflictPolicy
+		want   []*models.UserDetails
+	}{
+		{models.ConflictSkip, []*models.UserDetails{first, other}},
+		{models.ConflictOverwrite, []*models.UserDetails{merged, undated}},
+		// the latest timestamp wins, and a tie keeps the earlier user
+		{models.ConflictNewest, []*models.UserDetails{deleted, other}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			assert.Equal(t, tt.want, dedupeUsers(users, tt.policy))
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package usecases
+
+import (
+	"encoding/json"
+
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+// batcher groups encoded users into jobs bounded by a user count and an encoded byte size
+type batcher struct {
+	size     int
+	maxBytes int
+	jobs     chan<- job
+
+	rows  []int64
+	users []json.RawMessage
+	bytes int
+}
+
+func newBatcher(size, maxBytes int, jobs chan<- job) *batcher {
+	return &batcher{size: size, maxBytes: maxBytes, jobs: jobs}
+}
+
+// add encodes a user and queues it, sending the pending batch first if the user would push it over maxBytes
+func (b *batcher) add(row int64, user *models.UserDetails) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	// a single-user message keeps the original object envelope
+	if b.size == 1 {
+		b.jobs <- job{rows: []int64{row}, body: data}
+		return nil
+	}
+
+	// array brackets plus one separator per user
+	if len(b.users) > 0 && b.bytes+len(data)+len(b.users)+2 > b.maxBytes {
+		b.flush()
+	}
+
+	b.rows = append(b.rows, row)
+	b.users = append(b.users, data)
+	b.bytes += len(data)
+
+	if len(b.users) >= b.size {
+		b.flush()
+	}
+	return nil
+}
+
+// flush sends the pending users as one batch envelope
+func (b *batcher) flush() {
+	if len(b.users) == 0 {
+		return
+	}
+
+	b.jobs <- job{rows: b.rows, body: encodeBatch(b.users)}
+	b.rows = nil
+	b.users = nil
+	b.bytes = 0
+}
@@ -0,0 +1,132 @@
+package usecases
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// BatchOptions controls how the consumer groups messages into database writes.
+// Zero fields take the value of DefaultBatchOptions.
+type BatchOptions struct {
+	// Size is the number of users after which a batch is written
+	Size int
+	// MaxBytes bounds the message bytes of a batch; a single larger message still makes a batch
+	MaxBytes int
+	// FlushInterval writes a partial batch once it has waited this long
+	FlushInterval time.Duration
+	// Workers is the number of batches written concurrently
+	Workers int
+	// ChannelSize is the number of batches buffered for the workers
+	ChannelSize int
+
+	// Adaptive grows Size up to MaxSize while writes take less than TargetLatency and halves it,
+	// down to MinSize, when they take longer
+	Adaptive      bool
+	TargetLatency time.Duration
+	MinSize       int
+	MaxSize       int
+}
+
+// DefaultBatchOptions writes batches of 10 users, flushed every second, with 15 workers
+func DefaultBatchOptions() BatchOptions {
+	return BatchOptions{
+		Size:          batchSize,
+		MaxBytes:      4 << 20,
+		FlushInterval: time.Second,
+		Workers:       consumerWorkerCount,
+		ChannelSize:   consumerWorkerCount,
+		TargetLatency: 500 * time.Millisecond,
+		MinSize:       1,
+		MaxSize:       10000,
+	}
+}
+
+// withDefaults fills the zero fields from DefaultBatchOptions
+func (o BatchOptions) withDefaults() BatchOptions {
+	def := DefaultBatchOptions()
+	if o.Size <= 0 {
+		o.Size = def.Size
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = def.MaxBytes
+	}
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = def.FlushInterval
+	}
+	if o.Workers <= 0 {
+		o.Workers = def.Workers
+	}
+	if o.ChannelSize <= 0 {
+		o.ChannelSize = def.ChannelSize
+	}
+	if o.TargetLatency <= 0 {
+		o.TargetLatency = def.TargetLatency
+	}
+	if o.MinSize <= 0 {
+		o.MinSize = def.MinSize
+	}
+	if o.MaxSize <= 0 {
+		o.MaxSize = max(def.MaxSize, o.Size)
+	}
+	o.MinSize = min(o.MinSize, o.Size)
+	o.MaxSize = max(o.MaxSize, o.Size)
+	return o
+}
+
+// WithBatchOptions sets how the consumer batches and writes messages
+func WithBatchOptions(opts BatchOptions) ConsumerOption {
+	return func(c *Consumer) {
+		c.batch = opts.withDefaults()
+		c.sizer = newBatchSizer(c.batch)
+	}
+}
+
+// batchSizer holds the current batch size. In adaptive mode it follows additive increase,
+// multiplicative decrease on the write latency: a full batch written under the target grows
+// the size by the configured Size, a slow write halves it.
+type batchSizer struct {
+	size     atomic.Int64
+	adaptive bool
+	step     int64
+	min, max int64
+	target   time.Duration
+}
+
+func newBatchSizer(opts BatchOptions) *batchSizer {
+	s := &batchSizer{
+		adaptive: opts.Adaptive,
+		step:     int64(opts.Size),
+		min:      int64(opts.MinSize),
+		max:      int64(opts.MaxSize),
+		target:   opts.TargetLatency,
+	}
+	s.size.Store(int64(opts.Size))
+	return s
+}
+
+// Size is the number of users after which a batch is written
+func (s *batchSizer) Size() int {
+	return int(s.size.Load())
+}
+
+// Observe adjusts the size after a batch of users was written in latency
+func (s *batchSizer) Observe(users int, latency time.Duration) {
+	if !s.adaptive {
+		return
+	}
+
+	for {
+		current := s.size.Load()
+		next := current
+		switch {
+		case latency > s.target:
+			next = max(current/2, s.min)
+		case int64(users) >= current:
+			// only batches that filled up show the size is what limits throughput
+			next = min(current+s.step, s.max)
+		}
+		if next == current || s.size.CompareAndSwap(current, next) {
+			return
+		}
+	}
+}
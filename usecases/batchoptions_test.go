@@ -0,0 +1,70 @@
+package usecases
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchOptions_Defaults(t *testing.T) {
+	opts := BatchOptions{Size: 50}.withDefaults()
+
+	assert.Equal(t, 50, opts.Size)
+	assert.Equal(t, DefaultBatchOptions().Workers, opts.Workers)
+	assert.Equal(t, time.Second, opts.FlushInterval)
+	assert.Equal(t, 1, opts.MinSize)
+	assert.GreaterOrEqual(t, opts.MaxSize, opts.Size)
+}
+
+type observation struct {
+	users   int
+	latency time.Duration
+}
+
+func TestBatchSizer(t *testing.T) {
+	opts := BatchOptions{Size: 10, MinSize: 5, MaxSize: 25, TargetLatency: 100 * time.Millisecond}.withDefaults()
+
+	tests := []struct {
+		name     string
+		adaptive bool
+		observe  []observation
+		want     int
+	}{
+		{
+			name:     "fixed size ignores latency",
+			adaptive: false,
+			observe:  []observation{{10, time.Millisecond}, {10, time.Second}},
+			want:     10,
+		},
+		{
+			name:     "fast full batches grow up to the maximum",
+			adaptive: true,
+			observe:  []observation{{10, time.Millisecond}, {20, time.Millisecond}, {30, time.Millisecond}},
+			want:     25,
+		},
+		{
+			name:     "partial batches do not grow",
+			adaptive: true,
+			observe:  []observation{{3, time.Millisecond}},
+			want:     10,
+		},
+		{
+			name:     "slow batches halve down to the minimum",
+			adaptive: true,
+			observe:  []observation{{10, time.Second}, {5, time.Second}},
+			want:     5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts.Adaptive = tt.adaptive
+			sizer := newBatchSizer(opts)
+			for _, o := range tt.observe {
+				sizer.Observe(o.users, o.latency)
+			}
+			assert.Equal(t, tt.want, sizer.Size())
+		})
+	}
+}
@@ -3,19 +3,26 @@ package usecases
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
-	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/viswals_backend_task/pkg/encryptions"
 	"github.com/viswals_backend_task/pkg/models"
+	"github.com/viswals_backend_task/pkg/postgres"
 	"go.uber.org/zap"
 )
 
 const (
 	defaultTimeout = 15 * time.Second
 	batchSize      = 10
-	// workerCount    = 3 // Reduce to avoid excessive goroutines
+	cacheAttempts  = 3
+	cacheBackoff   = 100 * time.Millisecond
+	// defaultCopyThreshold is the batch size from which users are written with COPY
+	defaultCopyThreshold = 1000
+	// consumerWorkerCount is the default number of batches written concurrently
+	consumerWorkerCount = 15
 )
 
 type Consumer struct {
@@ -23,45 +30,96 @@ type Consumer struct {
 	cacheStore    CacheStore
 	logger        *zap.Logger
 	repo          UserRepository
-	channel       <-chan amqp.Delivery
+	channel       <-chan models.Message
+	copyThreshold int
+	batch         BatchOptions
+	sizer         *batchSizer
+
+	mu      sync.Mutex
+	written models.BulkResult
+}
+
+// ConsumerOption defines functional options for the consumer
+type ConsumerOption func(*Consumer)
+
+// WithCopyThreshold writes batches of at least n users with COPY instead of INSERT; 0 disables COPY
+func WithCopyThreshold(n int) ConsumerOption {
+	return func(c *Consumer) {
+		if n >= 0 {
+			c.copyThreshold = n
+		}
+	}
+}
+
+// userBatch holds decoded users together with the deliveries they came from, so the deliveries
+// can be acknowledged once the users are stored
+type userBatch struct {
+	users      []*models.UserDetails
+	deliveries []models.Message
+	// sources holds the index in deliveries of the message each user came from
+	sources []int
+	// bytes is the size of the message bodies
+	bytes int
+}
+
+// bisection collects the outcome of storing a failed batch half by half
+type bisection struct {
+	result models.BulkResult
+	stored []*models.UserDetails
+	bad    []badRow
+}
+
+// badRow is a user the database refused on its own, by index in the batch
+type badRow struct {
+	index int
+	err   error
 }
 
 // NewConsumer initializes a new consumer instance	
-func NewConsumer(messageBroker MessageBroker, userRepo UserRepository, cacheStore CacheStore, logger *zap.Logger) (*Consumer, error) {
+func NewConsumer(messageBroker MessageBroker, userRepo UserRepository, cacheStore CacheStore, logger *zap.Logger, opts ...ConsumerOption) (*Consumer, error) {
 	in, err := messageBroker.Subscribe(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	return &Consumer{
+	c := &Consumer{
 		messageBroker: messageBroker,
 		channel:       in,
 		logger:        logger,
 		repo:          userRepo,
 		cacheStore:    cacheStore,
-	}, nil
+		copyThreshold: defaultCopyThreshold,
+		batch:         DefaultBatchOptions(),
+	}
+	c.sizer = newBatchSizer(c.batch)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
 }
 
 // Consume listens for incoming messages and processes them in batches
-func (c *Consumer) Consume(wg *sync.WaitGroup, size int) {
+func (c *Consumer) Consume(wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	userDetailsChan := make(chan []*models.UserDetails, workerCount)
-	errorChan := make(chan error, workerCount)
+	userDetailsChan := make(chan userBatch, c.batch.ChannelSize)
+	errorChan := make(chan error, c.batch.Workers)
 
 	var internalWg sync.WaitGroup
 
 	// Start worker goroutines
-	for i := 0; i < workerCount; i++ {
+	for i := 0; i < c.batch.Workers; i++ {
 		internalWg.Add(1)
 		go c.processBatch(&internalWg, userDetailsChan, errorChan)
 	}
 
-	internalWg.Add(1)
-	go c.logErrors(&internalWg, errorChan)
+	// the error logger drains errorChan until the workers are done, so it is tracked separately.
+	var logWg sync.WaitGroup
+	logWg.Add(1)
+	go c.logErrors(&logWg, errorChan)
 
-	var batch []*models.UserDetails
-	timeout := time.NewTimer(1 * time.Second)
+	var batch userBatch
+	timeout := time.NewTimer(c.batch.FlushInterval)
 
 
 	for {
@@ -69,32 +127,52 @@ func (c *Consumer) Consume(wg *sync.WaitGroup, size int) {
 		case data, ok := <-c.channel:
 			if !ok {
 				c.logger.Warn("RabbitMQ channel closed, stopping consumer...")
+				if len(batch.deliveries) > 0 {
+					userDetailsChan <- batch
+				}
 				close(userDetailsChan)
 				internalWg.Wait()
 				close(errorChan)
-				c.logger.Info("All data processed successfully.") // Final success message
+				logWg.Wait()
+				c.logger.Info("All data processed successfully.", zap.Any("written", c.Written())) // Final success message
 				return
 			}
 
-			var user models.UserDetails
-			if err := json.Unmarshal(data.Body, &user); err != nil {
+			users, err := decodeUsers(data.Body)
+			if err != nil {
 				c.logger.Error("Error unmarshalling user details", zap.Error(err))
+				// a malformed message fails on every redelivery, so it is set aside for inspection
+				if err := c.messageBroker.DeadLetter(context.Background(), data, newFailure(err)); err != nil {
+					c.logger.Error("Failed to dead-letter message", zap.Error(err))
+				}
 				continue
 			}
 
-			batch = append(batch, &user)
+			// a message that would push the batch over its byte limit starts the next batch
+			if len(batch.deliveries) > 0 && batch.bytes+len(data.Body) > c.batch.MaxBytes {
+				userDetailsChan <- batch
+				batch = userBatch{}
+			}
+
+			// users of one message stay in the same batch
+			for range users {
+				batch.sources = append(batch.sources, len(batch.deliveries))
+			}
+			batch.users = append(batch.users, users...)
+			batch.deliveries = append(batch.deliveries, data)
+			batch.bytes += len(data.Body)
 
-			if len(batch) >= batchSize {
+			if len(batch.users) >= c.sizer.Size() || batch.bytes >= c.batch.MaxBytes {
 				userDetailsChan <- batch
-				batch = nil // Reset batch
+				batch = userBatch{} // Reset batch
 			}
 
 		case <-timeout.C:
-			if len(batch) > 0 {
+			if len(batch.deliveries) > 0 {
 				userDetailsChan <- batch
-				batch = nil
+				batch = userBatch{}
 			}
-			timeout.Reset(1 * time.Second)
+			timeout.Reset(c.batch.FlushInterval)
 		}
 	}
 }
@@ -107,36 +185,245 @@ func (c *Consumer) logErrors(wg *sync.WaitGroup, errorChan chan error) {
 	}
 }
 
-// processBatch processes a batch of user details, encrypting emails and storing them
-func (c *Consumer) processBatch(wg *sync.WaitGroup, inputChan chan []*models.UserDetails, errorChan chan error) {
+// processBatch processes a batch of user details, encrypting emails and storing them. The
+// deliveries of a batch are acked only once the users are committed to the database.
+func (c *Consumer) processBatch(wg *sync.WaitGroup, inputChan chan userBatch, errorChan chan error) {
 	defer wg.Done()
 
 	for batch := range inputChan {
-		// Encrypt email addresses
-		for _, user := range batch {
-			encEmail, err := encryptions.Encrypt(user.EmailAddress)
-			if err != nil {
-				errorChan <- err
-				continue
+		// Encrypt email addresses; the decoded users are kept as received for dead-lettering
+		users := encryptUsers(batch.users, errorChan)
+
+		// Store batch in the database; its latency drives the adaptive batch size
+		start := time.Now()
+		result, err := c.store(users)
+		if err == nil {
+			c.sizer.Observe(len(users), time.Since(start))
+		} else if postgres.IsTransient(err) {
+			// a timeout means the database is struggling with the batch size
+			c.sizer.Observe(len(users), c.batch.TargetLatency+1)
+		}
+
+		if err == nil {
+			c.ack(batch.deliveries, errorChan)
+			c.record(result)
+			c.cache(writtenUsers(users, result), errorChan)
+			continue
+		}
+
+		errorChan <- err
+		// transient failures are retried after a delay
+		if postgres.IsTransient(err) {
+			c.retry(batch.deliveries, err, errorChan)
+			continue
+		}
+
+		// a data error is caused by some of the rows: the batch is split until they are isolated
+		var b bisection
+		if len(users) == 1 {
+			b.bad = append(b.bad, badRow{index: 0, err: err})
+		} else if err := c.bisect(users, 0, &b); err != nil {
+			errorChan <- err
+			// the halves already stored are upserted again on redelivery
+			c.retry(batch.deliveries, err, errorChan)
+			continue
+		}
+
+		c.settleBisection(batch, b, errorChan)
+		c.record(b.result)
+		c.cache(b.stored, errorChan)
+	}
+}
+
+// store writes users with a timeout, using COPY for large batches and INSERT for small ones
+func (c *Consumer) store(users []*models.UserDetails) (models.BulkResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	if c.copyThreshold > 0 && len(users) >= c.copyThreshold {
+		return c.repo.CopyBulkUsers(ctx, users)
+	}
+	return c.repo.CreateBulkUsers(ctx, users)
+}
+
+// encryptUsers returns copies of the users with encrypted email addresses
+func encryptUsers(users []*models.UserDetails, errorChan chan error) []*models.UserDetails {
+	encrypted := make([]*models.UserDetails, len(users))
+	for i, user := range users {
+		u := *user
+		encEmail, err := encryptions.Encrypt(u.EmailAddress)
+		if err != nil {
+			errorChan <- err
+		} else {
+			u.EmailAddress = encEmail
+		}
+		encrypted[i] = &u
+	}
+	return encrypted
+}
+
+// bisect stores both halves of users, splitting every half that fails with a data error again
+// until the single users the database refuses are isolated. offset is the index of users[0] in
+// the batch. A transient error stops the bisection and is returned.
+func (c *Consumer) bisect(users []*models.UserDetails, offset int, b *bisection) error {
+	mid := len(users) / 2
+	halves := []struct {
+		users  []*models.UserDetails
+		offset int
+	}{
+		{users[:mid], offset},
+		{users[mid:], offset + mid},
+	}
+
+	for _, half := range halves {
+		result, err := c.store(half.users)
+
+		switch {
+		case err == nil:
+			b.result.Add(result)
+			b.stored = append(b.stored, writtenUsers(half.users, result)...)
+		case postgres.IsTransient(err):
+			return err
+		case len(half.users) == 1:
+			b.bad = append(b.bad, badRow{index: half.offset, err: err})
+		default:
+			if err := c.bisect(half.users, half.offset, b); err != nil {
+				return err
 			}
-			user.EmailAddress = encEmail
 		}
+	}
+	return nil
+}
 
-		// Store batch in the database with a timeout
-		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-		err := c.repo.CreateBulkUsers(ctx, batch)
-		cancel()
+// settleBisection dead-letters each bad row on its own and acks the deliveries. A delivery
+// whose bad row could not be dead-lettered is retried instead, so the row is not lost.
+func (c *Consumer) settleBisection(batch userBatch, b bisection, errorChan chan error) {
+	failed := make(map[int]models.Failure)
+	for _, row := range b.bad {
+		source := batch.sources[row.index]
+		failure := newFailure(row.err)
+
+		body, err := json.Marshal(batch.users[row.index])
+		if err == nil {
+			msg := batch.deliveries[source]
+			msg.Body = body
+			if msg.ID != "" {
+				msg.ID = fmt.Sprintf("%s-%d", msg.ID, batch.users[row.index].ID)
+			}
 
+			ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+			err = c.messageBroker.PublishDeadLetter(ctx, msg, failure)
+			cancel()
+		}
 		if err != nil {
 			errorChan <- err
+			failed[source] = failure
+		}
+	}
+
+	for i, d := range batch.deliveries {
+		failure, ok := failed[i]
+		if !ok {
+			c.ack([]models.Message{d}, errorChan)
 			continue
 		}
 
-		// Cache the processed batch
-		if err := c.cacheStore.SetBulk(context.Background(), batch); err != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		if err := c.messageBroker.Retry(ctx, d, failure); err != nil {
 			errorChan <- err
 		}
+		cancel()
+	}
+}
+
+// cache stores written users, reporting a failure without redelivering the messages
+func (c *Consumer) cache(users []*models.UserDetails, errorChan chan error) {
+	// the users are already stored, so the cache is retried here instead
+	if err := c.cacheUsers(users); err != nil {
+		errorChan <- err
+	}
+}
+
+// record adds the counts of a stored batch to the consumer's totals
+func (c *Consumer) record(result models.BulkResult) {
+	c.logger.Debug("Batch stored",
+		zap.Int64("inserted", result.Inserted),
+		zap.Int64("updated", result.Updated),
+		zap.Int64("skipped", result.Skipped),
+	)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written.Add(result)
+}
+
+// Written reports how many users have been inserted, updated and skipped so far
+func (c *Consumer) Written() models.BulkResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.written
+}
+
+// writtenUsers returns the users the database actually stored, so skipped users are not cached
+// over the stored ones
+func writtenUsers(users []*models.UserDetails, result models.BulkResult) []*models.UserDetails {
+	if result.Skipped == 0 {
+		return users
+	}
+	return result.Written
+}
+
+// cacheUsers stores users in the cache, retrying a few times with backoff
+func (c *Consumer) cacheUsers(users []*models.UserDetails) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt < cacheAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(cacheBackoff << (attempt - 1))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		err = c.cacheStore.SetBulk(ctx, users)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("cache users after %d attempts: %w", cacheAttempts, err)
+}
+
+// ack acknowledges stored deliveries so the broker drops them
+func (c *Consumer) ack(deliveries []models.Message, errorChan chan error) {
+	for _, d := range deliveries {
+		if err := d.Ack(); err != nil {
+			errorChan <- err
+		}
+	}
+}
+
+// retry schedules deliveries that failed transiently for a delayed redelivery
+func (c *Consumer) retry(deliveries []models.Message, cause error, errorChan chan error) {
+	failure := newFailure(cause)
+	for _, d := range deliveries {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		if err := c.messageBroker.Retry(ctx, d, failure); err != nil {
+			errorChan <- err
+		}
+		cancel()
+	}
+}
+
+// newFailure describes err for the dead-letter headers, classified by its innermost error and
+// carrying its Postgres error code
+func newFailure(err error) models.Failure {
+	cause := err
+	for next := errors.Unwrap(cause); next != nil; next = errors.Unwrap(cause) {
+		cause = next
 	}
+	return models.Failure{Reason: err.Error(), Class: fmt.Sprintf("%T", cause), Code: postgres.ErrorCode(err)}
 }
 
 // Close shuts down the consumer gracefully
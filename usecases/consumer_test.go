@@ -1,23 +1,33 @@
 package usecases
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/viswals_backend_task/pkg/encryptions"
 	"github.com/viswals_backend_task/pkg/models"
 	"github.com/viswals_backend_task/pkg/rabbitmq/mockrabbitmq"
 	"github.com/viswals_backend_task/pkg/redis/mockredis"
+	"github.com/viswals_backend_task/repository"
 	"github.com/viswals_backend_task/repository/mockrepository"
 	"go.uber.org/zap"
 )
 
 // TestConsumer validates the consume workflow with different scenarios.
 func TestConsumer(t *testing.T) {
+	os.Setenv("ENCRYPTION_KEY", "a8z9WmX2pQJ5YcQ6dT7m9LqFkX4r7BsY")
+	defer os.Unsetenv("ENCRYPTION_KEY")
+	assert.NoError(t, encryptions.InitEncryptionKey())
+
 	mockUserRepo := new(mockrepository.MockRepository)
 	mockCacheStore := new(mockredis.MockRedis)
 	mockQueueStore := new(mockrabbitmq.MockRabbitMQ)
@@ -26,8 +36,10 @@ func TestConsumer(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Mock RabbitMQ subscription
-	deliveryChannel := make(chan amqp.Delivery, 10)
-	mockQueueStore.On("Subscribe", mock.Anything).Return((<-chan amqp.Delivery)(deliveryChannel), nil)
+	deliveryChannel := make(chan models.Message, 10)
+	mockQueueStore.On("Subscribe", mock.Anything).Return((<-chan models.Message)(deliveryChannel), nil)
+	mockUserRepo.On("CreateBulkUsers", mock.Anything, mock.Anything).Return(models.BulkResult{Inserted: 1}, nil)
+	mockCacheStore.On("SetBulk", mock.Anything, mock.Anything).Return(nil)
 
 	consumer, err := NewConsumer(mockQueueStore, mockUserRepo, mockCacheStore, logger)
 	assert.NoError(t, err)
@@ -36,7 +48,7 @@ func TestConsumer(t *testing.T) {
 	wg.Add(1)
 
 	// Start consumer
-	go consumer.Consume(wg, 1)
+	go consumer.Consume(wg)
 
 	// Send valid user data
 	testBody := []byte(`{
@@ -50,7 +62,7 @@ func TestConsumer(t *testing.T) {
 		"parent_user_id": 1
 	}`)
 
-	deliveryChannel <- amqp.Delivery{Body: testBody}
+	deliveryChannel <- models.Message{Body: testBody}
 
 	time.Sleep(2 * time.Second) // Allow processing time
 
@@ -63,6 +75,434 @@ func TestConsumer(t *testing.T) {
 	mockQueueStore.AssertExpectations(t)
 }
 
+// TestConsumer_BatchEnvelope validates that the users of a batch message are stored together.
+func TestConsumer_BatchEnvelope(t *testing.T) {
+	os.Setenv("ENCRYPTION_KEY", "a8z9WmX2pQJ5YcQ6dT7m9LqFkX4r7BsY")
+	defer os.Unsetenv("ENCRYPTION_KEY")
+	assert.NoError(t, encryptions.InitEncryptionKey())
+
+	mockUserRepo := new(mockrepository.MockRepository)
+	mockCacheStore := new(mockredis.MockRedis)
+	mockQueueStore := new(mockrabbitmq.MockRabbitMQ)
+
+	deliveryChannel := make(chan models.Message, 10)
+	mockQueueStore.On("Subscribe", mock.Anything).Return((<-chan models.Message)(deliveryChannel), nil)
+	mockUserRepo.On("CreateBulkUsers", mock.Anything, mock.MatchedBy(func(users []*models.UserDetails) bool {
+		return len(users) == 3
+	})).Return(models.BulkResult{Inserted: 3}, nil).Once()
+	mockCacheStore.On("SetBulk", mock.Anything, mock.Anything).Return(nil).Once()
+
+	consumer, err := NewConsumer(mockQueueStore, mockUserRepo, mockCacheStore, zap.NewNop())
+	assert.NoError(t, err)
+
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go consumer.Consume(wg)
+
+	deliveryChannel <- models.Message{Body: []byte(`[
+		{"id":1,"first_name":"John","last_name":"Doe","email_address":"john@doe.com","parent_user_id":0},
+		{"id":2,"first_name":"Jane","last_name":"Doe","email_address":"jane@doe.com","parent_user_id":1},
+		{"id":3,"first_name":"Jim","last_name":"Doe","email_address":"jim@doe.com","parent_user_id":1}
+	]`)}
+	close(deliveryChannel)
+	wg.Wait()
+
+	mockUserRepo.AssertExpectations(t)
+	mockCacheStore.AssertExpectations(t)
+}
+
+// fakeAcknowledger records how each message was settled.
+type fakeAcknowledger struct {
+	mu      sync.Mutex
+	acked   []uint64
+	nacked  []uint64
+	requeue []bool
+}
+
+// message returns a message settled through f under tag
+func (f *fakeAcknowledger) message(tag uint64, id string, body []byte) models.Message {
+	nack := func(requeue bool) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.nacked = append(f.nacked, tag)
+		f.requeue = append(f.requeue, requeue)
+		return nil
+	}
+	return models.Message{
+		ID:   id,
+		Body: body,
+		AckFunc: func() error {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.acked = append(f.acked, tag)
+			return nil
+		},
+		NackFunc:   nack,
+		RejectFunc: nack,
+	}
+}
+
+// TestConsumer_Acknowledgements validates that deliveries are acked only after the write commits,
+// that transient failures are retried and that messages which cannot succeed are dead-lettered.
+func TestConsumer_Acknowledgements(t *testing.T) {
+	os.Setenv("ENCRYPTION_KEY", "a8z9WmX2pQJ5YcQ6dT7m9LqFkX4r7BsY")
+	defer os.Unsetenv("ENCRYPTION_KEY")
+	assert.NoError(t, encryptions.InitEncryptionKey())
+
+	userBody := []byte(`{"id":1,"first_name":"John","last_name":"Doe","email_address":"john@doe.com","parent_user_id":0}`)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		repoErr   error
+		wantAcked []uint64
+		// wantRetry and wantDeadLetter are the exception class both deliveries are retried or dead-lettered with
+		wantRetry      string
+		wantDeadLetter string
+		// wantBadRows is the exception class each row is dead-lettered with on its own
+		wantBadRows string
+	}{
+		{
+			name:      "Committed batch is acked",
+			body:      userBody,
+			wantAcked: []uint64{1, 2},
+		},
+		{
+			name:      "Transient failure is retried",
+			body:      userBody,
+			repoErr:   context.DeadlineExceeded,
+			wantRetry: "context.deadlineExceededError",
+		},
+		{
+			name:        "Permanent failure dead-letters the bad rows",
+			body:        userBody,
+			repoErr:     repository.ErrDuplicate,
+			wantAcked:   []uint64{1, 2},
+			wantBadRows: "*errors.errorString",
+		},
+		{
+			name:           "Malformed message is dead-lettered",
+			body:           []byte(`{invalid json}`),
+			wantDeadLetter: "*json.SyntaxError",
+		},
+		{
+			name:           "Null user in a batch is dead-lettered",
+			body:           []byte(`[null]`),
+			wantDeadLetter: "*errors.errorString",
+		},
+		{
+			name:           "Null envelope is dead-lettered",
+			body:           []byte(`null`),
+			wantDeadLetter: "*errors.errorString",
+		},
+		{
+			name:           "Empty batch is dead-lettered",
+			body:           []byte(`[]`),
+			wantDeadLetter: "*errors.errorString",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserRepo := new(mockrepository.MockRepository)
+			mockCacheStore := new(mockredis.MockRedis)
+			mockQueueStore := new(mockrabbitmq.MockRabbitMQ)
+
+			deliveryChannel := make(chan models.Message, 10)
+			mockQueueStore.On("Subscribe", mock.Anything).Return((<-chan models.Message)(deliveryChannel), nil)
+			mockUserRepo.On("CreateBulkUsers", mock.Anything, mock.Anything).Return(models.BulkResult{Inserted: 2}, tt.repoErr).Maybe()
+			mockCacheStore.On("SetBulk", mock.Anything, mock.Anything).Return(nil).Maybe()
+			if tt.wantRetry != "" {
+				mockQueueStore.On("Retry", mock.Anything, mock.Anything, mock.MatchedBy(func(f models.Failure) bool {
+					return f.Class == tt.wantRetry
+				})).Return(nil).Twice()
+			}
+			if tt.wantBadRows != "" {
+				mockQueueStore.On("PublishDeadLetter", mock.Anything, mock.Anything, mock.MatchedBy(func(f models.Failure) bool {
+					return f.Class == tt.wantBadRows
+				})).Return(nil).Twice()
+			}
+			if tt.wantDeadLetter != "" {
+				mockQueueStore.On("DeadLetter", mock.Anything, mock.Anything, mock.MatchedBy(func(f models.Failure) bool {
+					return f.Class == tt.wantDeadLetter && f.Reason != ""
+				})).Return(nil).Twice()
+			}
+
+			consumer, err := NewConsumer(mockQueueStore, mockUserRepo, mockCacheStore, zap.NewNop())
+			assert.NoError(t, err)
+
+			wg := new(sync.WaitGroup)
+			wg.Add(1)
+			go consumer.Consume(wg)
+
+			ack := &fakeAcknowledger{}
+			deliveryChannel <- ack.message(1, "", tt.body)
+			deliveryChannel <- ack.message(2, "", tt.body)
+			close(deliveryChannel)
+			wg.Wait()
+
+			assert.Equal(t, tt.wantAcked, ack.acked)
+			assert.Empty(t, ack.nacked)
+			mockQueueStore.AssertExpectations(t)
+			if tt.repoErr == nil && tt.wantDeadLetter == "" {
+				mockCacheStore.AssertCalled(t, "SetBulk", mock.Anything, mock.Anything)
+			} else {
+				mockCacheStore.AssertNotCalled(t, "SetBulk", mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+// TestConsumer_CacheRetry validates that a failing cache write is retried without redelivering the messages.
+func TestConsumer_CacheRetry(t *testing.T) {
+	os.Setenv("ENCRYPTION_KEY", "a8z9WmX2pQJ5YcQ6dT7m9LqFkX4r7BsY")
+	defer os.Unsetenv("ENCRYPTION_KEY")
+	assert.NoError(t, encryptions.InitEncryptionKey())
+
+	mockUserRepo := new(mockrepository.MockRepository)
+	mockCacheStore := new(mockredis.MockRedis)
+	mockQueueStore := new(mockrabbitmq.MockRabbitMQ)
+
+	deliveryChannel := make(chan models.Message, 10)
+	mockQueueStore.On("Subscribe", mock.Anything).Return((<-chan models.Message)(deliveryChannel), nil)
+	mockUserRepo.On("CreateBulkUsers", mock.Anything, mock.Anything).Return(models.BulkResult{Inserted: 1}, nil).Once()
+	mockCacheStore.On("SetBulk", mock.Anything, mock.Anything).Return(errors.New("connection reset")).Once()
+	mockCacheStore.On("SetBulk", mock.Anything, mock.Anything).Return(nil).Once()
+
+	consumer, err := NewConsumer(mockQueueStore, mockUserRepo, mockCacheStore, zap.NewNop())
+	assert.NoError(t, err)
+
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go consumer.Consume(wg)
+
+	ack := &fakeAcknowledger{}
+	deliveryChannel <- ack.message(1, "", []byte(`{"id":1,"first_name":"John","last_name":"Doe","email_address":"john@doe.com","parent_user_id":0}`))
+	close(deliveryChannel)
+	wg.Wait()
+
+	assert.Equal(t, []uint64{1}, ack.acked)
+	assert.Empty(t, ack.nacked)
+	mockUserRepo.AssertExpectations(t)
+	mockCacheStore.AssertExpectations(t)
+}
+
+// TestConsumer_ConflictCounts validates that the write counts are totalled and that skipped users are not cached.
+func TestConsumer_ConflictCounts(t *testing.T) {
+	os.Setenv("ENCRYPTION_KEY", "a8z9WmX2pQJ5YcQ6dT7m9LqFkX4r7BsY")
+	defer os.Unsetenv("ENCRYPTION_KEY")
+	assert.NoError(t, encryptions.InitEncryptionKey())
+
+	mockUserRepo := new(mockrepository.MockRepository)
+	mockCacheStore := new(mockredis.MockRedis)
+	mockQueueStore := new(mockrabbitmq.MockRabbitMQ)
+
+	deliveryChannel := make(chan models.Message, 10)
+	mockQueueStore.On("Subscribe", mock.Anything).Return((<-chan models.Message)(deliveryChannel), nil)
+	// user 2 already exists and is skipped
+	mockUserRepo.On("CreateBulkUsers", mock.Anything, mock.Anything).Return(models.BulkResult{
+		Inserted: 1,
+		Updated:  1,
+		Skipped:  1,
+		Written:  []*models.UserDetails{{ID: 1}, {ID: 3}},
+	}, nil).Once()
+	mockCacheStore.On("SetBulk", mock.Anything, mock.MatchedBy(func(users []*models.UserDetails) bool {
+		return len(users) == 2 && users[0].ID == 1 && users[1].ID == 3
+	})).Return(nil).Once()
+
+	consumer, err := NewConsumer(mockQueueStore, mockUserRepo, mockCacheStore, zap.NewNop())
+	assert.NoError(t, err)
+
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go consumer.Consume(wg)
+
+	ack := &fakeAcknowledger{}
+	deliveryChannel <- ack.message(1, "", []byte(`[
+		{"id":1,"first_name":"John","last_name":"Doe","email_address":"john@doe.com","parent_user_id":0},
+		{"id":2,"first_name":"Jane","last_name":"Doe","email_address":"jane@doe.com","parent_user_id":1},
+		{"id":3,"first_name":"Jim","last_name":"Doe","email_address":"jim@doe.com","parent_user_id":1}
+	]`))
+	close(deliveryChannel)
+	wg.Wait()
+
+	assert.Equal(t, []uint64{1}, ack.acked)
+	written := consumer.Written()
+	assert.Equal(t, models.BulkResult{Inserted: 1, Updated: 1, Skipped: 1}, written)
+	mockCacheStore.AssertExpectations(t)
+}
+
+// TestConsumer_Bisection validates that a batch failing on one row stores the other rows and
+// dead-letters the bad one with its Postgres error code.
+func TestConsumer_Bisection(t *testing.T) {
+	os.Setenv("ENCRYPTION_KEY", "a8z9WmX2pQJ5YcQ6dT7m9LqFkX4r7BsY")
+	defer os.Unsetenv("ENCRYPTION_KEY")
+	assert.NoError(t, encryptions.InitEncryptionKey())
+
+	mockUserRepo := new(mockrepository.MockRepository)
+	mockCacheStore := new(mockredis.MockRedis)
+	mockQueueStore := new(mockrabbitmq.MockRabbitMQ)
+
+	hasBadRow := func(users []*models.UserDetails) bool {
+		for _, user := range users {
+			if user.ID == 3 {
+				return true
+			}
+		}
+		return false
+	}
+	tooLong := fmt.Errorf("insert users: %w", &pq.Error{Code: "22001", Message: "value too long for type character varying(255)"})
+
+	var stored []int64
+	deliveryChannel := make(chan models.Message, 10)
+	mockQueueStore.On("Subscribe", mock.Anything).Return((<-chan models.Message)(deliveryChannel), nil)
+	mockUserRepo.On("CreateBulkUsers", mock.Anything, mock.MatchedBy(hasBadRow)).Return(models.BulkResult{}, tooLong)
+	mockUserRepo.On("CreateBulkUsers", mock.Anything, mock.MatchedBy(func(users []*models.UserDetails) bool {
+		return !hasBadRow(users)
+	})).Return(models.BulkResult{Inserted: 2}, nil).Run(func(args mock.Arguments) {
+		for _, user := range args.Get(1).([]*models.UserDetails) {
+			stored = append(stored, user.ID)
+		}
+	})
+	mockCacheStore.On("SetBulk", mock.Anything, mock.Anything).Return(nil)
+	mockQueueStore.On("PublishDeadLetter", mock.Anything, mock.MatchedBy(func(d models.Message) bool {
+		return d.ID == "m2-3" && strings.Contains(string(d.Body), `"id":3`) && strings.Contains(string(d.Body), "jim@doe.com")
+	}), models.Failure{Reason: tooLong.Error(), Class: "*pq.Error", Code: "22001"}).Return(nil).Once()
+
+	consumer, err := NewConsumer(mockQueueStore, mockUserRepo, mockCacheStore, zap.NewNop())
+	assert.NoError(t, err)
+
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go consumer.Consume(wg)
+
+	ack := &fakeAcknowledger{}
+	deliveryChannel <- ack.message(1, "m1", []byte(`[
+		{"id":1,"first_name":"John","last_name":"Doe","email_address":"john@doe.com","parent_user_id":0},
+		{"id":2,"first_name":"Jane","last_name":"Doe","email_address":"jane@doe.com","parent_user_id":1}
+	]`))
+	deliveryChannel <- ack.message(2, "m2", []byte(`[
+		{"id":3,"first_name":"Jim","last_name":"Doe","email_address":"jim@doe.com","parent_user_id":1},
+		{"id":4,"first_name":"Joe","last_name":"Doe","email_address":"joe@doe.com","parent_user_id":1}
+	]`))
+	close(deliveryChannel)
+	wg.Wait()
+
+	assert.ElementsMatch(t, []int64{1, 2, 4}, stored)
+	assert.Equal(t, []uint64{1, 2}, ack.acked)
+	assert.Empty(t, ack.nacked)
+	mockQueueStore.AssertExpectations(t)
+}
+
+// TestConsumer_CopyThreshold validates that batches from the threshold on are written with COPY.
+func TestConsumer_CopyThreshold(t *testing.T) {
+	os.Setenv("ENCRYPTION_KEY", "a8z9WmX2pQJ5YcQ6dT7m9LqFkX4r7BsY")
+	defer os.Unsetenv("ENCRYPTION_KEY")
+	assert.NoError(t, encryptions.InitEncryptionKey())
+
+	mockUserRepo := new(mockrepository.MockRepository)
+	mockCacheStore := new(mockredis.MockRedis)
+	mockQueueStore := new(mockrabbitmq.MockRabbitMQ)
+
+	deliveryChannel := make(chan models.Message, 10)
+	mockQueueStore.On("Subscribe", mock.Anything).Return((<-chan models.Message)(deliveryChannel), nil)
+	mockUserRepo.On("CopyBulkUsers", mock.Anything, mock.MatchedBy(func(users []*models.UserDetails) bool {
+		return len(users) == 3
+	})).Return(models.BulkResult{Inserted: 3}, nil).Once()
+	mockCacheStore.On("SetBulk", mock.Anything, mock.Anything).Return(nil).Once()
+
+	consumer, err := NewConsumer(mockQueueStore, mockUserRepo, mockCacheStore, zap.NewNop(), WithCopyThreshold(3))
+	assert.NoError(t, err)
+
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go consumer.Consume(wg)
+
+	deliveryChannel <- models.Message{Body: []byte(`[
+		{"id":1,"first_name":"John","last_name":"Doe","email_address":"john@doe.com","parent_user_id":0},
+		{"id":2,"first_name":"Jane","last_name":"Doe","email_address":"jane@doe.com","parent_user_id":1},
+		{"id":3,"first_name":"Jim","last_name":"Doe","email_address":"jim@doe.com","parent_user_id":1}
+	]`)}
+	close(deliveryChannel)
+	wg.Wait()
+
+	mockUserRepo.AssertExpectations(t)
+	mockUserRepo.AssertNotCalled(t, "CreateBulkUsers", mock.Anything, mock.Anything)
+}
+
+func TestConsumer_BatchOptions(t *testing.T) {
+	os.Setenv("ENCRYPTION_KEY", "a8z9WmX2pQJ5YcQ6dT7m9LqFkX4r7BsY")
+	defer os.Unsetenv("ENCRYPTION_KEY")
+	assert.NoError(t, encryptions.InitEncryptionKey())
+
+	bodies := [][]byte{
+		[]byte(`{"id":1,"first_name":"John","last_name":"Doe","email_address":"john@doe.com","parent_user_id":0}`),
+		[]byte(`{"id":2,"first_name":"Jane","last_name":"Doe","email_address":"jane@doe.com","parent_user_id":1}`),
+	}
+
+	tests := []struct {
+		name      string
+		opts      BatchOptions
+		wantSizes []int
+	}{
+		{
+			name:      "both messages fit one batch",
+			opts:      BatchOptions{Size: 2, Workers: 1},
+			wantSizes: []int{2},
+		},
+		{
+			name:      "byte limit splits the batch",
+			opts:      BatchOptions{Size: 2, MaxBytes: len(bodies[0]) + 1, Workers: 1},
+			wantSizes: []int{1, 1},
+		},
+		{
+			name:      "flush interval writes a partial batch",
+			opts:      BatchOptions{Size: 100, FlushInterval: 10 * time.Millisecond, Workers: 1},
+			wantSizes: []int{1, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockUserRepo := new(mockrepository.MockRepository)
+			mockCacheStore := new(mockredis.MockRedis)
+			mockQueueStore := new(mockrabbitmq.MockRabbitMQ)
+
+			deliveryChannel := make(chan models.Message, len(bodies))
+			mockQueueStore.On("Subscribe", mock.Anything).Return((<-chan models.Message)(deliveryChannel), nil)
+
+			var (
+				mu    sync.Mutex
+				sizes []int
+			)
+			mockUserRepo.On("CreateBulkUsers", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				mu.Lock()
+				defer mu.Unlock()
+				sizes = append(sizes, len(args.Get(1).([]*models.UserDetails)))
+			}).Return(models.BulkResult{}, nil)
+			mockCacheStore.On("SetBulk", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+			consumer, err := NewConsumer(mockQueueStore, mockUserRepo, mockCacheStore, zap.NewNop(), WithBatchOptions(tt.opts))
+			assert.NoError(t, err)
+
+			wg := new(sync.WaitGroup)
+			wg.Add(1)
+			go consumer.Consume(wg)
+
+			for _, body := range bodies {
+				deliveryChannel <- models.Message{Body: body}
+				if tt.opts.FlushInterval > 0 {
+					time.Sleep(5 * tt.opts.FlushInterval)
+				}
+			}
+			close(deliveryChannel)
+			wg.Wait()
+
+			assert.Equal(t, tt.wantSizes, sizes)
+		})
+	}
+}
+
 // TestConvertToUserDetails validates JSON parsing.
 func TestConvertToUserDetails(t *testing.T) {
 	// logger, err := zap.NewDevelopment()
@@ -92,11 +532,45 @@ func TestConvertToUserDetails(t *testing.T) {
 			]`),
 			expectErr: false,
 		},
+		{
+			testName: "Single user object",
+			testInput: []byte(`{
+				"id":1,
+				"first_name":"John",
+				"last_name":"Doe",
+				"email_address":"john@doe.com",
+				"created_at":null,
+				"deleted_at":null,
+				"merged_at":null,
+				"parent_user_id":1
+			}`),
+			expectErr: false,
+		},
 		{
 			testName:  "Nil input",
 			testInput: nil,
 			expectErr: true,
 		},
+		{
+			testName:  "Null envelope",
+			testInput: []byte(`null`),
+			expectErr: true,
+		},
+		{
+			testName:  "Empty batch",
+			testInput: []byte(` [] `),
+			expectErr: true,
+		},
+		{
+			testName:  "Null user in batch",
+			testInput: []byte(`[null]`),
+			expectErr: true,
+		},
+		{
+			testName:  "Null user among users",
+			testInput: []byte(`[{"id":1,"first_name":"John"},null]`),
+			expectErr: true,
+		},
 		{
 			testName: "Invalid JSON",
 			testInput: []byte(`[
@@ -117,8 +591,7 @@ func TestConvertToUserDetails(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.testName, func(t *testing.T) {
-			var userDetails []*models.UserDetails
-			err := json.Unmarshal(tc.testInput, &userDetails)
+			userDetails, err := decodeUsers(tc.testInput)
 
 			if tc.expectErr {
 				assert.Error(t, err)
@@ -0,0 +1,65 @@
+package usecases
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/viswals_backend_task/pkg/models"
+)
+
+var (
+	// errEmptyEnvelope is returned for a message body that holds no users: no JSON value, a null or an empty array
+	errEmptyEnvelope = errors.New("empty message envelope")
+	// errNullUser is returned for a batch envelope holding a null element
+	errNullUser = errors.New("null user in message envelope")
+)
+
+// encodeBatch packs pre-serialized users into a batch envelope, a JSON array of user objects
+func encodeBatch(users []json.RawMessage) []byte {
+	size := 2
+	for _, u := range users {
+		size += len(u) + 1
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	buf.WriteByte('[')
+	for i, u := range users {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(u)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+// decodeUsers reads the users of a message body, accepting both a single-user object and a batch array
+func decodeUsers(body []byte) ([]*models.UserDetails, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		return nil, errEmptyEnvelope
+	}
+
+	if trimmed[0] == '[' {
+		var users []*models.UserDetails
+		if err := json.Unmarshal(trimmed, &users); err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			return nil, errEmptyEnvelope
+		}
+		for _, user := range users {
+			if user == nil {
+				return nil, errNullUser
+			}
+		}
+		return users, nil
+	}
+
+	var user models.UserDetails
+	if err := json.Unmarshal(trimmed, &user); err != nil {
+		return nil, err
+	}
+	return []*models.UserDetails{&user}, nil
+}
@@ -0,0 +1,384 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/viswals_backend_task/pkg/mapping"
+	"github.com/viswals_backend_task/pkg/models"
+	"github.com/viswals_backend_task/pkg/recordsource"
+	"github.com/viswals_backend_task/pkg/rejects"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMaxConcurrentImports = 2
+	// defaultImportRetention is how long a finished import can still be looked up
+	defaultImportRetention = 24 * time.Hour
+	// defaultMaxFinishedImports bounds how many finished imports are kept, whatever their age
+	defaultMaxFinishedImports = 1000
+)
+
+// ImportService ingests uploaded files through the producer pipeline, one background job per upload
+type ImportService struct {
+	broker       MessageBroker
+	logger       *zap.Logger
+	spoolDir     string
+	rejectsDir   string
+	aliases      mapping.Aliases
+	producerOpts []ProducerOption
+	slots        chan struct{}
+	retention    time.Duration
+	maxFinished  int
+
+	mu   sync.RWMutex
+	jobs map[string]*importJob
+}
+
+// importJob is the state of one import; producer and progress are only set while it runs
+type importJob struct {
+	mu       sync.Mutex
+	job      models.ImportJob
+	producer *Producer
+	progress ProgressReporter
+}
+
+// ImportOption defines functional options for the import service
+type ImportOption func(*ImportService)
+
+// WithImportSpoolDir sets where uploads are stored while they are being ingested
+func WithImportSpoolDir(dir string) ImportOption {
+	return func(s *ImportService) {
+		s.spoolDir = dir
+	}
+}
+
+// WithImportRejectsDir writes the rejected rows of each import to <dir>/<import id>.rejects.csv
+func WithImportRejectsDir(dir string) ImportOption {
+	return func(s *ImportService) {
+		s.rejectsDir = dir
+	}
+}
+
+// WithImportAliases sets the header aliases used to map uploaded columns
+func WithImportAliases(aliases mapping.Aliases) ImportOption {
+	return func(s *ImportService) {
+		s.aliases = aliases
+	}
+}
+
+// WithImportProducerOptions applies producer options, such as validation or batching, to every import
+func WithImportProducerOptions(opts ...ProducerOption) ImportOption {
+	return func(s *ImportService) {
+		s.producerOpts = append(s.producerOpts, opts...)
+	}
+}
+
+// WithMaxConcurrentImports limits how many imports run at once; further uploads wait as queued
+func WithMaxConcurrentImports(n int) ImportOption {
+	return func(s *ImportService) {
+		if n > 0 {
+			s.slots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithImportRetention sets how long a finished import is kept for status lookups
+func WithImportRetention(retention time.Duration) ImportOption {
+	return func(s *ImportService) {
+		if retention > 0 {
+			s.retention = retention
+		}
+	}
+}
+
+// WithMaxFinishedImports caps how many finished imports are kept; the oldest are dropped first
+func WithMaxFinishedImports(n int) ImportOption {
+	return func(s *ImportService) {
+		if n > 0 {
+			s.maxFinished = n
+		}
+	}
+}
+
+// NewImportService initializes a new import service publishing to the given broker
+func NewImportService(broker MessageBroker, logger *zap.Logger, opts ...ImportOption) *ImportService {
+	s := &ImportService{
+		broker:      broker,
+		logger:      logger,
+		spoolDir:    os.TempDir(),
+		aliases:     mapping.DefaultAliases(),
+		slots:       make(chan struct{}, defaultMaxConcurrentImports),
+		retention:   defaultImportRetention,
+		maxFinished: defaultMaxFinishedImports,
+		jobs:        make(map[string]*importJob),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Submit stores an upload and starts ingesting it in the background. Uploads whose format or
+// header cannot be ingested are rejected up front with models.ErrInvalidImport.
+func (s *ImportService) Submit(ctx context.Context, fileName string, r io.Reader) (*models.ImportJob, error) {
+	fileName = filepath.Base(fileName)
+	if _, err := recordsource.DetectFormat(fileName); err != nil {
+		return nil, fmt.Errorf("%w: %w", models.ErrInvalidImport, err)
+	}
+
+	id := uuid.NewString()
+	path, err := s.spool(ctx, id, fileName, r)
+	if err != nil {
+		return nil, err
+	}
+
+	source, columns, err := s.open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	j := &importJob{job: models.ImportJob{
+		ID:        id,
+		FileName:  fileName,
+		Status:    models.ImportQueued,
+		CreatedAt: time.Now(),
+	}}
+
+	s.mu.Lock()
+	s.evict(time.Now())
+	s.jobs[id] = j
+	s.mu.Unlock()
+
+	s.logger.Info("Import queued", zap.String("import_id", id), zap.String("file", fileName))
+	go s.run(j, path, source, columns)
+
+	job := j.snapshot()
+	return &job, nil
+}
+
+// Get reports the current state of an import
+func (s *ImportService) Get(_ context.Context, id string) (*models.ImportJob, error) {
+	s.mu.RLock()
+	j, ok := s.jobs[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, models.ErrImportNotFound
+	}
+
+	job := j.snapshot()
+	return &job, nil
+}
+
+// evict drops finished imports past their retention, then the oldest finished imports over the
+// cap; running and queued imports are always kept. The caller holds s.mu.
+func (s *ImportService) evict(now time.Time) {
+	type finishedJob struct {
+		id         string
+		finishedAt time.Time
+	}
+
+	var finished []finishedJob
+	for id, j := range s.jobs {
+		finishedAt, ok := j.finishedAt()
+		if !ok {
+			continue
+		}
+		if now.Sub(finishedAt) > s.retention {
+			delete(s.jobs, id)
+			continue
+		}
+		finished = append(finished, finishedJob{id: id, finishedAt: finishedAt})
+	}
+
+	if len(finished) <= s.maxFinished {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].finishedAt.Before(finished[b].finishedAt) })
+	for _, f := range finished[:len(finished)-s.maxFinished] {
+		delete(s.jobs, f.id)
+	}
+}
+
+// spool copies the upload to a file the import owns until it finishes
+func (s *ImportService) spool(ctx context.Context, id, fileName string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(s.spoolDir, 0o755); err != nil {
+		return "", err
+	}
+
+	// the original name is kept so the format and compression can still be detected
+	path := filepath.Join(s.spoolDir, id+"-"+fileName)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(f, readerWithContext(ctx, r)); err != nil {
+		f.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// open reads the header of a spooled upload and maps its columns
+func (s *ImportService) open(path string) (*recordsource.File, *mapping.Mapping, error) {
+	source, err := recordsource.Open(path, recordsource.Options{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", models.ErrInvalidImport, err)
+	}
+
+	columns, err := mapping.Resolve(source.Header(), s.aliases)
+	if err != nil {
+		source.Close()
+		return nil, nil, fmt.Errorf("%w: %w", models.ErrInvalidImport, err)
+	}
+	return source, columns, nil
+}
+
+// run ingests a spooled upload once a slot is free, then removes it
+func (s *ImportService) run(j *importJob, path string, source *recordsource.File, columns *mapping.Mapping) {
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+
+	logger := s.logger.With(zap.String("import_id", j.job.ID))
+	producer, err := s.ingest(j, source, columns, logger)
+
+	// the upload is cleaned up before the import is reported as finished
+	err = errors.Join(err, source.Close())
+	// users that could not be published are lost from the import, so it did not complete
+	if err == nil && producer.Summary().Failed > 0 {
+		err = fmt.Errorf("%d users could not be published", producer.Summary().Failed)
+	}
+	if rmErr := os.Remove(path); rmErr != nil {
+		logger.Warn("Failed to remove spooled upload", zap.Error(rmErr), zap.String("path", path))
+	}
+
+	j.finish(err)
+	if err != nil {
+		logger.Error("Import failed", zap.Error(err))
+		return
+	}
+	logger.Info("Import completed", zap.Any("summary", producer.Summary()))
+}
+
+// ingest publishes every row of the source, writing rejects to the import's own file
+func (s *ImportService) ingest(j *importJob, source *recordsource.File, columns *mapping.Mapping, logger *zap.Logger) (*Producer, error) {
+	opts := append([]ProducerOption{}, s.producerOpts...)
+	opts = append(opts, WithColumnMapping(columns))
+
+	var rejectsFile string
+	if s.rejectsDir != "" {
+		rejectsFile = filepath.Join(s.rejectsDir, j.job.ID+".rejects.csv")
+		w, err := rejects.Open(rejectsFile, source.Header(), true)
+		if err != nil {
+			return nil, fmt.Errorf("open rejects file: %w", err)
+		}
+		defer w.Close()
+		opts = append(opts, WithRejects(w))
+	}
+
+	// the broker is shared by every import, so the producer is not closed here
+	producer := NewProducer(source, s.broker, logger, opts...)
+	j.start(producer, source, rejectsFile)
+	logger.Info("Import started")
+
+	return producer, producer.Start(context.Background())
+}
+
+// start marks the import as running with the producer that reports its counts
+func (j *importJob) start(producer *Producer, progress ProgressReporter, rejectsFile string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := time.Now()
+	j.producer = producer
+	j.progress = progress
+	j.job.Status = models.ImportRunning
+	j.job.StartedAt = &now
+	j.job.RejectsFile = rejectsFile
+}
+
+// finish records the final counts of an import and releases the producer
+func (j *importJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.fill()
+	now := time.Now()
+	j.job.FinishedAt = &now
+	j.job.Status = models.ImportCompleted
+	if err != nil {
+		j.job.Status = models.ImportFailed
+		j.job.Error = err.Error()
+	}
+	j.producer = nil
+	j.progress = nil
+}
+
+// finishedAt reports when the import finished, if it has
+func (j *importJob) finishedAt() (time.Time, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.job.FinishedAt == nil {
+		return time.Time{}, false
+	}
+	return *j.job.FinishedAt, true
+}
+
+// snapshot returns a copy of the job with live counts while it is running
+func (j *importJob) snapshot() models.ImportJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.fill()
+	job := j.job
+	job.Rejects = copyCounts(j.job.Rejects)
+	return job
+}
+
+// fill copies the producer's counts into the job; the caller holds j.mu
+func (j *importJob) fill() {
+	if j.progress != nil {
+		j.job.BytesRead, j.job.BytesTotal = j.progress.Progress()
+	}
+	if j.producer == nil {
+		return
+	}
+
+	summary := j.producer.Summary()
+	j.job.RowsRead = summary.RowsRead
+	j.job.Valid = summary.Valid
+	j.job.Published = summary.Published
+	j.job.Failed = summary.Failed
+	j.job.Rejected = summary.Rejected
+	j.job.Rejects = summary.RejectReasons
+}
+
+// readerWithContext stops a copy once ctx is done, e.g. when the client disconnects mid-upload
+func readerWithContext(ctx context.Context, r io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		return r.Read(p)
+	})
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
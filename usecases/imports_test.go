@@ -0,0 +1,142 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals_backend_task/pkg/models"
+	"github.com/viswals_backend_task/pkg/rabbitmq/mockrabbitmq"
+	"go.uber.org/zap"
+)
+
+// TestImportService_Submit tests that an upload is ingested in the background and its counts reported.
+func TestImportService_Submit(t *testing.T) {
+	csvData := `email,id,first_name,last_name,created_at
+john@example.com,1,John,Doe,1622548800000
+jane@example.com,two,Jane,Doe,1622548800000
+jim@example.com,3,Jim,Doe,1622548800000
+`
+	spoolDir := t.TempDir()
+	rejectsDir := t.TempDir()
+
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	mockBroker.On("Publish", mock.Anything, mock.Anything).Return(nil).Once()
+
+	service := NewImportService(mockBroker, zap.NewNop(),
+		WithImportSpoolDir(spoolDir),
+		WithImportRejectsDir(rejectsDir),
+		WithImportProducerOptions(WithBatchSize(10)),
+	)
+
+	job, err := service.Submit(context.Background(), "../users.csv", strings.NewReader(csvData))
+	require.NoError(t, err)
+	require.Equal(t, "users.csv", job.FileName)
+	require.NotEmpty(t, job.ID)
+
+	require.Eventually(t, func() bool {
+		job, err = service.Get(context.Background(), job.ID)
+		require.NoError(t, err)
+		return job.Status == models.ImportCompleted
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, int64(3), job.RowsRead)
+	require.Equal(t, int64(2), job.Valid)
+	require.Equal(t, int64(2), job.Published)
+	require.Equal(t, int64(1), job.Rejected)
+	require.Equal(t, int64(1), job.Rejects["bad_integer"])
+	require.Equal(t, job.BytesTotal, job.BytesRead)
+	mockBroker.AssertExpectations(t)
+
+	rejected, err := os.ReadFile(filepath.Join(rejectsDir, job.ID+".rejects.csv"))
+	require.NoError(t, err)
+	require.Contains(t, string(rejected), "jane@example.com")
+
+	// the spooled upload is removed once the import finishes
+	spooled, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	require.Empty(t, spooled)
+}
+
+// TestImportService_PublishFailed tests that an import with users that could not be published is reported as failed.
+func TestImportService_PublishFailed(t *testing.T) {
+	csvData := `email,id,first_name,last_name,created_at
+john@example.com,1,John,Doe,1622548800000
+jane@example.com,2,Jane,Doe,1622548800000
+`
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	mockBroker.On("Publish", mock.Anything, mock.Anything).Return(errors.New("channel closed"))
+
+	service := NewImportService(mockBroker, zap.NewNop(),
+		WithImportSpoolDir(t.TempDir()),
+		WithImportProducerOptions(WithBatchSize(10)),
+	)
+
+	job, err := service.Submit(context.Background(), "users.csv", strings.NewReader(csvData))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		job, err = service.Get(context.Background(), job.ID)
+		require.NoError(t, err)
+		return job.FinishedAt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, models.ImportFailed, job.Status)
+	require.Equal(t, int64(2), job.Failed)
+	require.Equal(t, int64(0), job.Published)
+	require.Contains(t, job.Error, "2 users could not be published")
+}
+
+// TestImportService_Evict tests that finished imports are dropped past their retention or over the cap.
+func TestImportService_Evict(t *testing.T) {
+	service := NewImportService(new(mockrabbitmq.MockRabbitMQ), zap.NewNop(),
+		WithImportRetention(time.Hour),
+		WithMaxFinishedImports(2),
+	)
+
+	now := time.Now()
+	finished := func(age time.Duration) *importJob {
+		finishedAt := now.Add(-age)
+		return &importJob{job: models.ImportJob{Status: models.ImportCompleted, FinishedAt: &finishedAt}}
+	}
+	service.jobs = map[string]*importJob{
+		"expired": finished(2 * time.Hour),
+		"oldest":  finished(30 * time.Minute),
+		"older":   finished(20 * time.Minute),
+		"newest":  finished(10 * time.Minute),
+		"running": {job: models.ImportJob{Status: models.ImportRunning}},
+	}
+
+	service.evict(now)
+
+	require.Len(t, service.jobs, 3)
+	require.Contains(t, service.jobs, "older")
+	require.Contains(t, service.jobs, "newest")
+	require.Contains(t, service.jobs, "running")
+}
+
+// TestImportService_SubmitInvalid tests that uploads that cannot be ingested are rejected up front.
+func TestImportService_SubmitInvalid(t *testing.T) {
+	spoolDir := t.TempDir()
+	service := NewImportService(new(mockrabbitmq.MockRabbitMQ), zap.NewNop(), WithImportSpoolDir(spoolDir))
+
+	_, err := service.Submit(context.Background(), "users.pdf", strings.NewReader("%PDF"))
+	require.ErrorIs(t, err, models.ErrInvalidImport)
+
+	_, err = service.Submit(context.Background(), "users.csv", strings.NewReader("id,first_name\n1,John\n"))
+	require.ErrorIs(t, err, models.ErrInvalidImport)
+	require.ErrorContains(t, err, "missing required columns")
+
+	spooled, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	require.Empty(t, spooled)
+
+	_, err = service.Get(context.Background(), "unknown")
+	require.ErrorIs(t, err, models.ErrImportNotFound)
+}
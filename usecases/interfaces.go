@@ -3,18 +3,45 @@ package usecases
 import (
 	"context"
 
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/viswals_backend_task/pkg/checkpoint"
 	"github.com/viswals_backend_task/pkg/models"
+	"github.com/viswals_backend_task/pkg/rejects"
+	"github.com/viswals_backend_task/pkg/validation"
 )
 
+// MessageBroker publishes and delivers messages; the consumer settles every delivered message
 type MessageBroker interface {
 	Publish(ctx context.Context, message []byte) error
-	Subscribe(ctx context.Context) (<-chan amqp.Delivery, error)
+	Subscribe(ctx context.Context) (<-chan models.Message, error)
+	Retry(ctx context.Context, msg models.Message, failure models.Failure) error
+	DeadLetter(ctx context.Context, msg models.Message, failure models.Failure) error
+	PublishDeadLetter(ctx context.Context, msg models.Message, failure models.Failure) error
 	Close() error
 }
 
+type RecordSource interface {
+	Header() []string
+	Next() ([]string, error)
+	Line() int64
+	Close() error
+}
+
+type RejectWriter interface {
+	Write(reject rejects.Reject) error
+	Close() error
+}
+
+type ProgressReporter interface {
+	Progress() (read int64, total int64)
+}
+
+type RowValidator interface {
+	Validate(user *models.UserDetails) []validation.Violation
+}
+
 type UserRepository interface {
-	CreateBulkUsers(ctx context.Context, users []*models.UserDetails) error
+	CreateBulkUsers(ctx context.Context, users []*models.UserDetails) (models.BulkResult, error)
+	CopyBulkUsers(ctx context.Context, users []*models.UserDetails) (models.BulkResult, error)
 	CreateUser(ctx context.Context, user *models.UserDetails) error
 	GetUserByID(ctx context.Context, id string) (*models.UserDetails, error)
 	GetAllUsers(ctx context.Context) ([]*models.UserDetails, error)
@@ -28,3 +55,8 @@ type CacheStore interface {
 	SetBulk(ctx context.Context, userDetails []*models.UserDetails) error 
 	Delete(ctx context.Context, key string) error
 }
+
+type CheckpointStore interface {
+	Load(filePath, fingerprint string) (checkpoint.Checkpoint, bool, error)
+	Save(cp checkpoint.Checkpoint) error
+}
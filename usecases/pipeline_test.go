@@ -0,0 +1,80 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/viswals_backend_task/pkg/encryptions"
+	"github.com/viswals_backend_task/pkg/membroker"
+	"github.com/viswals_backend_task/pkg/models"
+	"github.com/viswals_backend_task/pkg/recordsource"
+	"github.com/viswals_backend_task/pkg/redis/mockredis"
+	"github.com/viswals_backend_task/repository/mockrepository"
+	"go.uber.org/zap"
+)
+
+// TestPipeline_MemoryBroker runs the producer and the consumer in one process over the in-process broker.
+func TestPipeline_MemoryBroker(t *testing.T) {
+	os.Setenv("ENCRYPTION_KEY", "a8z9WmX2pQJ5YcQ6dT7m9LqFkX4r7BsY")
+	defer os.Unsetenv("ENCRYPTION_KEY")
+	require.NoError(t, encryptions.InitEncryptionKey())
+
+	broker, err := membroker.New("users")
+	require.NoError(t, err)
+
+	mockUserRepo := new(mockrepository.MockRepository)
+	mockCacheStore := new(mockredis.MockRedis)
+
+	var (
+		mu     sync.Mutex
+		stored []int64
+	)
+	allStored := make(chan struct{})
+	mockUserRepo.On("CreateBulkUsers", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, user := range args.Get(1).([]*models.UserDetails) {
+			stored = append(stored, user.ID)
+		}
+		if len(stored) == 3 {
+			close(allStored)
+		}
+	}).Return(models.BulkResult{}, nil)
+	mockCacheStore.On("SetBulk", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	consumer, err := NewConsumer(broker, mockUserRepo, mockCacheStore, zap.NewNop(),
+		WithBatchOptions(BatchOptions{Size: 3, FlushInterval: 20 * time.Millisecond}))
+	require.NoError(t, err)
+
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go consumer.Consume(wg)
+
+	csvData := `1,John,Doe,john@example.com,1622548800000,-1,-1,0
+2,Jane,Doe,jane@example.com,1622548800000,-1,-1,1
+3,Jim,Doe,jim@example.com,1622548800000,-1,-1,1`
+	source := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
+	require.NoError(t, NewProducer(source, broker, zap.NewNop()).Start(context.Background()))
+
+	select {
+	case <-allStored:
+	case <-time.After(10 * time.Second):
+		t.Fatal("users were not stored")
+	}
+
+	// the messages are acked once their batch is stored
+	assert.Eventually(t, func() bool { return broker.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, broker.Close())
+	wg.Wait()
+
+	assert.ElementsMatch(t, []int64{1, 2, 3}, stored)
+}
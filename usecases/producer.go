@@ -2,153 +2,461 @@ package usecases
 
 import (
 	"context"
-	"database/sql"
-	"encoding/csv"
-	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/viswals_backend_task/pkg/csvutils"
+	"github.com/viswals_backend_task/pkg/checkpoint"
+	"github.com/viswals_backend_task/pkg/mapping"
 	"github.com/viswals_backend_task/pkg/models"
+	"github.com/viswals_backend_task/pkg/recordsource"
+	"github.com/viswals_backend_task/pkg/rejects"
+	"github.com/viswals_backend_task/pkg/timeparse"
 	"go.uber.org/zap"
 )
 
 const (
-	publishTimeout = 15 * time.Second
-	workerCount    = 15 // Number of concurrent workers
+	publishTimeout  = 15 * time.Second
+	producerWorkers = 15 // Number of concurrent workers
+	monitorInterval = 5 * time.Second
+
+	defaultBatchSize     = 1
+	defaultMaxBatchBytes = 4 << 20 // well below the RabbitMQ frame and message size limits
 )
 
+// ErrInterrupted is returned by Start when its context is cancelled before the input is exhausted.
+// Rows that were read have been published or recorded as failed, and the checkpoint is saved.
+var ErrInterrupted = errors.New("producer interrupted")
+
 type Producer struct {
-	csvReader *csv.Reader
-	broker    MessageBroker
-	logger    *zap.Logger
+	source     RecordSource
+	broker     MessageBroker
+	logger     *zap.Logger
+	columns    *mapping.Mapping
+	rejects    RejectWriter
+	validator  RowValidator
+	timestamps *timeparse.Columns
+	summary    summaryCounter
+	dryRun     bool
+	stats      *statsCollector
+
+	batchSize      int
+	maxBatchBytes  int
+	publishTimeout time.Duration
+
+	checkpoints CheckpointStore
+	filePath    string
+	fingerprint string
+	forceRerun  bool
+	tracker     *checkpoint.Tracker
+}
+
+// ProducerOption defines functional options for the producer
+type ProducerOption func(*Producer)
+
+// WithCheckpoint enables resumable ingestion, keyed by the input file path and its content fingerprint
+func WithCheckpoint(store CheckpointStore, filePath, fingerprint string) ProducerOption {
+	return func(p *Producer) {
+		p.checkpoints = store
+		p.filePath = filePath
+		p.fingerprint = fingerprint
+	}
+}
+
+// WithColumnMapping reads user fields by the given header mapping instead of the fixed positional layout
+func WithColumnMapping(columns *mapping.Mapping) ProducerOption {
+	return func(p *Producer) {
+		p.columns = columns
+	}
+}
+
+// WithRejects records every row that cannot be published, with its line number and reason
+func WithRejects(w RejectWriter) ProducerOption {
+	return func(p *Producer) {
+		p.rejects = w
+	}
+}
+
+// WithTimestampFormats parses each timestamp column with its configured format and null values
+func WithTimestampFormats(columns *timeparse.Columns) ProducerOption {
+	return func(p *Producer) {
+		if columns != nil {
+			p.timestamps = columns
+		}
+	}
+}
+
+// WithValidator applies business validation rules to every parsed row before it is published
+func WithValidator(v RowValidator) ProducerOption {
+	return func(p *Producer) {
+		p.validator = v
+	}
+}
+
+// WithDryRun runs the full parse and validation pipeline without publishing or checkpointing,
+// collecting the statistics returned by Report
+func WithDryRun(dryRun bool) ProducerOption {
+	return func(p *Producer) {
+		p.dryRun = dryRun
+	}
+}
+
+// WithForceRerun ignores any stored checkpoint and reads the input from the first row
+func WithForceRerun(force bool) ProducerOption {
+	return func(p *Producer) {
+		p.forceRerun = force
+	}
+}
+
+// WithBatchSize packs up to size users into a single message; a size of 1 publishes one user per message
+func WithBatchSize(size int) ProducerOption {
+	return func(p *Producer) {
+		if size > 0 {
+			p.batchSize = size
+		}
+	}
+}
+
+// WithMaxBatchBytes caps the encoded size of a batch message; a single user larger than the cap is sent on its own
+func WithMaxBatchBytes(size int) ProducerOption {
+	return func(p *Producer) {
+		if size > 0 {
+			p.maxBatchBytes = size
+		}
+	}
+}
+
+// WithPublishTimeout bounds each publish, including the broker's confirm wait and retries
+func WithPublishTimeout(timeout time.Duration) ProducerOption {
+	return func(p *Producer) {
+		if timeout > 0 {
+			p.publishTimeout = timeout
+		}
+	}
 }
 
 // Initializes a new Producer instance
-func NewProducer(csvReader *csv.Reader, broker MessageBroker, logger *zap.Logger) *Producer {
-	return &Producer{
-		csvReader: csvReader,
-		broker:    broker,
-		logger:    logger,
+func NewProducer(source RecordSource, broker MessageBroker, logger *zap.Logger, opts ...ProducerOption) *Producer {
+	p := &Producer{
+		source:         source,
+		broker:         broker,
+		logger:         logger,
+		columns:        mapping.Positional(),
+		timestamps:     timeparse.DefaultColumns(),
+		batchSize:      defaultBatchSize,
+		maxBatchBytes:  defaultMaxBatchBytes,
+		publishTimeout: publishTimeout,
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	if p.dryRun {
+		p.stats = newStatsCollector(p.timestamps)
+	}
+	return p
+}
+
+// job is an encoded message together with the input rows of the users it carries
+type job struct {
+	rows []int64
+	body []byte
 }
 
-// Starts the producer, reading CSV data and sending messages to the queue
-func (p *Producer) Start() error {
+// Starts the producer, reading input records and sending messages to the queue.
+// Cancelling ctx stops reading; batches already read are still published before Start returns.
+func (p *Producer) Start(ctx context.Context) error {
 	p.logger.Info("Starting producer")
 
-	jobs := make(chan *models.UserDetails, workerCount*2)
+	row, err := p.resume(ctx)
+	if err != nil {
+		return err
+	}
+	if err := p.trimRejects(row); err != nil {
+		return fmt.Errorf("trim rejects file: %w", err)
+	}
+	p.tracker = checkpoint.NewTracker(row)
+	p.summary.update(func(s *RunSummary) { s.RowsSkipped = row })
+
+	jobs := make(chan job, producerWorkers*2)
 	var wg sync.WaitGroup
 
 	// Start worker pool
-	for i := 0; i < workerCount; i++ {
+	for i := 0; i < producerWorkers; i++ {
 		wg.Add(1)
 		go p.worker(jobs, &wg)
 	}
 
-	// Read CSV and send jobs to workers
+	// Periodically persist the checkpoint and report progress while rows are being published
+	stopMonitor := make(chan struct{})
+	monitorDone := make(chan struct{})
+	go p.monitor(stopMonitor, monitorDone)
+
+	// Read records and send batches of encoded users to workers
+	batch := newBatcher(p.batchSize, p.maxBatchBytes, jobs)
+	var readErr error
 	for {
-		rows, invalidRows, err := p.readCSV()
+		if err := ctx.Err(); err != nil {
+			p.logger.Warn("Stopping producer, draining in-flight messages", zap.Error(err), zap.Int64("row", row))
+			readErr = fmt.Errorf("%w: %w", ErrInterrupted, err)
+			break
+		}
+
+		record, err := p.source.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, recordsource.ErrMalformedRecord) {
+			readErr = err
+			break
+		}
+
+		row++
+		p.summary.update(func(s *RunSummary) { s.RowsRead++ })
 		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			p.logger.Error("Error reading CSV", zap.Error(err))
+			p.tracker.Done(row)
+			p.reject(record, sourceError(err))
 			continue
 		}
 
-		if len(invalidRows) > 0 {
-			p.logger.Warn("Invalid rows encountered", zap.Any("data", invalidRows))
+		if p.stats != nil {
+			p.stats.observeRecord(p.columns, record)
 		}
 
-		users := p.transformData(rows)
-		for _, user := range users {
-			jobs <- user
+		user, err := p.transformRow(record)
+		if err == nil {
+			if p.stats != nil {
+				p.stats.observeUser(user)
+			}
+			err = p.validate(user)
+		}
+		if err != nil {
+			p.tracker.Done(row)
+			p.reject(record, err)
+			continue
+		}
+
+		p.summary.update(func(s *RunSummary) { s.Valid++ })
+		if p.dryRun {
+			p.tracker.Done(row)
+			continue
+		}
+		if err := batch.add(row, user); err != nil {
+			p.tracker.Done(row)
+			p.reject(record, err)
 		}
 	}
 
+	batch.flush()
 	close(jobs) // Close the channel after sending all jobs
 	wg.Wait()   // Wait for workers to complete
 
-	return nil
+	close(stopMonitor)
+	<-monitorDone
+
+	if err := p.saveCheckpoint(); err != nil {
+		return errors.Join(readErr, err)
+	}
+	return readErr
 }
 
-// Reads CSV data and returns valid and invalid rows
-func (p *Producer) readCSV() ([][]string, []string, error) {
-	return csvutils.ReadRows(p.csvReader, 1) // Read one row at a time
+// Summary returns the counts of the current or last run
+func (p *Producer) Summary() RunSummary {
+	return p.summary.snapshot()
 }
 
-// Worker function to process messages concurrently
-func (p *Producer) worker(jobs <-chan *models.UserDetails, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for user := range jobs {
-		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
-		err := p.publishMessage(ctx, user)
-		cancel()
+// Report returns the dry-run statistics of the current or last run; it is only populated in dry-run mode
+func (p *Producer) Report() IngestionReport {
+	if p.stats == nil {
+		return IngestionReport{RunSummary: p.Summary()}
+	}
+	return p.stats.report(p.Summary())
+}
 
-		if err != nil {
-			p.logger.Error("Failed to publish message", zap.Error(err))
-		}
+// validate runs the business rules, counting every violation and rejecting the row on the first one
+func (p *Producer) validate(user *models.UserDetails) error {
+	if p.validator == nil {
+		return nil
+	}
+
+	violations := p.validator.Validate(user)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	messages := make([]string, 0, len(violations))
+	for _, v := range violations {
+		p.summary.violated(v.Rule)
+		messages = append(messages, v.Message)
+	}
+
+	return &rejects.Error{
+		Reason: rejects.ReasonValidation,
+		Field:  violations[0].Field,
+		Rule:   violations[0].Rule,
+		Err:    errors.New(strings.Join(messages, "; ")),
 	}
 }
 
-// Serializes user data and publishes it to the message broker
-func (p *Producer) publishMessage(ctx context.Context, user *models.UserDetails) error {
-	jsonData, err := json.Marshal(user)
-	if err != nil {
-		return err
+// reject logs a row that cannot be published and records it in the rejects file
+func (p *Producer) reject(record []string, err error) {
+	line := p.source.Line()
+	p.logger.Warn("Invalid rows encountered", zap.Error(err), zap.Any("data", record), zap.Int64("line", line))
+
+	r := rejects.Reject{Line: line, Reason: rejects.ReasonMalformed, Detail: err.Error(), Raw: record}
+	var rowErr *rejects.Error
+	if errors.As(err, &rowErr) {
+		r.Reason = rowErr.Reason
+		r.Field = rowErr.Field
+		r.Rule = rowErr.Rule
+		r.Detail = rowErr.Err.Error()
+	}
+	p.summary.rejected(string(r.Reason))
+
+	if p.rejects == nil {
+		return
+	}
+
+	if err := p.rejects.Write(r); err != nil {
+		p.logger.Error("Failed to write rejected row", zap.Error(err), zap.Int64("line", line))
 	}
-	return p.broker.Publish(ctx, jsonData)
 }
 
-// Transforms CSV rows into structured user details
-func (p *Producer) transformData(data [][]string) []*models.UserDetails {
-	var result []*models.UserDetails
-	for _, row := range data {
-		if len(row) != 8 {
-			p.logger.Warn("Skipping incomplete row", zap.Any("row", row))
-			continue
+// resume returns the last row handled by a previous run of the same file, skipping past it in the reader
+func (p *Producer) resume(ctx context.Context) (int64, error) {
+	if p.checkpoints == nil || p.forceRerun || p.dryRun {
+		return 0, nil
+	}
+
+	cp, ok, err := p.checkpoints.Load(p.filePath, p.fingerprint)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || cp.LastRow == 0 {
+		return 0, nil
+	}
+
+	var skipped int64
+	for skipped < cp.LastRow {
+		if err := ctx.Err(); err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrInterrupted, err)
+		}
+		_, err := p.source.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, recordsource.ErrMalformedRecord) {
+			return 0, err
 		}
+		// malformed records still occupy a position in the input.
+		skipped++
+	}
 
-		result = append(result, &models.UserDetails{
-			ID:           parseInt64(row[0]),
-			FirstName:    row[1],
-			LastName:     row[2],
-			EmailAddress: row[3],
-			CreatedAt:    parseNullTime(row[4]),
-			DeletedAt:    parseNullTime(row[5]),
-			MergedAt:     parseNullTime(row[6]),
-			ParentUserId: parseInt64(row[7]),
-		})
+	p.logger.Info("Resuming from checkpoint", zap.String("file", p.filePath), zap.Int64("row", skipped))
+	return skipped, nil
+}
+
+// trimRejects drops the rejects a previous run recorded past the resumed row, which this run records
+// again; a run that does not resume starts from an empty rejects file
+func (p *Producer) trimRejects(row int64) error {
+	trimmer, ok := p.rejects.(rejects.Trimmer)
+	if !ok {
+		return nil
 	}
-	return result
+	var line int64
+	if row > 0 {
+		line = p.source.Line()
+	}
+	return trimmer.TrimAfter(line)
 }
 
-// Converts string to int64, returning 0 if parsing fails
-func parseInt64(value string) int64 {
-	n, err := strconv.ParseInt(value, 10, 64)
-	if err != nil {
-		return 0
+// monitor persists the checkpoint and logs input progress on a fixed interval until stop is closed
+func (p *Producer) monitor(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	progress, hasProgress := p.source.(ProgressReporter)
+	if p.checkpoints == nil && !hasProgress {
+		return
+	}
+
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := p.saveCheckpoint(); err != nil {
+				p.logger.Warn("Failed to save checkpoint", zap.Error(err))
+			}
+			if hasProgress {
+				p.logProgress(progress)
+			}
+		}
 	}
-	return n
 }
 
-// Converts timestamp string to sql.NullTime, handling invalid cases
-func parseNullTime(value string) sql.NullTime {
-	if value == "-1" {
-		return sql.NullTime{Valid: false}
+// logProgress reports how much of the input file has been consumed, measured in on-disk bytes
+func (p *Producer) logProgress(progress ProgressReporter) {
+	read, total := progress.Progress()
+	fields := []zap.Field{zap.Int64("bytes_read", read), zap.Int64("bytes_total", total), zap.Int64("rows_done", p.tracker.Last())}
+	if total > 0 {
+		fields = append(fields, zap.Float64("percent", float64(read)*100/float64(total)))
 	}
-	n, err := strconv.ParseInt(value, 10, 64)
-	if err != nil {
-		return sql.NullTime{Valid: false}
+	p.logger.Info("Producer progress", fields...)
+}
+
+// saveCheckpoint records the last contiguous row that was successfully handled
+func (p *Producer) saveCheckpoint() error {
+	if p.checkpoints == nil || p.dryRun {
+		return nil
 	}
-	return sql.NullTime{Time: time.UnixMilli(n), Valid: true}
+	return p.checkpoints.Save(checkpoint.Checkpoint{
+		FilePath:    p.filePath,
+		Fingerprint: p.fingerprint,
+		LastRow:     p.tracker.Last(),
+	})
 }
 
-// Closes the producer by shutting down the message broker connection
+// Worker function to process messages concurrently
+func (p *Producer) worker(jobs <-chan job, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for j := range jobs {
+		ctx, cancel := context.WithTimeout(context.Background(), p.publishTimeout)
+		err := p.broker.Publish(ctx, j.body)
+		cancel()
+
+		count := int64(len(j.rows))
+		if err != nil {
+			// the broker has already retried; these rows stay behind the checkpoint for the next run
+			p.summary.update(func(s *RunSummary) {
+				s.Failed += count
+				s.FailedMessages++
+			})
+			p.logger.Error("Failed to publish message", zap.Error(err), zap.Int64("first_row", j.rows[0]), zap.Int64("users", count))
+			continue
+		}
+		p.summary.update(func(s *RunSummary) {
+			s.Published += count
+			s.Messages++
+		})
+		for _, row := range j.rows {
+			p.tracker.Done(row)
+		}
+	}
+}
+
+// Closes the producer by shutting down the input source and the message broker connection
 func (p *Producer) Close() error {
-	return p.broker.Close()
+	var err error
+	if p.source != nil {
+		err = p.source.Close()
+	}
+	if p.broker != nil {
+		err = errors.Join(err, p.broker.Close())
+	}
+	return err
 }
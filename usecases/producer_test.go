@@ -2,25 +2,46 @@ package usecases
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"github.com/viswals_backend_task/pkg/checkpoint"
+	"github.com/viswals_backend_task/pkg/mapping"
 	"github.com/viswals_backend_task/pkg/models"
 	"github.com/viswals_backend_task/pkg/rabbitmq/mockrabbitmq"
+	"github.com/viswals_backend_task/pkg/recordsource"
+	"github.com/viswals_backend_task/pkg/rejects"
+	"github.com/viswals_backend_task/pkg/timeparse"
+	"github.com/viswals_backend_task/pkg/validation"
+	"github.com/xuri/excelize/v2"
 	"go.uber.org/zap"
 )
 
+// millis returns a valid sql.NullTime for a Unix millisecond timestamp.
+func millis(ms int64) sql.NullTime {
+	return sql.NullTime{Time: time.UnixMilli(ms), Valid: true}
+}
+
 // TestProducer_Start tests the producer's Start method.
 func TestProducer_Start(t *testing.T) {
 	// Mock CSV input
 	csvData := `1,John,Doe,john@example.com,1622548800000,-1,-1,0
 2,Jane,Doe,jane@example.com,1622548800000,-1,-1,1`
 
-	reader := csv.NewReader(bytes.NewReader([]byte(csvData)))
+	reader := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
 
 	// Initialize mock broker
 	mockBroker := new(mockrabbitmq.MockRabbitMQ)
@@ -36,9 +57,9 @@ func TestProducer_Start(t *testing.T) {
 			FirstName:    "John",
 			LastName:     "Doe",
 			EmailAddress: "john@example.com",
-			CreatedAt:    parseNullTime("1622548800000"),
-			DeletedAt:    parseNullTime("-1"),
-			MergedAt:     parseNullTime("-1"),
+			CreatedAt:    millis(1622548800000),
+			DeletedAt:    sql.NullTime{},
+			MergedAt:     sql.NullTime{},
 			ParentUserId: 0,
 		},
 		{
@@ -46,9 +67,9 @@ func TestProducer_Start(t *testing.T) {
 			FirstName:    "Jane",
 			LastName:     "Doe",
 			EmailAddress: "jane@example.com",
-			CreatedAt:    parseNullTime("1622548800000"),
-			DeletedAt:    parseNullTime("-1"),
-			MergedAt:     parseNullTime("-1"),
+			CreatedAt:    millis(1622548800000),
+			DeletedAt:    sql.NullTime{},
+			MergedAt:     sql.NullTime{},
 			ParentUserId: 1,
 		},
 	}
@@ -60,7 +81,7 @@ func TestProducer_Start(t *testing.T) {
 	}
 
 	// Run the producer
-	err := producer.Start()
+	err := producer.Start(context.Background())
 	require.NoError(t, err)
 
 	// Verify all expectations were met
@@ -70,7 +91,7 @@ func TestProducer_Start(t *testing.T) {
 // TestProducer_Start_Error tests the producer's handling of publish errors.
 func TestProducer_Start_Error(t *testing.T) {
 	csvData := `1,John,Doe,john@example.com,1622548800000,-1,-1,0`
-	reader := csv.NewReader(bytes.NewReader([]byte(csvData)))
+	reader := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
 
 	mockBroker := new(mockrabbitmq.MockRabbitMQ)
 	logger := zap.NewNop()
@@ -80,13 +101,619 @@ func TestProducer_Start_Error(t *testing.T) {
 	// Simulate publish failure
 	mockBroker.On("Publish", mock.Anything, mock.Anything).Return(errors.New("publish error"))
 
-	err := producer.Start()
+	err := producer.Start(context.Background())
 	require.NoError(t, err) // Producer should handle errors internally
 
 	// Verify Publish was called at least once
 	mockBroker.AssertCalled(t, "Publish", mock.Anything, mock.Anything)
 }
 
+// TestProducer_Start_ResumesFromCheckpoint tests that rows before the stored checkpoint are not republished.
+func TestProducer_Start_ResumesFromCheckpoint(t *testing.T) {
+	csvData := `1,John,Doe,john@example.com,1622548800000,-1,-1,0
+2,Jane,Doe,jane@example.com,1622548800000,-1,-1,1`
+
+	store := checkpoint.NewStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	require.NoError(t, store.Save(checkpoint.Checkpoint{FilePath: "users.csv", Fingerprint: "abc", LastRow: 1}))
+
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	jane, _ := json.Marshal(&models.UserDetails{
+		ID:           2,
+		FirstName:    "Jane",
+		LastName:     "Doe",
+		EmailAddress: "jane@example.com",
+		CreatedAt:    millis(1622548800000),
+		DeletedAt:    sql.NullTime{},
+		MergedAt:     sql.NullTime{},
+		ParentUserId: 1,
+	})
+	mockBroker.On("Publish", mock.Anything, jane).Return(nil).Once()
+
+	reader := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
+	producer := NewProducer(reader, mockBroker, zap.NewNop(), WithCheckpoint(store, "users.csv", "abc"))
+
+	require.NoError(t, producer.Start(context.Background()))
+	mockBroker.AssertExpectations(t)
+
+	cp, ok, err := store.Load("users.csv", "abc")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(2), cp.LastRow)
+
+	// A different fingerprint for the same path must not resume.
+	_, ok, err = store.Load("users.csv", "changed")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+// TestProducer_Start_ResumeTrimsRejects tests that a resumed run keeps the rejects before its checkpoint
+// and records those after it once, while a fresh run starts a new rejects file.
+func TestProducer_Start_ResumeTrimsRejects(t *testing.T) {
+	csvData := `one,John,Doe,john@example.com,1622548800000,-1,-1,0
+2,Jane,Doe,jane@example.com,1622548800000,-1,-1,1
+three,Jim,Doe,jim@example.com,1622548800000,-1,-1,1
+4,Joe,Doe,joe@example.com,1622548800000,-1,-1,1`
+
+	dir := t.TempDir()
+	rejectsFile := filepath.Join(dir, "users.csv.rejects.jsonl")
+	store := checkpoint.NewStore(filepath.Join(dir, "checkpoint.json"))
+
+	run := func() []int64 {
+		w, err := rejects.Open(rejectsFile, nil, false)
+		require.NoError(t, err)
+
+		mockBroker := new(mockrabbitmq.MockRabbitMQ)
+		mockBroker.On("Publish", mock.Anything, mock.Anything).Return(nil)
+		reader := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
+		producer := NewProducer(reader, mockBroker, zap.NewNop(), WithRejects(w), WithCheckpoint(store, "users.csv", "abc"))
+		require.NoError(t, producer.Start(context.Background()))
+		require.NoError(t, w.Close())
+
+		data, err := os.ReadFile(rejectsFile)
+		require.NoError(t, err)
+		var lines []int64
+		for _, raw := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+			var r rejects.Reject
+			require.NoError(t, json.Unmarshal(raw, &r))
+			lines = append(lines, r.Line)
+		}
+		return lines
+	}
+
+	// a previous run left rejects from before and after the row it checkpointed
+	w, err := rejects.Open(rejectsFile, nil, false)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(rejects.Reject{Line: 1, Reason: rejects.ReasonBadInteger}))
+	require.NoError(t, w.Write(rejects.Reject{Line: 3, Reason: rejects.ReasonBadInteger}))
+	require.NoError(t, w.Close())
+	require.NoError(t, store.Save(checkpoint.Checkpoint{FilePath: "users.csv", Fingerprint: "abc", LastRow: 2}))
+
+	require.Equal(t, []int64{1, 3}, run())
+
+	// once the checkpoint belongs to another version of the file, the run starts over
+	require.NoError(t, store.Save(checkpoint.Checkpoint{FilePath: "users.csv", Fingerprint: "other", LastRow: 4}))
+	require.Equal(t, []int64{1, 3}, run())
+}
+
+// TestProducer_Start_CheckpointStopsAtFailedRow tests that a failed publish holds the checkpoint back.
+func TestProducer_Start_CheckpointStopsAtFailedRow(t *testing.T) {
+	csvData := `1,John,Doe,john@example.com,1622548800000,-1,-1,0
+2,Jane,Doe,jane@example.com,1622548800000,-1,-1,1
+3,Jim,Doe,jim@example.com,1622548800000,-1,-1,1`
+
+	store := checkpoint.NewStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	mockBroker.On("Publish", mock.Anything, mock.MatchedBy(func(b []byte) bool {
+		return bytes.Contains(b, []byte(`"id":2`))
+	})).Return(errors.New("publish error"))
+	mockBroker.On("Publish", mock.Anything, mock.Anything).Return(nil)
+
+	reader := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
+	producer := NewProducer(reader, mockBroker, zap.NewNop(),
+		WithCheckpoint(store, "users.csv", "abc"),
+		WithForceRerun(true),
+	)
+
+	require.NoError(t, producer.Start(context.Background()))
+
+	cp, ok, err := store.Load("users.csv", "abc")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(1), cp.LastRow)
+}
+
+// TestProducer_Start_HeaderMapping tests that columns are resolved by header name rather than position.
+func TestProducer_Start_HeaderMapping(t *testing.T) {
+	csvData := `Email,id,last_name,first_name,created_at,source
+john@example.com,1,Doe,John,1622548800000,crm`
+
+	reader, err := recordsource.NewCSV(bytes.NewReader([]byte(csvData)), ',')
+	require.NoError(t, err)
+
+	columns, err := mapping.Resolve(reader.Header(), mapping.DefaultAliases())
+	require.NoError(t, err)
+
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	john, _ := json.Marshal(&models.UserDetails{
+		ID:           1,
+		FirstName:    "John",
+		LastName:     "Doe",
+		EmailAddress: "john@example.com",
+		CreatedAt:    millis(1622548800000),
+	})
+	mockBroker.On("Publish", mock.Anything, john).Return(nil).Once()
+
+	producer := NewProducer(reader, mockBroker, zap.NewNop(), WithColumnMapping(columns))
+	require.NoError(t, producer.Start(context.Background()))
+	mockBroker.AssertExpectations(t)
+}
+
+// TestProducer_Start_InputFormats tests that every input format yields the same user stream.
+func TestProducer_Start_InputFormats(t *testing.T) {
+	header := []string{"id", "first_name", "last_name", "email_address", "created_at", "deleted_at", "merged_at", "parent_user_id"}
+	row := []string{"1", "John", "Doe", "john@example.com", "1622548800000", "-1", "-1", "0"}
+
+	workbook := excelize.NewFile()
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A1", &header))
+	require.NoError(t, workbook.SetSheetRow("Sheet1", "A2", &row))
+	xlsxData, err := workbook.WriteToBuffer()
+	require.NoError(t, err)
+
+	inputs := map[recordsource.Format][]byte{
+		recordsource.FormatCSV: []byte("id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id\n" +
+			"1,John,Doe,john@example.com,1622548800000,-1,-1,0\n"),
+		recordsource.FormatTSV: []byte("email\tid\tfirst_name\tlast_name\tcreated_at\n" +
+			"john@example.com\t1\tJohn\tDoe\t1622548800000\n"),
+		recordsource.FormatJSONL: []byte(`{"id":1,"first_name":"John","last_name":"Doe","email":"john@example.com","created_at":1622548800000,"deleted_at":null}` + "\n"),
+		recordsource.FormatXLSX:  xlsxData.Bytes(),
+	}
+
+	expected, _ := json.Marshal(&models.UserDetails{
+		ID:           1,
+		FirstName:    "John",
+		LastName:     "Doe",
+		EmailAddress: "john@example.com",
+		CreatedAt:    millis(1622548800000),
+	})
+
+	for format, data := range inputs {
+		t.Run(string(format), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users."+string(format))
+			require.NoError(t, os.WriteFile(path, data, 0o600))
+
+			source, err := recordsource.Open(path, recordsource.Options{})
+			require.NoError(t, err)
+			defer source.Close()
+
+			columns, err := mapping.Resolve(source.Header(), mapping.DefaultAliases())
+			require.NoError(t, err)
+
+			mockBroker := new(mockrabbitmq.MockRabbitMQ)
+			mockBroker.On("Publish", mock.Anything, expected).Return(nil).Once()
+
+			producer := NewProducer(source, mockBroker, zap.NewNop(), WithColumnMapping(columns))
+			require.NoError(t, producer.Start(context.Background()))
+			mockBroker.AssertExpectations(t)
+		})
+	}
+}
+
+// TestProducer_Start_CompressedInput tests that compressed inputs are detected by magic bytes, not by name.
+func TestProducer_Start_CompressedInput(t *testing.T) {
+	csvData := []byte("id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id\n" +
+		"1,John,Doe,john@example.com,1622548800000,-1,-1,0\n")
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, err := gz.Write(csvData)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	zw, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	zstdData := zw.EncodeAll(csvData, nil)
+
+	// bzip2 of csvData; the standard library only ships a bzip2 decoder.
+	bzip2Data, err := hex.DecodeString("425a6839314159265359826a55bb00002cdf8020100007774044100000aff7de40200070632626984d3130134c035320d14da693c50d34f534f123684683e58ef8dde40c760703213888b27b4ca293920e64ac6cade883acbdaaafad2e16ab2f5d32509485dc8ce10d2cd5c04bdc6eed8a7dde4e0c2c08067ebe72f9b970aaf5af4b6d094c1c1b245fc5dc914e1424209a956ec0")
+	require.NoError(t, err)
+
+	inputs := map[recordsource.Compression][]byte{
+		recordsource.CompressionGzip:  gzipped.Bytes(),
+		recordsource.CompressionZstd:  zstdData,
+		recordsource.CompressionBzip2: bzip2Data,
+	}
+
+	expected, _ := json.Marshal(&models.UserDetails{
+		ID:           1,
+		FirstName:    "John",
+		LastName:     "Doe",
+		EmailAddress: "john@example.com",
+		CreatedAt:    millis(1622548800000),
+		DeletedAt:    sql.NullTime{},
+		MergedAt:     sql.NullTime{},
+	})
+
+	for compression, data := range inputs {
+		t.Run(string(compression), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.csv.gz")
+			require.NoError(t, os.WriteFile(path, data, 0o600))
+
+			source, err := recordsource.Open(path, recordsource.Options{})
+			require.NoError(t, err)
+			defer source.Close()
+			require.Equal(t, compression, source.Compression())
+
+			mockBroker := new(mockrabbitmq.MockRabbitMQ)
+			mockBroker.On("Publish", mock.Anything, expected).Return(nil).Once()
+
+			producer := NewProducer(source, mockBroker, zap.NewNop())
+			require.NoError(t, producer.Start(context.Background()))
+			mockBroker.AssertExpectations(t)
+
+			read, total := source.Progress()
+			require.Equal(t, int64(len(data)), read)
+			require.Equal(t, int64(len(data)), total)
+		})
+	}
+}
+
+// TestProducer_Start_Rejects tests that unusable rows are written to the rejects file with a reason code.
+func TestProducer_Start_Rejects(t *testing.T) {
+	csvData := `id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id
+1,John,Doe,john@example.com,1622548800000,-1,-1,0
+x2,Jane,Doe,jane@example.com,1622548800000,-1,-1,1
+3,Jim,Doe,jim@example.com,yesterday,-1,-1,1
+4,Joe,Doe,not-an-email,1622548800000,-1,-1,1
+5,Short,Row
+`
+	source, err := recordsource.NewCSV(bytes.NewReader([]byte(csvData)), ',')
+	require.NoError(t, err)
+
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	mockBroker.On("Publish", mock.Anything, mock.Anything).Return(nil).Once()
+
+	var out bytes.Buffer
+	producer := NewProducer(source, mockBroker, zap.NewNop(), WithRejects(rejects.NewJSONLWriter(&out)))
+	require.NoError(t, producer.Start(context.Background()))
+	mockBroker.AssertExpectations(t)
+
+	var got []rejects.Reject
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var r rejects.Reject
+		require.NoError(t, dec.Decode(&r))
+		got = append(got, r)
+	}
+
+	require.Len(t, got, 4)
+	require.Equal(t, int64(3), got[0].Line)
+	require.Equal(t, rejects.ReasonBadInteger, got[0].Reason)
+	require.Equal(t, "id", got[0].Field)
+	require.Equal(t, "x2", got[0].Raw[0])
+	require.Equal(t, rejects.ReasonBadTimestamp, got[1].Reason)
+	require.Equal(t, "created_at", got[1].Field)
+	require.Equal(t, rejects.ReasonBadEmail, got[2].Reason)
+	require.Equal(t, int64(6), got[3].Line)
+	require.Equal(t, rejects.ReasonFieldCount, got[3].Reason)
+	require.Equal(t, []string{"5", "Short", "Row"}, got[3].Raw)
+}
+
+// TestProducer_Start_Validation tests that rows failing business rules are rejected and counted per rule.
+func TestProducer_Start_Validation(t *testing.T) {
+	csvData := `id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id
+1,John,Doe,john@example.com,1622548800000,-1,-1,0
+2,Jane,Doe,jane@@example.com,1622548800000,-1,-1,1
+3,Jim,Doe,jim@example.com,1622548800000,1622548700000,-1,3
+4,Joe,Doe,joe@example.com,1622548800000,-1,-1,4
+`
+	rulesFile := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(rulesFile, []byte(`rules:
+  - name: deleted_after_created
+    type: not_before
+    field: deleted_at
+    other: created_at
+  - name: parent_not_self
+    type: not_equal
+    field: parent_user_id
+    other: id
+  - name: created_not_future
+    type: not_future
+    field: created_at
+    tolerance: 5m
+`), 0o600))
+
+	cfg, err := validation.LoadConfig(rulesFile)
+	require.NoError(t, err)
+	validator, err := validation.New(cfg)
+	require.NoError(t, err)
+
+	source, err := recordsource.NewCSV(bytes.NewReader([]byte(csvData)), ',')
+	require.NoError(t, err)
+
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	mockBroker.On("Publish", mock.Anything, mock.Anything).Return(nil).Once()
+
+	var out bytes.Buffer
+	producer := NewProducer(source, mockBroker, zap.NewNop(),
+		WithRejects(rejects.NewJSONLWriter(&out)),
+		WithValidator(validator),
+	)
+	require.NoError(t, producer.Start(context.Background()))
+	mockBroker.AssertExpectations(t)
+
+	summary := producer.Summary()
+	require.Equal(t, int64(4), summary.RowsRead)
+	require.Equal(t, int64(1), summary.Published)
+	require.Equal(t, int64(3), summary.Rejected)
+	require.Equal(t, int64(1), summary.RejectReasons[string(rejects.ReasonBadEmail)])
+	require.Equal(t, int64(2), summary.RejectReasons[string(rejects.ReasonValidation)])
+	require.Equal(t, map[string]int64{"deleted_after_created": 1, "parent_not_self": 2}, summary.RuleViolations)
+
+	require.Contains(t, out.String(), `"rule":"deleted_after_created"`)
+}
+
+// TestValidation_DefaultRules tests the built-in rule set.
+func TestValidation_DefaultRules(t *testing.T) {
+	validator, err := validation.New(validation.DefaultConfig())
+	require.NoError(t, err)
+
+	user := &models.UserDetails{
+		ID:           7,
+		EmailAddress: "John Doe <john@example.com>",
+		CreatedAt:    sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+		MergedAt:     millis(1622548800000),
+		ParentUserId: 7,
+	}
+
+	var rules []string
+	for _, v := range validator.Validate(user) {
+		rules = append(rules, v.Rule)
+	}
+	require.Equal(t, []string{"email_syntax", "created_at_not_future", "merged_at_after_created_at", "parent_not_self"}, rules)
+}
+
+// TestProducer_Start_DryRun tests that a dry run reports statistics without publishing.
+func TestProducer_Start_DryRun(t *testing.T) {
+	csvData := `id,first_name,last_name,email_address,created_at,deleted_at,merged_at,parent_user_id
+1,John,Doe,john@example.com,1622548800000,-1,-1,0
+2,Jane,,jane@example.com,1622548900000,1622549000000,-1,1
+2,Jane,Doe,jane@example.com,1622548700000,-1,-1,1
+3,Jim,Doe,jim@example,1622548800000,-1,-1,1
+`
+	source, err := recordsource.NewCSV(bytes.NewReader([]byte(csvData)), ',')
+	require.NoError(t, err)
+
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	producer := NewProducer(source, mockBroker, zap.NewNop(), WithDryRun(true))
+	require.NoError(t, producer.Start(context.Background()))
+	mockBroker.AssertNotCalled(t, "Publish", mock.Anything, mock.Anything)
+
+	report := producer.Report()
+	require.Equal(t, int64(4), report.RowsRead)
+	require.Equal(t, int64(3), report.Valid)
+	require.Equal(t, int64(0), report.Published)
+	require.Equal(t, int64(1), report.RejectReasons[string(rejects.ReasonBadEmail)])
+	require.Equal(t, int64(1), report.DuplicateIDs)
+	require.Equal(t, []DuplicateID{{ID: 2, Occurrences: 2}}, report.DuplicateSamples)
+	require.Equal(t, 0.25, report.NullRates["last_name"])
+	require.Equal(t, 0.75, report.NullRates["deleted_at"])
+	require.Equal(t, 1.0, report.NullRates["merged_at"])
+
+	created := report.TimestampRanges["created_at"]
+	require.True(t, created.Min.Equal(time.UnixMilli(1622548700000)))
+	require.True(t, created.Max.Equal(time.UnixMilli(1622548900000)))
+	require.Nil(t, report.TimestampRanges["merged_at"].Min)
+
+	var text bytes.Buffer
+	require.NoError(t, report.WriteText(&text))
+	require.Contains(t, text.String(), "rows rejected")
+}
+
+// TestProducer_Start_Batching tests that users are packed into batch envelopes bounded by count and size.
+func TestProducer_Start_Batching(t *testing.T) {
+	csvData := `1,John,Doe,john@example.com,1622548800000,-1,-1,0
+2,Jane,Doe,jane@example.com,1622548800000,-1,-1,1
+3,Jim,Doe,jim@example.com,1622548800000,-1,-1,1`
+
+	decode := func(b []byte) []*models.UserDetails {
+		users, err := decodeUsers(b)
+		require.NoError(t, err)
+		return users
+	}
+
+	t.Run("batch size", func(t *testing.T) {
+		var published [][]*models.UserDetails
+		mockBroker := new(mockrabbitmq.MockRabbitMQ)
+		mockBroker.On("Publish", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			published = append(published, decode(args.Get(1).([]byte)))
+		}).Return(nil)
+
+		store := checkpoint.NewStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+		reader := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
+		producer := NewProducer(reader, mockBroker, zap.NewNop(),
+			WithBatchSize(2),
+			WithCheckpoint(store, "users.csv", "abc"),
+		)
+		require.NoError(t, producer.Start(context.Background()))
+
+		// one worker per batch may publish in any order
+		require.Len(t, published, 2)
+		require.ElementsMatch(t, []int{2, 1}, []int{len(published[0]), len(published[1])})
+		require.Equal(t, int64(3), producer.Summary().Published)
+		require.Equal(t, int64(2), producer.Summary().Messages)
+
+		cp, ok, err := store.Load("users.csv", "abc")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, int64(3), cp.LastRow)
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		one, _ := json.Marshal(&models.UserDetails{ID: 1, FirstName: "John", LastName: "Doe", EmailAddress: "john@example.com", CreatedAt: millis(1622548800000)})
+
+		mockBroker := new(mockrabbitmq.MockRabbitMQ)
+		mockBroker.On("Publish", mock.Anything, mock.MatchedBy(func(b []byte) bool {
+			return len(decode(b)) == 1 && b[0] == '['
+		})).Return(nil).Times(3)
+
+		reader := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
+		producer := NewProducer(reader, mockBroker, zap.NewNop(),
+			WithBatchSize(10),
+			WithMaxBatchBytes(len(one)+2),
+		)
+		require.NoError(t, producer.Start(context.Background()))
+		mockBroker.AssertExpectations(t)
+	})
+
+	t.Run("failed batch", func(t *testing.T) {
+		mockBroker := new(mockrabbitmq.MockRabbitMQ)
+		mockBroker.On("Publish", mock.Anything, mock.Anything).Return(errors.New("publish error"))
+
+		reader := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
+		producer := NewProducer(reader, mockBroker, zap.NewNop(), WithBatchSize(3))
+		require.NoError(t, producer.Start(context.Background()))
+
+		summary := producer.Summary()
+		require.Equal(t, int64(3), summary.Failed)
+		require.Equal(t, int64(1), summary.FailedMessages)
+		require.Equal(t, int64(0), summary.Published)
+		mockBroker.AssertNumberOfCalls(t, "Publish", 1)
+	})
+}
+
+// TestParseNullTime_Formats tests each timestamp format, including unit auto-detection.
+func TestParseNullTime_Formats(t *testing.T) {
+	want := time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		format string
+		value  string
+		valid  bool
+	}{
+		{format: "unix_s", value: "1612137600", valid: true},
+		{format: "unix_ms", value: "1612137600000", valid: true},
+		{format: "unix_us", value: "1612137600000000", valid: true},
+		{format: "rfc3339", value: "2021-02-01T00:00:00Z", valid: true},
+		{format: "layout:02/01/2006 15:04", value: "01/02/2021 00:00", valid: true},
+		{format: "auto", value: "1612137600", valid: true},
+		{format: "auto", value: "1612137600000", valid: true},
+		{format: "auto", value: "1612137600000000", valid: true},
+		{format: "auto", value: "2021-02-01T00:00:00Z", valid: true},
+		{format: "auto", value: "2021-02-01", valid: true},
+		{format: "auto", value: "-1", valid: false},
+		{format: "unix_s", value: "", valid: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format+"/"+tc.value, func(t *testing.T) {
+			parser, err := timeparse.New(tc.format, nil)
+			require.NoError(t, err)
+
+			got, err := parseNullTime(parser, tc.value)
+			require.NoError(t, err)
+			require.Equal(t, tc.valid, got.Valid)
+			if tc.valid {
+				require.True(t, got.Time.Equal(want), "got %s", got.Time)
+			}
+		})
+	}
+
+	parser, err := timeparse.New("unix_s", nil)
+	require.NoError(t, err)
+	_, err = parseNullTime(parser, "2021-02-01")
+	require.Error(t, err)
+
+	_, err = timeparse.New("fortnights", nil)
+	require.Error(t, err)
+}
+
+// TestProducer_Start_TimestampFormats tests per-column formats and configurable null values.
+func TestProducer_Start_TimestampFormats(t *testing.T) {
+	csvData := `1,John,Doe,john@example.com,1612137600,NULL,2021-02-02T00:00:00Z,0
+2,Jane,Doe,jane@example.com,1612137600,none,NULL,1`
+
+	seconds, err := timeparse.New("unix_s", []string{"NULL"})
+	require.NoError(t, err)
+	rfc3339, err := timeparse.New("rfc3339", []string{"NULL"})
+	require.NoError(t, err)
+
+	var published []*models.UserDetails
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	mockBroker.On("Publish", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		users, err := decodeUsers(args.Get(1).([]byte))
+		require.NoError(t, err)
+		published = append(published, users...)
+	}).Return(nil)
+
+	var rejected bytes.Buffer
+	reader := recordsource.FromCSVReader(csv.NewReader(bytes.NewReader([]byte(csvData))), nil)
+	producer := NewProducer(reader, mockBroker, zap.NewNop(),
+		WithBatchSize(2),
+		WithRejects(rejects.NewJSONLWriter(&rejected)),
+		WithTimestampFormats(&timeparse.Columns{
+			Default: seconds,
+			Fields:  map[string]*timeparse.Parser{mapping.FieldMergedAt: rfc3339},
+		}),
+	)
+	require.NoError(t, producer.Start(context.Background()))
+
+	// "none" is not one of the configured null values, so it is an invalid timestamp.
+	require.Len(t, published, 1)
+	require.True(t, published[0].CreatedAt.Time.Equal(time.Unix(1612137600, 0)))
+	require.False(t, published[0].DeletedAt.Valid)
+	require.True(t, published[0].MergedAt.Time.Equal(time.Date(2021, 2, 2, 0, 0, 0, 0, time.UTC)))
+	require.Contains(t, rejected.String(), `"reason":"bad_timestamp","field":"deleted_at"`)
+}
+
+// endlessSource yields valid user rows until it is closed, like a file far larger than the test.
+type endlessSource struct {
+	row int64
+}
+
+func (s *endlessSource) Header() []string { return nil }
+func (s *endlessSource) Line() int64      { return s.row }
+func (s *endlessSource) Close() error     { return nil }
+
+func (s *endlessSource) Next() ([]string, error) {
+	s.row++
+	return []string{strconv.FormatInt(s.row, 10), "John", "Doe", "john@example.com", "1622548800000", "-1", "-1", "0"}, nil
+}
+
+// TestProducer_Start_Interrupted tests that cancelling the context drains every row read and saves the checkpoint.
+func TestProducer_Start_Interrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mockBroker := new(mockrabbitmq.MockRabbitMQ)
+	mockBroker.On("Publish", mock.Anything, mock.Anything).Run(func(mock.Arguments) { cancel() }).Return(nil)
+
+	store := checkpoint.NewStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	producer := NewProducer(&endlessSource{}, mockBroker, zap.NewNop(),
+		WithBatchSize(3),
+		WithCheckpoint(store, "users.csv", "abc"),
+	)
+
+	err := producer.Start(ctx)
+	require.ErrorIs(t, err, ErrInterrupted)
+	require.ErrorIs(t, err, context.Canceled)
+
+	summary := producer.Summary()
+	require.Positive(t, summary.RowsRead)
+	require.Equal(t, summary.RowsRead, summary.Published)
+
+	cp, ok, err := store.Load("users.csv", "abc")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, summary.RowsRead, cp.LastRow)
+}
+
+// TestResolve_MissingRequiredColumn tests that a header without a required column is rejected up front.
+func TestResolve_MissingRequiredColumn(t *testing.T) {
+	_, err := mapping.Resolve([]string{"id", "first_name", "last_name", "created_at"}, mapping.DefaultAliases())
+	require.ErrorContains(t, err, "missing required columns email_address")
+}
+
 // TestProducer_Close tests the Close method.
 func TestProducer_Close(t *testing.T) {
 	mockBroker := new(mockrabbitmq.MockRabbitMQ)
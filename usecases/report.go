@@ -0,0 +1,210 @@
+package usecases
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/viswals_backend_task/pkg/mapping"
+	"github.com/viswals_backend_task/pkg/models"
+	"github.com/viswals_backend_task/pkg/timeparse"
+)
+
+// maxDuplicateSamples caps how many duplicated IDs are listed in a report
+const maxDuplicateSamples = 50
+
+// IngestionReport describes an input file as seen by a dry run of the producer
+type IngestionReport struct {
+	RunSummary
+	DuplicateIDs     int64                `json:"duplicate_ids"`
+	DuplicateRows    int64                `json:"duplicate_rows"`
+	DuplicateSamples []DuplicateID        `json:"duplicate_samples,omitempty"`
+	NullRates        map[string]float64   `json:"null_rates"`
+	TimestampRanges  map[string]TimeRange `json:"timestamp_ranges"`
+}
+
+// DuplicateID is an ID found on more than one row
+type DuplicateID struct {
+	ID          int64 `json:"id"`
+	Occurrences int64 `json:"occurrences"`
+}
+
+// TimeRange is the earliest and latest value of a timestamp column
+type TimeRange struct {
+	Min *time.Time `json:"min,omitempty"`
+	Max *time.Time `json:"max,omitempty"`
+}
+
+// statsCollector gathers per-column statistics for the ingestion report
+type statsCollector struct {
+	mu         sync.Mutex
+	timestamps *timeparse.Columns
+	rows       int64
+	nulls      map[string]int64
+	ids        map[int64]int64
+	minTimes   map[string]time.Time
+	maxTimes   map[string]time.Time
+}
+
+func newStatsCollector(timestamps *timeparse.Columns) *statsCollector {
+	return &statsCollector{
+		timestamps: timestamps,
+		nulls:      make(map[string]int64),
+		ids:        make(map[int64]int64),
+		minTimes:   make(map[string]time.Time),
+		maxTimes:   make(map[string]time.Time),
+	}
+}
+
+// observeRecord counts empty and null values of every mapped column
+func (s *statsCollector) observeRecord(columns *mapping.Mapping, record []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rows++
+	for _, field := range mapping.Fields {
+		if s.isNullValue(field, columns.Value(record, field)) {
+			s.nulls[field]++
+		}
+	}
+}
+
+// observeUser tracks IDs and timestamp ranges of a parsed user
+func (s *statsCollector) observeUser(user *models.UserDetails) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ids[user.ID]++
+	s.observeTime(mapping.FieldCreatedAt, user.CreatedAt.Time, user.CreatedAt.Valid)
+	s.observeTime(mapping.FieldDeletedAt, user.DeletedAt.Time, user.DeletedAt.Valid)
+	s.observeTime(mapping.FieldMergedAt, user.MergedAt.Time, user.MergedAt.Valid)
+}
+
+func (s *statsCollector) observeTime(field string, t time.Time, valid bool) {
+	if !valid {
+		return
+	}
+	if min, ok := s.minTimes[field]; !ok || t.Before(min) {
+		s.minTimes[field] = t
+	}
+	if max, ok := s.maxTimes[field]; !ok || t.After(max) {
+		s.maxTimes[field] = t
+	}
+}
+
+// report combines the collected statistics with the run summary
+func (s *statsCollector) report(summary RunSummary) IngestionReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := IngestionReport{
+		RunSummary:      summary,
+		NullRates:       make(map[string]float64, len(mapping.Fields)),
+		TimestampRanges: make(map[string]TimeRange),
+	}
+
+	for _, field := range mapping.Fields {
+		if s.rows > 0 {
+			r.NullRates[field] = float64(s.nulls[field]) / float64(s.rows)
+		}
+	}
+
+	for _, field := range []string{mapping.FieldCreatedAt, mapping.FieldDeletedAt, mapping.FieldMergedAt} {
+		var tr TimeRange
+		if min, ok := s.minTimes[field]; ok {
+			min, max := min.UTC(), s.maxTimes[field].UTC()
+			tr.Min, tr.Max = &min, &max
+		}
+		r.TimestampRanges[field] = tr
+	}
+
+	for id, n := range s.ids {
+		if n > 1 {
+			r.DuplicateIDs++
+			r.DuplicateRows += n
+			r.DuplicateSamples = append(r.DuplicateSamples, DuplicateID{ID: id, Occurrences: n})
+		}
+	}
+	sort.Slice(r.DuplicateSamples, func(i, j int) bool {
+		a, b := r.DuplicateSamples[i], r.DuplicateSamples[j]
+		if a.Occurrences != b.Occurrences {
+			return a.Occurrences > b.Occurrences
+		}
+		return a.ID < b.ID
+	})
+	if len(r.DuplicateSamples) > maxDuplicateSamples {
+		r.DuplicateSamples = r.DuplicateSamples[:maxDuplicateSamples]
+	}
+
+	return r
+}
+
+// isNullValue reports whether a raw column value is empty or a timestamp null sentinel
+func (s *statsCollector) isNullValue(field, value string) bool {
+	if strings.TrimSpace(value) == "" {
+		return true
+	}
+	switch field {
+	case mapping.FieldCreatedAt, mapping.FieldDeletedAt, mapping.FieldMergedAt:
+		return s.timestamps.For(field).IsNull(value)
+	}
+	return false
+}
+
+// WriteText prints a human-readable version of the report
+func (r IngestionReport) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "Ingestion dry run")
+	fmt.Fprintf(tw, "  rows read\t%d\n", r.RowsRead)
+	if r.RowsSkipped > 0 {
+		fmt.Fprintf(tw, "  rows skipped\t%d\n", r.RowsSkipped)
+	}
+	fmt.Fprintf(tw, "  rows valid\t%d\n", r.Valid)
+	fmt.Fprintf(tw, "  rows rejected\t%d\n", r.Rejected)
+	for _, reason := range sortedKeys(r.RejectReasons) {
+		fmt.Fprintf(tw, "    %s\t%d\n", reason, r.RejectReasons[reason])
+	}
+
+	if len(r.RuleViolations) > 0 {
+		fmt.Fprintln(tw, "\nRule violations")
+		for _, rule := range sortedKeys(r.RuleViolations) {
+			fmt.Fprintf(tw, "  %s\t%d\n", rule, r.RuleViolations[rule])
+		}
+	}
+
+	fmt.Fprintf(tw, "\nDuplicate IDs\t%d (%d rows)\n", r.DuplicateIDs, r.DuplicateRows)
+	for _, d := range r.DuplicateSamples {
+		fmt.Fprintf(tw, "  %d\t%d rows\n", d.ID, d.Occurrences)
+	}
+
+	fmt.Fprintln(tw, "\nNull rate")
+	for _, field := range mapping.Fields {
+		fmt.Fprintf(tw, "  %s\t%.2f%%\n", field, r.NullRates[field]*100)
+	}
+
+	fmt.Fprintln(tw, "\nTimestamp range")
+	for _, field := range []string{mapping.FieldCreatedAt, mapping.FieldDeletedAt, mapping.FieldMergedAt} {
+		tr := r.TimestampRanges[field]
+		if tr.Min == nil {
+			fmt.Fprintf(tw, "  %s\t-\n", field)
+			continue
+		}
+		fmt.Fprintf(tw, "  %s\t%s .. %s\n", field, tr.Min.Format(time.RFC3339), tr.Max.Format(time.RFC3339))
+	}
+
+	return tw.Flush()
+}
+
+func sortedKeys(m map[string]int64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
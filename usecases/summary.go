@@ -0,0 +1,73 @@
+package usecases
+
+import (
+	"sync"
+)
+
+// RunSummary counts what happened to the input rows of a producer run. Published and Failed count
+// users; Messages and FailedMessages count the broker messages, which carry a batch of users each.
+type RunSummary struct {
+	RowsRead       int64            `json:"rows_read"`
+	RowsSkipped    int64            `json:"rows_skipped"`
+	Valid          int64            `json:"valid"`
+	Published      int64            `json:"published"`
+	Failed         int64            `json:"failed"`
+	Messages       int64            `json:"messages"`
+	FailedMessages int64            `json:"failed_messages"`
+	Rejected       int64            `json:"rejected"`
+	RejectReasons  map[string]int64 `json:"reject_reasons,omitempty"`
+	RuleViolations map[string]int64 `json:"rule_violations,omitempty"`
+}
+
+// summaryCounter accumulates a RunSummary from the reader and the publish workers
+type summaryCounter struct {
+	mu      sync.Mutex
+	summary RunSummary
+}
+
+func (s *summaryCounter) update(fn func(*RunSummary)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.summary)
+}
+
+func (s *summaryCounter) rejected(reason string) {
+	s.update(func(sum *RunSummary) {
+		sum.Rejected++
+		if sum.RejectReasons == nil {
+			sum.RejectReasons = make(map[string]int64)
+		}
+		sum.RejectReasons[reason]++
+	})
+}
+
+func (s *summaryCounter) violated(rule string) {
+	s.update(func(sum *RunSummary) {
+		if sum.RuleViolations == nil {
+			sum.RuleViolations = make(map[string]int64)
+		}
+		sum.RuleViolations[rule]++
+	})
+}
+
+// snapshot returns a copy that is safe to use while the run continues
+func (s *summaryCounter) snapshot() RunSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := s.summary
+	out.RejectReasons = copyCounts(s.summary.RejectReasons)
+	out.RuleViolations = copyCounts(s.summary.RuleViolations)
+	return out
+}
+
+func copyCounts(in map[string]int64) map[string]int64 {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]int64, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+package usecases
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/viswals_backend_task/pkg/mapping"
+	"github.com/viswals_backend_task/pkg/models"
+	"github.com/viswals_backend_task/pkg/recordsource"
+	"github.com/viswals_backend_task/pkg/rejects"
+	"github.com/viswals_backend_task/pkg/timeparse"
+)
+
+// Transforms a single input row into user details, or explains why the row was rejected
+func (p *Producer) transformRow(row []string) (*models.UserDetails, error) {
+	if len(row) < p.columns.Width() {
+		return nil, &rejects.Error{
+			Reason: rejects.ReasonFieldCount,
+			Err:    fmt.Errorf("expected %d fields, got %d", p.columns.Width(), len(row)),
+		}
+	}
+
+	value := func(field string) string {
+		return p.columns.Value(row, field)
+	}
+
+	var errs []error
+	user := &models.UserDetails{
+		FirstName:    value(mapping.FieldFirstName),
+		LastName:     value(mapping.FieldLastName),
+		EmailAddress: value(mapping.FieldEmailAddress),
+	}
+
+	// collect every field error and report the first one as the reject reason
+	intField := func(field string, required bool) int64 {
+		n, err := parseInt64(value(field), required)
+		if err != nil {
+			errs = append(errs, &rejects.Error{Reason: rejects.ReasonBadInteger, Field: field, Err: err})
+		}
+		return n
+	}
+	timeField := func(field string) sql.NullTime {
+		t, err := parseNullTime(p.timestamps.For(field), value(field))
+		if err != nil {
+			errs = append(errs, &rejects.Error{Reason: rejects.ReasonBadTimestamp, Field: field, Err: err})
+		}
+		return t
+	}
+
+	user.ID = intField(mapping.FieldID, true)
+	if err := checkEmail(user.EmailAddress); err != nil {
+		errs = append(errs, &rejects.Error{Reason: rejects.ReasonBadEmail, Field: mapping.FieldEmailAddress, Err: err})
+	}
+	user.CreatedAt = timeField(mapping.FieldCreatedAt)
+	user.DeletedAt = timeField(mapping.FieldDeletedAt)
+	user.MergedAt = timeField(mapping.FieldMergedAt)
+	user.ParentUserId = intField(mapping.FieldParentUserID, false)
+
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return user, nil
+}
+
+// sourceError classifies an error returned by the record source for the rejects file
+func sourceError(err error) error {
+	if errors.Is(err, recordsource.ErrFieldCount) {
+		return &rejects.Error{Reason: rejects.ReasonFieldCount, Err: err}
+	}
+	return &rejects.Error{Reason: rejects.ReasonMalformed, Err: err}
+}
+
+// Converts string to int64; an empty optional value is 0
+func parseInt64(value string, required bool) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		if required {
+			return 0, errors.New("value is empty")
+		}
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// Converts a timestamp in the column's configured format to sql.NullTime; null values are not valid
+func parseNullTime(parser *timeparse.Parser, value string) (sql.NullTime, error) {
+	t, ok, err := parser.Parse(value)
+	if err != nil || !ok {
+		return sql.NullTime{Valid: false}, err
+	}
+	return sql.NullTime{Time: t, Valid: true}, nil
+}
+
+// checkEmail performs a cheap shape check: a single '@' with a local part and a dotted domain
+func checkEmail(email string) error {
+	local, domain, ok := strings.Cut(strings.TrimSpace(email), "@")
+	switch {
+	case email == "":
+		return errors.New("value is empty")
+	case !ok || local == "" || strings.Contains(domain, "@"):
+		return fmt.Errorf("%q is not an email address", email)
+	case !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, "."):
+		return fmt.Errorf("%q has an invalid domain", email)
+	}
+	return nil
+}